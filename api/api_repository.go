@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"runtime"
@@ -12,11 +13,14 @@ import (
 
 	"github.com/PlakarKorp/kloset/connectors/storage"
 	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/header"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/cached"
+	"github.com/PlakarKorp/plakar/history"
+	"github.com/PlakarKorp/plakar/pathindex"
 )
 
 type RepositoryInfoSnapshots struct {
@@ -260,6 +264,25 @@ type TimelineLocation struct {
 	Entry    vfs.Entry     `json:"vfs_entry"`
 }
 
+// pathCandidates lists the snapshots that may hold resource, skipping the
+// ones the path index knows do not.
+func (ui *uiserver) pathCandidates(resource string) []objects.MAC {
+	var snapshotIDs []objects.MAC
+	for snapshotID, err := range ui.repository.ListSnapshots() {
+		if err != nil {
+			// XXX - temporarily ignore errors in List snapshots iteration, it is safe here
+			continue
+		}
+		snapshotIDs = append(snapshotIDs, snapshotID)
+	}
+
+	index, err := pathindex.Load(ui.ctx.CacheDir, ui.repository.Configuration().RepositoryID)
+	if err != nil {
+		return snapshotIDs
+	}
+	return index.Candidates(snapshotIDs, resource)
+}
+
 func (ui *uiserver) repositoryLocatePathname(w http.ResponseWriter, r *http.Request) error {
 	offset, err := QueryParamToUint32(r, "offset", 0, 0)
 	if err != nil {
@@ -303,11 +326,7 @@ func (ui *uiserver) repositoryLocatePathname(w http.ResponseWriter, r *http.Requ
 
 	totalSnapshots := int(0)
 	locations := make([]TimelineLocation, 0)
-	for snapshotID, err := range ui.repository.ListSnapshots() {
-		if err != nil {
-			// XXX - temporarily ignore errors in List snapshots iteration, it is safe here
-			continue
-		}
+	for _, snapshotID := range ui.pathCandidates(resource) {
 		snap, err := snapshot.Load(ui.repository, snapshotID)
 		if err != nil {
 			return err
@@ -395,3 +414,65 @@ func (ui *uiserver) repositoryLocatePathname(w http.ResponseWriter, r *http.Requ
 
 	return json.NewEncoder(w).Encode(items)
 }
+
+func (ui *uiserver) repositoryHistory(w http.ResponseWriter, r *http.Request) error {
+	resource, found, err := QueryParamToString(r, "resource")
+	if err != nil {
+		return err
+	}
+	if !found {
+		return parameterError("resource", MissingArgument, ErrMissingField)
+	}
+
+	importerType, _, err := QueryParamToString(r, "importerType")
+	if err != nil {
+		return err
+	}
+
+	importerOrigin, _, err := QueryParamToString(r, "importerOrigin")
+	if err != nil {
+		return err
+	}
+
+	importerDirectory, _, err := QueryParamToString(r, "importerDirectory")
+	if err != nil {
+		return err
+	}
+
+	if !ui.norefresh {
+		if _, err := cached.RebuildStateFromStore(ui.ctx, ui.repository.Configuration().RepositoryID, ui.ctx.StoreConfig, false); err != nil {
+			return err
+		}
+	}
+
+	var snapshotIDs []objects.MAC
+	for _, snapshotID := range ui.pathCandidates(resource) {
+		snap, err := snapshot.Load(ui.repository, snapshotID)
+		if err != nil {
+			return err
+		}
+
+		source := snap.Header.GetSource(0)
+		keep := (importerType == "" || strings.EqualFold(source.Importer.Type, importerType)) &&
+			(importerOrigin == "" || strings.EqualFold(source.Importer.Origin, importerOrigin)) &&
+			(importerDirectory == "" || strings.EqualFold(source.Importer.Directory, importerDirectory))
+		snap.Close()
+
+		if keep {
+			snapshotIDs = append(snapshotIDs, snapshotID)
+		}
+	}
+
+	versions, err := history.Versions(ui.repository, snapshotIDs, resource)
+	if err != nil && !errors.Is(err, history.ErrNotFound) {
+		return err
+	}
+
+	items := Items[history.Version]{
+		Total: len(versions),
+		Items: make([]history.Version, 0, len(versions)),
+	}
+	items.Items = append(items.Items, versions...)
+
+	return json.NewEncoder(w).Encode(items)
+}
@@ -194,6 +194,28 @@ func TestRepository(t *testing.T) {
 		require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
 	})
 
+	t.Run("history", func(t *testing.T) {
+		w := get(t, mux, "/api/repository/history?resource=/subdir/dummy.txt")
+		require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+		var items Items[json.RawMessage]
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+		require.Equal(t, 1, items.Total)
+		require.Contains(t, string(items.Items[0]), `"index":1`)
+
+		// unknown path or filtered out snapshots -> empty result, 200.
+		w = get(t, mux, "/api/repository/history?resource=/no/such/file")
+		require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+		require.Equal(t, 0, items.Total)
+
+		w = get(t, mux, "/api/repository/history?resource=/subdir/dummy.txt&importerType=nope")
+		require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+
+		// resource is mandatory.
+		w = get(t, mux, "/api/repository/history")
+		require.Equal(t, http.StatusBadRequest, w.Code, "body=%s", w.Body.String())
+	})
+
 	t.Run("locate-pathname param errors", func(t *testing.T) {
 		for _, q := range []string{"offset=abc", "limit=abc", "sort=NoSuchKey"} {
 			t.Run(q, func(t *testing.T) {
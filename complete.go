@@ -15,6 +15,7 @@ import (
 	"github.com/PlakarKorp/kloset/logging"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/pathindex"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/spf13/cobra"
 )
@@ -108,6 +109,18 @@ func completeSnapshotIDs(ctx *appcontext.AppContext, repo *repository.Repository
 // completeEntries offers what lives directly under the directory the user has
 // typed so far, so that walking a snapshot costs one directory at a time.
 func completeEntries(ctx *appcontext.AppContext, repo *repository.Repository, snapID, path string) []string {
+	dir := path
+	if !strings.HasSuffix(dir, "/") {
+		dir = filepath.Dir(dir)
+	}
+	if dir == "." {
+		dir = "/"
+	}
+
+	if out, ok := completeIndexedEntries(ctx, repo, snapID, dir, path); ok {
+		return out
+	}
+
 	snap, _, err := locate.OpenSnapshotByPath(repo, snapID)
 	if err != nil {
 		return nil
@@ -119,14 +132,6 @@ func completeEntries(ctx *appcontext.AppContext, repo *repository.Repository, sn
 		return nil
 	}
 
-	dir := path
-	if !strings.HasSuffix(dir, "/") {
-		dir = filepath.Dir(dir)
-	}
-	if dir == "." {
-		dir = "/"
-	}
-
 	entry, err := fs.GetEntry(dir)
 	if err != nil || !entry.Stat().Mode().IsDir() {
 		return nil
@@ -165,6 +170,36 @@ func completeEntries(ctx *appcontext.AppContext, repo *repository.Repository, sn
 	return out
 }
 
+// completeIndexedEntries answers from the path index cached maintains,
+// which spares loading the snapshot at all.  It reports false when the
+// index does not know the snapshot.
+func completeIndexedEntries(ctx *appcontext.AppContext, repo *repository.Repository, snapID, dir, path string) ([]string, bool) {
+	index, err := pathindex.Load(ctx.CacheDir, repo.Configuration().RepositoryID)
+	if err != nil {
+		return nil, false
+	}
+	id, ok := index.Resolve(snapID)
+	if !ok {
+		return nil, false
+	}
+	children, ok := index.Children(id, dir)
+	if !ok {
+		return nil, false
+	}
+
+	var out []string
+	for _, child := range children {
+		full := filepath.Join(dir, child.Name)
+		if child.Dir {
+			full += "/"
+		}
+		if strings.HasPrefix(full, path) {
+			out = append(out, snapID+":"+full)
+		}
+	}
+	return out, true
+}
+
 // completionRepository opens the repository the command line points at, under
 // the budget above.  The returned closer must always be called.
 func completionRepository() (*appcontext.AppContext, *repository.Repository, func(), error) {
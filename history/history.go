@@ -0,0 +1,130 @@
+// Package history lists the versions a path went through across
+// snapshots.
+package history
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+)
+
+// Version is one distinct content of a path.  Consecutive snapshots holding
+// the same content are folded into a single version, the first of them
+// being the one it is fetched from.
+type Version struct {
+	Index      int           `json:"index"`
+	Snapshot   objects.MAC   `json:"snapshot"`
+	Timestamp  time.Time     `json:"timestamp"`
+	LastSeen   time.Time     `json:"last_seen"`
+	Snapshots  []objects.MAC `json:"snapshots"`
+	Size       int64         `json:"size"`
+	ContentMAC objects.MAC   `json:"content_mac"`
+	Mode       fs.FileMode   `json:"mode"`
+	ModTime    time.Time     `json:"mtime"`
+}
+
+var ErrNotFound = errors.New("path not found in any snapshot")
+
+type seen struct {
+	id        objects.MAC
+	timestamp time.Time
+	size      int64
+	mac       objects.MAC
+	mode      fs.FileMode
+	mtime     time.Time
+}
+
+// Versions returns the versions of pathname found in the given snapshots,
+// oldest first.  Snapshots that do not hold the path are skipped.
+func Versions(repo *repository.Repository, snapshotIDs []objects.MAC, pathname string) ([]Version, error) {
+	var found []seen
+	for _, snapshotID := range snapshotIDs {
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			return nil, err
+		}
+
+		s, ok, err := lookup(snap, pathname)
+		snap.Close()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found = append(found, s)
+		}
+	}
+	if len(found) == 0 {
+		return nil, ErrNotFound
+	}
+
+	slices.SortStableFunc(found, func(a, b seen) int {
+		return a.timestamp.Compare(b.timestamp)
+	})
+
+	var versions []Version
+	for _, s := range found {
+		if n := len(versions); n != 0 && sameVersion(&versions[n-1], &s) {
+			last := &versions[n-1]
+			last.LastSeen = s.timestamp
+			last.Snapshots = append(last.Snapshots, s.id)
+			continue
+		}
+		versions = append(versions, Version{
+			Index:      len(versions) + 1,
+			Snapshot:   s.id,
+			Timestamp:  s.timestamp,
+			LastSeen:   s.timestamp,
+			Snapshots:  []objects.MAC{s.id},
+			Size:       s.size,
+			ContentMAC: s.mac,
+			Mode:       s.mode,
+			ModTime:    s.mtime,
+		})
+	}
+	return versions, nil
+}
+
+func sameVersion(v *Version, s *seen) bool {
+	if v.Mode.Type() != s.mode.Type() {
+		return false
+	}
+	// directories have no content, only tell them apart by their mtime
+	if s.mode.IsDir() {
+		return v.ModTime.Equal(s.mtime)
+	}
+	return v.ContentMAC == s.mac && v.Size == s.size
+}
+
+func lookup(snap *snapshot.Snapshot, pathname string) (seen, bool, error) {
+	fsys, err := snap.Filesystem()
+	if err != nil {
+		return seen{}, false, err
+	}
+
+	entry, err := fsys.GetEntry(pathname)
+	if errors.Is(err, fs.ErrNotExist) {
+		return seen{}, false, nil
+	} else if err != nil {
+		return seen{}, false, err
+	}
+
+	st := entry.Stat()
+	s := seen{
+		id:        snap.Header.Identifier,
+		timestamp: snap.Header.Timestamp,
+		mode:      st.Mode(),
+		mtime:     st.ModTime(),
+	}
+	if !st.Mode().IsDir() {
+		s.size = st.Size()
+	}
+	if entry.ResolvedObject != nil {
+		s.mac = entry.ResolvedObject.ContentMAC
+	}
+	return s, true, nil
+}
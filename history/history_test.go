@@ -0,0 +1,48 @@
+package history
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersions(t *testing.T) {
+	repo, _ := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	var ids []objects.MAC
+	for _, content := range []string{"one", "one", "two", "one"} {
+		snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+			ptesting.NewMockDir("etc"),
+			ptesting.NewMockFile("etc/app.conf", 0644, content),
+		})
+		ids = append(ids, snap.Header.Identifier)
+		snap.Close()
+	}
+	other := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("unrelated.txt", 0644, "x"),
+	})
+	ids = append(ids, other.Header.Identifier)
+	other.Close()
+
+	versions, err := Versions(repo, ids, "/etc/app.conf")
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+
+	require.Equal(t, 1, versions[0].Index)
+	require.Equal(t, []objects.MAC{ids[0], ids[1]}, versions[0].Snapshots)
+	require.Equal(t, ids[0], versions[0].Snapshot)
+	require.Equal(t, int64(3), versions[0].Size)
+
+	require.Equal(t, ids[2], versions[1].Snapshot)
+	require.NotEqual(t, versions[0].ContentMAC, versions[1].ContentMAC)
+
+	// going back to older content is a new version
+	require.Equal(t, ids[3], versions[2].Snapshot)
+	require.Equal(t, versions[0].ContentMAC, versions[2].ContentMAC)
+
+	_, err = Versions(repo, ids, "/etc/missing.conf")
+	require.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,164 @@
+// Package hold keeps track of the snapshots placed on hold, which must not
+// be deleted, nor the packfiles they rely on, until the hold expires or is
+// released.
+//
+// Holds are stored in the repository, one metadata record per snapshot,
+// so that every machine deleting snapshots or running maintenance sees
+// them and that two machines placing holds don't overwrite each other.
+package hold
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+)
+
+const VERSION = "1.0.0"
+
+// metadataKind names the repository metadata records of holds.
+const metadataKind = "hold"
+
+var ErrHeld = errors.New("snapshot is on hold")
+
+// Hold protects a snapshot until a date.
+type Hold struct {
+	Snapshot objects.MAC
+	Until    time.Time
+	Reason   string
+	Created  time.Time
+}
+
+// Active tells whether the hold still protects its snapshot.
+func (h *Hold) Active(now time.Time) bool {
+	return now.Before(h.Until)
+}
+
+type onDisk struct {
+	Version string    `json:"version"`
+	Until   time.Time `json:"until"`
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+}
+
+type Holds struct {
+	repo  *repository.Repository
+	holds map[objects.MAC]*Hold
+}
+
+// Load reads the holds of the repository.
+func Load(repo *repository.Repository) (*Holds, error) {
+	hs := &Holds{
+		repo:  repo,
+		holds: make(map[objects.MAC]*Hold),
+	}
+
+	ids, err := repo.ListMetadata(metadataKind)
+	if err != nil {
+		return nil, fmt.Errorf("could not list holds: %w", err)
+	}
+	for _, id := range ids {
+		h, err := get(repo, id)
+		if err != nil {
+			return nil, fmt.Errorf("hold of %x: %w", id[:4], err)
+		}
+		hs.holds[id] = h
+	}
+	return hs, nil
+}
+
+func get(repo *repository.Repository, id objects.MAC) (*Hold, error) {
+	rd, err := repo.GetMetadata(metadataKind, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	var disk onDisk
+	if err := json.NewDecoder(rd).Decode(&disk); err != nil {
+		return nil, fmt.Errorf("could not decode: %w", err)
+	}
+	if disk.Version != VERSION {
+		return nil, fmt.Errorf("unsupported version %q", disk.Version)
+	}
+	return &Hold{
+		Snapshot: id,
+		Until:    disk.Until,
+		Reason:   disk.Reason,
+		Created:  disk.Created,
+	}, nil
+}
+
+// Put places a hold, replacing the one the snapshot may already have.
+func (hs *Holds) Put(h Hold) error {
+	data, err := json.Marshal(&onDisk{
+		Version: VERSION,
+		Until:   h.Until,
+		Reason:  h.Reason,
+		Created: h.Created,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := hs.repo.PutMetadata(metadataKind, h.Snapshot, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("could not store hold of %x: %w", h.Snapshot[:4], err)
+	}
+	hs.holds[h.Snapshot] = &h
+	return nil
+}
+
+// Release removes the hold of a snapshot and tells whether it had one.
+func (hs *Holds) Release(id objects.MAC) (bool, error) {
+	if _, ok := hs.holds[id]; !ok {
+		return false, nil
+	}
+	if err := hs.repo.DeleteMetadata(metadataKind, id); err != nil {
+		return false, fmt.Errorf("could not release hold of %x: %w", id[:4], err)
+	}
+	delete(hs.holds, id)
+	return true, nil
+}
+
+// List returns the holds, expired ones included, by expiry date.
+func (hs *Holds) List() []Hold {
+	list := make([]Hold, 0, len(hs.holds))
+	for _, h := range hs.holds {
+		list = append(list, *h)
+	}
+	slices.SortFunc(list, func(a, b Hold) int {
+		if c := a.Until.Compare(b.Until); c != 0 {
+			return c
+		}
+		return slices.Compare(a.Snapshot[:], b.Snapshot[:])
+	})
+	return list
+}
+
+// Check fails with an error wrapping ErrHeld if the snapshot is under an
+// active hold.
+func (hs *Holds) Check(id objects.MAC, now time.Time) error {
+	h, ok := hs.holds[id]
+	if !ok || !h.Active(now) {
+		return nil
+	}
+	if h.Reason == "" {
+		return fmt.Errorf("%w until %s", ErrHeld, h.Until.UTC().Format(time.RFC3339))
+	}
+	return fmt.Errorf("%w until %s: %s", ErrHeld, h.Until.UTC().Format(time.RFC3339), h.Reason)
+}
+
+// Held returns the snapshots under an active hold.
+func (hs *Holds) Held(now time.Time) []objects.MAC {
+	var ids []objects.MAC
+	for _, h := range hs.List() {
+		if h.Active(now) {
+			ids = append(ids, h.Snapshot)
+		}
+	}
+	return ids
+}
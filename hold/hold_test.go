@@ -0,0 +1,52 @@
+package hold
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHoldsRoundTrip(t *testing.T) {
+	repo, _ := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	holds, err := Load(repo)
+	require.NoError(t, err)
+	require.Empty(t, holds.List())
+
+	a, b := objects.MAC{1}, objects.MAC{2}
+	require.NoError(t, holds.Put(Hold{Snapshot: a, Until: now.Add(24 * time.Hour), Reason: "audit", Created: now}))
+	require.NoError(t, holds.Put(Hold{Snapshot: b, Until: now.Add(-time.Hour), Created: now.Add(-48 * time.Hour)}))
+
+	// holds live in the repository, any other client reads them back
+	holds, err = Load(repo)
+	require.NoError(t, err)
+	list := holds.List()
+	require.Len(t, list, 2)
+	require.Equal(t, b, list[0].Snapshot)
+	require.Equal(t, "audit", list[1].Reason)
+
+	err = holds.Check(a, now)
+	require.True(t, errors.Is(err, ErrHeld))
+	require.Contains(t, err.Error(), "audit")
+	require.NoError(t, holds.Check(b, now), "expired holds no longer protect")
+	require.NoError(t, holds.Check(objects.MAC{3}, now))
+	require.Equal(t, []objects.MAC{a}, holds.Held(now))
+
+	released, err := holds.Release(a)
+	require.NoError(t, err)
+	require.True(t, released)
+	released, err = holds.Release(a)
+	require.NoError(t, err)
+	require.False(t, released)
+	require.NoError(t, holds.Check(a, now))
+
+	holds, err = Load(repo)
+	require.NoError(t, err)
+	require.Len(t, holds.List(), 1)
+}
@@ -0,0 +1,239 @@
+// Package lock takes the exclusive lock of a repository for the commands
+// that must not run along with others, and describes the locks in place.
+//
+// A lock is a lease: its holder renews it every LOCK_REFRESH_RATE, and once
+// TTL has elapsed since the last renewal the lock is stale and the next
+// command to take the lock removes it, so that a crashed holder doesn't
+// lock everyone out.  The kloset lock only carries a hostname, the holder
+// records its pid along with it as host[pid].
+package lock
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+)
+
+// TTL is how long after its last renewal a lock is stale, as kloset sees
+// it.
+const TTL = 2 * repository.LOCK_REFRESH_RATE
+
+var (
+	ErrLocked = errors.New("can't take exclusive lock, repository is already locked")
+	ErrInUse  = errors.New("lock is in use")
+)
+
+// Info describes a lock of the repository.
+type Info struct {
+	ID        objects.MAC
+	Hostname  string
+	PID       int
+	Exclusive bool
+	Renewed   time.Time
+	Stale     bool
+}
+
+func (info *Info) Type() string {
+	if info.Exclusive {
+		return "exclusive"
+	}
+	return "shared"
+}
+
+// Holder names who holds the lock, host[pid] when the pid is known.
+func (info *Info) Holder() string {
+	if info.PID == 0 {
+		return info.Hostname
+	}
+	return owner(info.Hostname, info.PID)
+}
+
+// Expires returns when the lock goes stale unless renewed.
+func (info *Info) Expires() time.Time {
+	return info.Renewed.Add(TTL)
+}
+
+func owner(hostname string, pid int) string {
+	return fmt.Sprintf("%s[%d]", hostname, pid)
+}
+
+// parseOwner splits host[pid], locks taken by other clients only carry
+// the hostname.
+func parseOwner(s string) (string, int) {
+	i := strings.LastIndexByte(s, '[')
+	if i < 0 || !strings.HasSuffix(s, "]") {
+		return s, 0
+	}
+	pid, err := strconv.Atoi(s[i+1 : len(s)-1])
+	if err != nil || pid <= 0 {
+		return s, 0
+	}
+	return s[:i], pid
+}
+
+// Get reads the lock.
+func Get(repo *repository.Repository, id objects.MAC) (*Info, error) {
+	rd, err := repo.GetLock(id)
+	if err != nil {
+		return nil, fmt.Errorf("lock %x: %w", id[:4], err)
+	}
+	defer rd.Close()
+
+	l, err := repository.NewLockFromStream(rd)
+	if err != nil {
+		return nil, fmt.Errorf("lock %x: %w", id[:4], err)
+	}
+
+	hostname, pid := parseOwner(l.Hostname)
+	return &Info{
+		ID:        id,
+		Hostname:  hostname,
+		PID:       pid,
+		Exclusive: l.Exclusive,
+		Renewed:   l.Timestamp,
+		Stale:     l.IsStale(),
+	}, nil
+}
+
+// List reads the locks of the repository, oldest renewal first.
+func List(repo *repository.Repository) ([]*Info, error) {
+	ids, err := repo.GetLocks()
+	if err != nil {
+		return nil, err
+	}
+
+	locks := make([]*Info, 0, len(ids))
+	for _, id := range ids {
+		info, err := Get(repo, id)
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, info)
+	}
+	slices.SortFunc(locks, func(a, b *Info) int {
+		if c := a.Renewed.Compare(b.Renewed); c != 0 {
+			return c
+		}
+		return slices.Compare(a.ID[:], b.ID[:])
+	})
+	return locks, nil
+}
+
+func put(repo *repository.Repository, id objects.MAC) error {
+	l := repository.NewExclusiveLock(owner(repo.AppContext().Hostname, os.Getpid()))
+
+	buffer := &bytes.Buffer{}
+	if err := l.SerializeToStream(buffer); err != nil {
+		return err
+	}
+	_, err := repo.PutLock(id, buffer)
+	return err
+}
+
+// Lease is an exclusive lock held and renewed until released.
+type Lease struct {
+	repo *repository.Repository
+	id   objects.MAC
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Acquire takes the exclusive lock of the repository under id.  Stale locks
+// are removed on the way, any other lock makes it fail with ErrLocked.
+func Acquire(repo *repository.Repository, id objects.MAC) (*Lease, error) {
+	if err := put(repo, id); err != nil {
+		return nil, err
+	}
+
+	// We installed the lock, now let's see if there is a conflicting lock or
+	// not.  On error we still need to delete ours, and we need to do so
+	// manually.
+	locks, err := List(repo)
+	if err != nil {
+		repo.DeleteLock(id)
+		return nil, err
+	}
+
+	for _, info := range locks {
+		if info.ID == id {
+			continue
+		}
+
+		if info.Stale {
+			if err := repo.DeleteLock(info.ID); err != nil {
+				repo.DeleteLock(id)
+				return nil, err
+			}
+			continue
+		}
+
+		if err := repo.DeleteLock(id); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w by %s (lock %x)", ErrLocked, info.Holder(), info.ID[:4])
+	}
+
+	lease := &Lease{
+		repo: repo,
+		id:   id,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go lease.renew()
+	return lease, nil
+}
+
+func (lease *Lease) renew() {
+	defer close(lease.done)
+	for {
+		select {
+		case <-lease.stop:
+			lease.repo.DeleteLock(lease.id)
+			return
+		case <-time.After(repository.LOCK_REFRESH_RATE):
+			// We ignore errors here on purpose, if renewing keeps failing
+			// the lease expires and others see the lock as stale.  Should
+			// the lock have been broken, this puts it back.
+			put(lease.repo, lease.id)
+		}
+	}
+}
+
+// Release stops renewing the lease and removes the lock.  A nil lease is
+// released as a no-op, for the commands running without a lock.
+func (lease *Lease) Release() {
+	if lease == nil {
+		return
+	}
+	close(lease.stop)
+	<-lease.done
+}
+
+// Break removes the lock of someone else.  Unless forced, only stale locks
+// are broken, and never one held by a process still running on this host.
+func Break(repo *repository.Repository, id objects.MAC, force bool) error {
+	info, err := Get(repo, id)
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		if !info.Stale {
+			return fmt.Errorf("%w: %x of %s was renewed %s ago",
+				ErrInUse, id[:4], info.Holder(), time.Since(info.Renewed).Round(time.Second))
+		}
+		if info.PID != 0 && info.Hostname == repo.AppContext().Hostname && running(info.PID) {
+			return fmt.Errorf("%w: %x is held by process %d, still running on this host", ErrInUse, id[:4], info.PID)
+		}
+	}
+
+	return repo.DeleteLock(id)
+}
@@ -0,0 +1,102 @@
+package lock
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+// install writes a lock on behalf of someone else, renewed at when.
+func install(t *testing.T, repo *repository.Repository, hostname string, when time.Time) objects.MAC {
+	t.Helper()
+	id := objects.RandomMAC()
+	l := repository.NewExclusiveLock(hostname)
+	l.Timestamp = when
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, l.SerializeToStream(buf))
+	_, err := repo.PutLock(id, buf)
+	require.NoError(t, err)
+	return id
+}
+
+func TestParseOwner(t *testing.T) {
+	host, pid := parseOwner(owner("host", 42))
+	require.Equal(t, "host", host)
+	require.Equal(t, 42, pid)
+
+	for _, s := range []string{"host", "host[]", "host[x]", "host[-1]", "[42"} {
+		host, pid = parseOwner(s)
+		require.Equal(t, s, host)
+		require.Zero(t, pid)
+	}
+}
+
+func TestAcquireAndRelease(t *testing.T) {
+	repo, _ := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	id := objects.RandomMAC()
+	lease, err := Acquire(repo, id)
+	require.NoError(t, err)
+
+	locks, err := List(repo)
+	require.NoError(t, err)
+	require.Len(t, locks, 1)
+	require.Equal(t, id, locks[0].ID)
+	require.Equal(t, os.Getpid(), locks[0].PID)
+	require.True(t, locks[0].Exclusive)
+	require.False(t, locks[0].Stale)
+	require.WithinDuration(t, locks[0].Renewed.Add(TTL), locks[0].Expires(), 0)
+
+	// a second holder is turned away while the lease is live
+	_, err = Acquire(repo, objects.RandomMAC())
+	require.ErrorIs(t, err, ErrLocked)
+
+	lease.Release()
+	locks, err = List(repo)
+	require.NoError(t, err)
+	require.Empty(t, locks)
+
+	// releasing no lease is a no-op
+	var none *Lease
+	none.Release()
+}
+
+func TestAcquireRemovesStaleLocks(t *testing.T) {
+	repo, _ := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	stale := install(t, repo, "ghost-host", time.Now().Add(-3*TTL))
+
+	lease, err := Acquire(repo, objects.RandomMAC())
+	require.NoError(t, err)
+	defer lease.Release()
+
+	_, err = Get(repo, stale)
+	require.Error(t, err)
+}
+
+func TestBreak(t *testing.T) {
+	repo, _ := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	live := install(t, repo, "other-host", time.Now())
+	stale := install(t, repo, "ghost-host", time.Now().Add(-3*TTL))
+
+	// held by this very process on this host, it is running
+	self := install(t, repo, owner(repo.AppContext().Hostname, os.Getpid()), time.Now().Add(-3*TTL))
+
+	require.ErrorIs(t, Break(repo, live, false), ErrInUse)
+	require.ErrorIs(t, Break(repo, self, false), ErrInUse)
+	require.NoError(t, Break(repo, stale, false))
+	require.NoError(t, Break(repo, live, true))
+	require.NoError(t, Break(repo, self, true))
+
+	locks, err := List(repo)
+	require.NoError(t, err)
+	require.Empty(t, locks)
+
+	require.Error(t, Break(repo, live, true))
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package lock
+
+import "os"
+
+// running tells whether a process of this host has the pid, opening it
+// fails otherwise.
+func running(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
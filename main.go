@@ -49,9 +49,14 @@ import (
 	_ "github.com/PlakarKorp/plakar/subcommands/diff"
 	_ "github.com/PlakarKorp/plakar/subcommands/digest"
 	_ "github.com/PlakarKorp/plakar/subcommands/dup"
+	_ "github.com/PlakarKorp/plakar/subcommands/find"
+	_ "github.com/PlakarKorp/plakar/subcommands/grep"
 	_ "github.com/PlakarKorp/plakar/subcommands/help"
+	_ "github.com/PlakarKorp/plakar/subcommands/history"
+	_ "github.com/PlakarKorp/plakar/subcommands/hold"
 	_ "github.com/PlakarKorp/plakar/subcommands/info"
 	_ "github.com/PlakarKorp/plakar/subcommands/locate"
+	_ "github.com/PlakarKorp/plakar/subcommands/lock"
 	_ "github.com/PlakarKorp/plakar/subcommands/login"
 	_ "github.com/PlakarKorp/plakar/subcommands/ls"
 	_ "github.com/PlakarKorp/plakar/subcommands/maintenance"
@@ -66,6 +71,7 @@ import (
 	_ "github.com/PlakarKorp/plakar/subcommands/service"
 	_ "github.com/PlakarKorp/plakar/subcommands/sync"
 	_ "github.com/PlakarKorp/plakar/subcommands/ui"
+	_ "github.com/PlakarKorp/plakar/subcommands/undelete"
 	_ "github.com/PlakarKorp/plakar/subcommands/version"
 
 	_ "github.com/PlakarKorp/integrations/fs/exporter"
@@ -0,0 +1,751 @@
+// Package pathindex maintains a local index of the paths held by the
+// snapshots of a repository, so that finding which snapshots hold a path
+// no longer means walking every one of them.
+//
+// The index is inverted: it maps every path to the snapshots holding it
+// and every base name to the paths ending with it, both split in shards
+// by a hash of the key, so that a lookup only reads the shard of the key
+// it asks for.  Each indexed snapshot also has a file listing its paths,
+// which tells that it is indexed, what to drop once it is deleted and what
+// a directory holds for completion.
+//
+// Snapshots are added and removed as they come and go, only the shards
+// they touch are rewritten.  The index is kept up to date by cached;
+// readers must not assume it is complete and fall back to walking the
+// snapshots it does not know about.
+package pathindex
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const VERSION = "1.2.0"
+
+var ErrWrongVersion = errors.New("path index has a different version")
+
+const (
+	shards = 256
+
+	// snapshots are indexed in batches, each rewriting the shards once
+	batchSize = 32
+)
+
+func shardOf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % shards)
+}
+
+// snapshotFile is the file of one snapshot, Dirs tells which of the sorted
+// Paths are directories.
+type snapshotFile struct {
+	Version string
+	Paths   []string
+	Dirs    []bool
+}
+
+// shardFile is a shard of either map.  Snapshots are referred to by the
+// number they were given when indexed, which is much shorter than their
+// identifier.
+type shardFile[V any] struct {
+	Version string
+	Entries map[string]V
+}
+
+// paths is what a snapshot holds, as read from its file.
+type paths struct {
+	sorted []string
+	dirs   []bool
+}
+
+type Index struct {
+	dir string
+
+	// the indexed snapshots and their number
+	snapshots map[objects.MAC]uint32
+	byNumber  map[uint32]objects.MAC
+
+	// the files read so far, nil for those that could not be
+	loaded map[objects.MAC]*paths
+
+	// the shards read so far, a shard that could not be read is nil and
+	// makes lookups treat every snapshot as not indexed
+	pathShards map[int]map[string][]uint32
+	nameShards map[int]map[string][]string
+}
+
+func indexDir(cacheDir string, repoID uuid.UUID) string {
+	return filepath.Join(cacheDir, "pathindex", VERSION, repoID.String())
+}
+
+// New returns an empty index for the repository.
+func New(cacheDir string, repoID uuid.UUID) *Index {
+	ix := &Index{dir: indexDir(cacheDir, repoID)}
+	ix.reset()
+	return ix
+}
+
+func (ix *Index) reset() {
+	ix.snapshots = make(map[objects.MAC]uint32)
+	ix.byNumber = make(map[uint32]objects.MAC)
+	ix.loaded = make(map[objects.MAC]*paths)
+	ix.pathShards = make(map[int]map[string][]uint32)
+	ix.nameShards = make(map[int]map[string][]string)
+}
+
+// Load opens the index of the repository, or fails with an error wrapping
+// fs.ErrNotExist if none was built yet.  Only the list of indexed snapshots
+// is read, the shards are read as lookups need them.
+func Load(cacheDir string, repoID uuid.UUID) (*Index, error) {
+	ix := New(cacheDir, repoID)
+
+	entries, err := os.ReadDir(filepath.Join(ix.dir, "snapshots"))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if id, n, ok := parseName(e.Name()); ok {
+			ix.snapshots[id] = n
+			ix.byNumber[n] = id
+		}
+	}
+	return ix, nil
+}
+
+// parseName reads the name of a snapshot file, its number and identifier.
+func parseName(name string) (objects.MAC, uint32, bool) {
+	var id objects.MAC
+	num, mac, ok := strings.Cut(name, "-")
+	if !ok {
+		return id, 0, false
+	}
+	n, err := strconv.ParseUint(num, 16, 32)
+	if err != nil {
+		return id, 0, false
+	}
+	b, err := hex.DecodeString(mac)
+	if err != nil || len(b) != len(id) {
+		return id, 0, false
+	}
+	copy(id[:], b)
+	return id, uint32(n), true
+}
+
+func (ix *Index) file(id objects.MAC) string {
+	return filepath.Join(ix.dir, "snapshots", fmt.Sprintf("%08x-%x", ix.snapshots[id], id[:]))
+}
+
+func (ix *Index) shardPath(kind string, i int) string {
+	return filepath.Join(ix.dir, kind, fmt.Sprintf("%02x", i))
+}
+
+func decode(file string, v any, version func() string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := msgpack.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("could not decode %s: %w", file, err)
+	}
+	if version() != VERSION {
+		return fmt.Errorf("%w (%s)", ErrWrongVersion, version())
+	}
+	return nil
+}
+
+func encode(file string, v any) error {
+	data, err := msgpack.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".pathindex-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// readShard reads a shard, a missing one being empty.
+func readShard[V any](file string) (map[string]V, error) {
+	var sf shardFile[V]
+	err := decode(file, &sf, func() string { return sf.Version })
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(map[string]V), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sf.Entries == nil {
+		sf.Entries = make(map[string]V)
+	}
+	return sf.Entries, nil
+}
+
+func writeShard[V any](file string, entries map[string]V) error {
+	if len(entries) == 0 {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return encode(file, &shardFile[V]{Version: VERSION, Entries: entries})
+}
+
+func (ix *Index) pathShard(i int) map[string][]uint32 {
+	s, ok := ix.pathShards[i]
+	if !ok {
+		s, _ = readShard[[]uint32](ix.shardPath("paths", i))
+		ix.pathShards[i] = s
+	}
+	return s
+}
+
+func (ix *Index) nameShard(i int) map[string][]string {
+	s, ok := ix.nameShards[i]
+	if !ok {
+		s, _ = readShard[[]string](ix.shardPath("names", i))
+		ix.nameShards[i] = s
+	}
+	return s
+}
+
+func (ix *Index) read(id objects.MAC) (*paths, error) {
+	var sf snapshotFile
+	if err := decode(ix.file(id), &sf, func() string { return sf.Version }); err != nil {
+		return nil, err
+	}
+	if len(sf.Dirs) != len(sf.Paths) {
+		return nil, fmt.Errorf("corrupted path index of %x", id[:4])
+	}
+	return &paths{sorted: sf.Paths, dirs: sf.Dirs}, nil
+}
+
+// get returns the paths of an indexed snapshot, or nil if it is not
+// indexed or its file can't be read.
+func (ix *Index) get(id objects.MAC) *paths {
+	if !ix.Has(id) {
+		return nil
+	}
+	if p, ok := ix.loaded[id]; ok {
+		return p
+	}
+	p, err := ix.read(id)
+	if err != nil {
+		p = nil
+	}
+	ix.loaded[id] = p
+	return p
+}
+
+// Snapshots returns the indexed snapshots.
+func (ix *Index) Snapshots() []objects.MAC {
+	ids := make([]objects.MAC, 0, len(ix.snapshots))
+	for id := range ix.snapshots {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b objects.MAC) int {
+		return slices.Compare(a[:], b[:])
+	})
+	return ids
+}
+
+// Has tells whether the snapshot was indexed.
+func (ix *Index) Has(id objects.MAC) bool {
+	_, ok := ix.snapshots[id]
+	return ok
+}
+
+// Update indexes the snapshots of the repository that are not indexed yet
+// and drops the ones that no longer exist, each change being written as it
+// is made.  It tells whether the index changed, which it may have even
+// when failing half-way.  If a shard it has to change can't be read, the
+// index is built again from scratch.
+func (ix *Index) Update(ctx context.Context, repo *repository.Repository) (bool, error) {
+	current := make(map[objects.MAC]struct{})
+	for id, err := range repo.ListSnapshots() {
+		if err != nil {
+			return false, err
+		}
+		current[id] = struct{}{}
+	}
+
+	// what was read is only kept for the time of the update, the index
+	// can be held on to between updates
+	defer func() {
+		ix.loaded = make(map[objects.MAC]*paths)
+		ix.pathShards = make(map[int]map[string][]uint32)
+		ix.nameShards = make(map[int]map[string][]string)
+	}()
+
+	changed, err := ix.update(ctx, repo, current)
+	if errors.Is(err, errDamaged) {
+		if err := os.RemoveAll(ix.dir); err != nil {
+			return changed, err
+		}
+		ix.reset()
+		_, err = ix.update(ctx, repo, current)
+		changed = true
+	}
+	return changed, err
+}
+
+var errDamaged = errors.New("path index is damaged")
+
+func (ix *Index) update(ctx context.Context, repo *repository.Repository, current map[objects.MAC]struct{}) (bool, error) {
+	changed := false
+	var gone []objects.MAC
+	for id := range ix.snapshots {
+		if _, ok := current[id]; !ok {
+			gone = append(gone, id)
+		}
+	}
+	if len(gone) != 0 {
+		if err := ix.remove(gone); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+
+	var added []objects.MAC
+	for id := range current {
+		if !ix.Has(id) {
+			added = append(added, id)
+		}
+	}
+	slices.SortFunc(added, func(a, b objects.MAC) int {
+		return slices.Compare(a[:], b[:])
+	})
+
+	for len(added) != 0 {
+		batch := make(map[objects.MAC]*paths)
+		for _, id := range added[:min(batchSize, len(added))] {
+			if err := ctx.Err(); err != nil {
+				return changed, err
+			}
+			p, err := walk(repo, id)
+			if err != nil {
+				return changed, fmt.Errorf("could not index snapshot %x: %w", id[:4], err)
+			}
+			batch[id] = p
+		}
+		added = added[len(batch):]
+
+		if err := ix.insert(batch); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+func walk(repo *repository.Repository, id objects.MAC) (*paths, error) {
+	snap, err := snapshot.Load(repo, id)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	type hit struct {
+		path string
+		dir  bool
+	}
+	var hits []hit
+	err = fs.WalkDir("/", func(p string, e *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		hits = append(hits, hit{p, e.IsDir()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(hits, func(a, b hit) int {
+		return strings.Compare(a.path, b.path)
+	})
+
+	p := &paths{
+		sorted: make([]string, len(hits)),
+		dirs:   make([]bool, len(hits)),
+	}
+	for i, h := range hits {
+		p.sorted[i], p.dirs[i] = h.path, h.dir
+	}
+	return p, nil
+}
+
+// insert adds the snapshots to the shards, which are written before the
+// files of the snapshots so that a snapshot is never indexed with paths
+// missing.
+func (ix *Index) insert(batch map[objects.MAC]*paths) error {
+	next, err := ix.readNext()
+	if err != nil {
+		return err
+	}
+	numbers := make(map[objects.MAC]uint32, len(batch))
+	for _, id := range slices.SortedFunc(maps.Keys(batch), func(a, b objects.MAC) int {
+		return slices.Compare(a[:], b[:])
+	}) {
+		numbers[id] = next
+		next++
+	}
+	// numbers are never given twice, even if writing the shards fails
+	if err := ix.writeNext(next); err != nil {
+		return err
+	}
+
+	touchedPaths := make(map[int]struct{})
+	touchedNames := make(map[int]struct{})
+	for id, p := range batch {
+		n := numbers[id]
+		for _, pathname := range p.sorted {
+			i := shardOf(pathname)
+			shard := ix.pathShard(i)
+			if shard == nil {
+				return errDamaged
+			}
+			holders, known := shard[pathname]
+			if at, found := slices.BinarySearch(holders, n); !found {
+				shard[pathname] = slices.Insert(holders, at, n)
+			}
+			touchedPaths[i] = struct{}{}
+			if known {
+				continue
+			}
+
+			name := path.Base(pathname)
+			j := shardOf(name)
+			names := ix.nameShard(j)
+			if names == nil {
+				return errDamaged
+			}
+			if !slices.Contains(names[name], pathname) {
+				names[name] = append(names[name], pathname)
+			}
+			touchedNames[j] = struct{}{}
+		}
+	}
+
+	if err := ix.flush(touchedPaths, touchedNames); err != nil {
+		return err
+	}
+
+	for id, p := range batch {
+		ix.snapshots[id] = numbers[id]
+		ix.byNumber[numbers[id]] = id
+		err := encode(ix.file(id), &snapshotFile{
+			Version: VERSION,
+			Paths:   p.sorted,
+			Dirs:    p.dirs,
+		})
+		if err != nil {
+			delete(ix.snapshots, id)
+			delete(ix.byNumber, numbers[id])
+			return err
+		}
+	}
+	return nil
+}
+
+// remove drops the snapshots from the shards, then their files.  The
+// shards a snapshot appears in are found from its file, all of them are
+// gone through if it can't be read.
+func (ix *Index) remove(ids []objects.MAC) error {
+	numbers := make(map[uint32]struct{}, len(ids))
+	touchedPaths := make(map[int]struct{})
+	for _, id := range ids {
+		numbers[ix.snapshots[id]] = struct{}{}
+		p := ix.get(id)
+		if p == nil {
+			for i := range shards {
+				touchedPaths[i] = struct{}{}
+			}
+			continue
+		}
+		for _, pathname := range p.sorted {
+			touchedPaths[shardOf(pathname)] = struct{}{}
+		}
+	}
+
+	touchedNames := make(map[int]struct{})
+	for i := range touchedPaths {
+		shard := ix.pathShard(i)
+		if shard == nil {
+			return errDamaged
+		}
+		for pathname, holders := range shard {
+			holders = slices.DeleteFunc(holders, func(n uint32) bool {
+				_, ok := numbers[n]
+				return ok
+			})
+			if len(holders) != 0 {
+				shard[pathname] = holders
+				continue
+			}
+
+			// no snapshot holds the path anymore
+			delete(shard, pathname)
+			name := path.Base(pathname)
+			j := shardOf(name)
+			names := ix.nameShard(j)
+			if names == nil {
+				return errDamaged
+			}
+			names[name] = slices.DeleteFunc(names[name], func(p string) bool { return p == pathname })
+			if len(names[name]) == 0 {
+				delete(names, name)
+			}
+			touchedNames[j] = struct{}{}
+		}
+	}
+
+	if err := ix.flush(touchedPaths, touchedNames); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := os.Remove(ix.file(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		delete(ix.byNumber, ix.snapshots[id])
+		delete(ix.snapshots, id)
+		delete(ix.loaded, id)
+	}
+	return nil
+}
+
+// readNext returns the number the next indexed snapshot gets.
+func (ix *Index) readNext() (uint32, error) {
+	data, err := os.ReadFile(filepath.Join(ix.dir, "next"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return 0, errDamaged
+	}
+	return uint32(n), nil
+}
+
+func (ix *Index) writeNext(next uint32) error {
+	if err := os.MkdirAll(ix.dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(ix.dir, "next"), []byte(strconv.FormatUint(uint64(next), 10)+"\n"), 0600)
+}
+
+func (ix *Index) flush(touchedPaths, touchedNames map[int]struct{}) error {
+	for i := range touchedPaths {
+		if err := writeShard(ix.shardPath("paths", i), ix.pathShards[i]); err != nil {
+			return err
+		}
+	}
+	for i := range touchedNames {
+		if err := writeShard(ix.shardPath("names", i), ix.nameShards[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// results prepares the answer of a lookup for the indexed snapshots of ids.
+func (ix *Index) results(ids []objects.MAC) map[objects.MAC][]string {
+	result := make(map[objects.MAC][]string)
+	for _, id := range ids {
+		if ix.Has(id) {
+			result[id] = nil
+		}
+	}
+	return result
+}
+
+// holders adds pathname to the result of each snapshot holding it.  It
+// reports false if the shard of the path can't be read.
+func (ix *Index) holders(result map[objects.MAC][]string, pathname string) bool {
+	shard := ix.pathShard(shardOf(pathname))
+	if shard == nil {
+		return false
+	}
+	for _, n := range shard[pathname] {
+		id, ok := ix.byNumber[n]
+		if !ok {
+			continue
+		}
+		if hits, wanted := result[id]; wanted {
+			result[id] = append(hits, pathname)
+		}
+	}
+	return true
+}
+
+func sortResults(result map[objects.MAC][]string) map[objects.MAC][]string {
+	for _, hits := range result {
+		slices.Sort(hits)
+	}
+	return result
+}
+
+// Match returns, for each of the given snapshots that is indexed, the
+// sorted paths it holds that match.  Snapshots missing from the result
+// are not indexed and have to be looked into.  The predicate is evaluated
+// once per distinct path of the index.
+func (ix *Index) Match(ids []objects.MAC, match func(pathname string) bool) map[objects.MAC][]string {
+	result := ix.results(ids)
+	if len(result) == 0 {
+		return result
+	}
+	for i := range shards {
+		shard := ix.pathShard(i)
+		if shard == nil {
+			return make(map[objects.MAC][]string)
+		}
+		for pathname := range shard {
+			if match(pathname) {
+				ix.holders(result, pathname)
+			}
+		}
+	}
+	return sortResults(result)
+}
+
+// MatchName is Match for a predicate on the base name, which is evaluated
+// once per distinct name.  Only the paths ending with a matching name are
+// looked up.
+func (ix *Index) MatchName(ids []objects.MAC, match func(name string) bool) map[objects.MAC][]string {
+	result := ix.results(ids)
+	if len(result) == 0 {
+		return result
+	}
+	for i := range shards {
+		names := ix.nameShard(i)
+		if names == nil {
+			return make(map[objects.MAC][]string)
+		}
+		for name, pathnames := range names {
+			if !match(name) {
+				continue
+			}
+			for _, pathname := range pathnames {
+				if !ix.holders(result, pathname) {
+					return make(map[objects.MAC][]string)
+				}
+			}
+		}
+	}
+	return sortResults(result)
+}
+
+// Candidates narrows ids down to the snapshots that may hold pathname:
+// the indexed ones holding it and the ones the index does not know about.
+func (ix *Index) Candidates(ids []objects.MAC, pathname string) []objects.MAC {
+	pathname = path.Clean("/" + pathname)
+
+	shard := ix.pathShard(shardOf(pathname))
+	if shard == nil {
+		return ids
+	}
+	holding := make(map[uint32]struct{})
+	for _, n := range shard[pathname] {
+		holding[n] = struct{}{}
+	}
+
+	var out []objects.MAC
+	for _, id := range ids {
+		n, indexed := ix.snapshots[id]
+		if !indexed {
+			out = append(out, id)
+			continue
+		}
+		if _, ok := holding[n]; ok {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// Resolve finds the indexed snapshot whose hexadecimal identifier starts
+// with prefix, if there is exactly one.
+func (ix *Index) Resolve(prefix string) (objects.MAC, bool) {
+	var found objects.MAC
+	count := 0
+	for id := range ix.snapshots {
+		if strings.HasPrefix(hex.EncodeToString(id[:]), prefix) {
+			found = id
+			count++
+		}
+	}
+	return found, count == 1
+}
+
+// Child is a direct child of a directory.
+type Child struct {
+	Name string
+	Dir  bool
+}
+
+// Children lists what the snapshot holds directly under dir, in order.
+func (ix *Index) Children(id objects.MAC, dir string) ([]Child, bool) {
+	p := ix.get(id)
+	if p == nil {
+		return nil, false
+	}
+
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	var out []Child
+	i := sort.SearchStrings(p.sorted, prefix)
+	for i < len(p.sorted) && strings.HasPrefix(p.sorted[i], prefix) {
+		name, _, deeper := strings.Cut(p.sorted[i][len(prefix):], "/")
+		if deeper {
+			// the child itself sorts before its content, skip the rest of it
+			i = sort.SearchStrings(p.sorted, prefix+name+"0")
+			continue
+		}
+		if name != "" {
+			out = append(out, Child{Name: name, Dir: p.dirs[i]})
+		}
+		i++
+	}
+	return out, true
+}
@@ -0,0 +1,155 @@
+package pathindex
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	repoID := repo.Configuration().RepositoryID
+
+	_, err := Load(ctx.CacheDir, repoID)
+	require.True(t, errors.Is(err, fs.ErrNotExist))
+
+	snap1 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("etc"),
+		ptesting.NewMockFile("etc/app.conf", 0644, "one"),
+		ptesting.NewMockFile("etc/hosts", 0644, "localhost"),
+	})
+	id1 := snap1.Header.Identifier
+	snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("etc"),
+		ptesting.NewMockFile("etc/app.conf", 0644, "two"),
+		ptesting.NewMockDir("home"),
+		ptesting.NewMockFile("home/app.conf", 0644, "three"),
+	})
+	id2 := snap2.Header.Identifier
+	snap2.Close()
+
+	ix := New(ctx.CacheDir, repoID)
+	changed, err := ix.Update(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.True(t, ix.Has(id1))
+	require.True(t, ix.Has(id2))
+
+	// nothing new, nothing to do
+	changed, err = ix.Update(ctx, repo)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	ix, err = Load(ctx.CacheDir, repoID)
+	require.NoError(t, err)
+	require.Len(t, ix.Snapshots(), 2)
+
+	var unknown objects.MAC
+	ids := []objects.MAC{id1, id2, unknown}
+
+	hits := ix.MatchName(ids, func(name string) bool { return name == "app.conf" })
+	require.Len(t, hits, 2)
+	require.Equal(t, []string{"/etc/app.conf"}, hits[id1])
+	require.Equal(t, []string{"/etc/app.conf", "/home/app.conf"}, hits[id2])
+
+	hits = ix.Match(ids, func(pathname string) bool { return pathname == "/etc/hosts" })
+	require.Equal(t, []string{"/etc/hosts"}, hits[id1])
+	require.Empty(t, hits[id2])
+	_, indexed := hits[unknown]
+	require.False(t, indexed)
+
+	require.Equal(t, []objects.MAC{id1, unknown}, ix.Candidates(ids, "/etc/hosts"))
+	require.Equal(t, []objects.MAC{id2, unknown}, ix.Candidates(ids, "home/app.conf"))
+
+	children, ok := ix.Children(id2, "/")
+	require.True(t, ok)
+	require.Equal(t, []Child{{Name: "etc", Dir: true}, {Name: "home", Dir: true}}, children)
+	children, ok = ix.Children(id1, "/etc/")
+	require.True(t, ok)
+	require.Equal(t, []Child{{Name: "app.conf"}, {Name: "hosts"}}, children)
+	_, ok = ix.Children(unknown, "/")
+	require.False(t, ok)
+
+	id, ok := ix.Resolve(hex.EncodeToString(id1[:4]))
+	require.True(t, ok)
+	require.Equal(t, id1, id)
+	_, ok = ix.Resolve("")
+	require.False(t, ok)
+
+	// a deleted snapshot is dropped along with the paths only it held
+	require.NoError(t, repo.DeleteSnapshot(id1))
+	require.NoError(t, repo.RebuildState())
+	changed, err = ix.Update(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.False(t, ix.Has(id1))
+	require.Equal(t, []objects.MAC{id2}, ix.Candidates([]objects.MAC{id2}, "/etc/app.conf"))
+	require.Empty(t, ix.Match([]objects.MAC{id2}, func(pathname string) bool { return pathname == "/etc/hosts" })[id2])
+
+	// and so is its file
+	ix, err = Load(ctx.CacheDir, repoID)
+	require.NoError(t, err)
+	require.Equal(t, []objects.MAC{id2}, ix.Snapshots())
+	entries, err := os.ReadDir(filepath.Join(ix.dir, "snapshots"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// the paths only the deleted snapshot held are gone from the shards
+	require.Empty(t, ix.pathShard(shardOf("/etc/hosts"))["/etc/hosts"])
+	require.NotContains(t, ix.nameShard(shardOf("hosts")), "hosts")
+	require.Len(t, ix.pathShard(shardOf("/etc/app.conf"))["/etc/app.conf"], 1)
+}
+
+func TestIndexUnreadable(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	repoID := repo.Configuration().RepositoryID
+
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("hosts", 0644, "localhost"),
+	})
+	id := snap.Header.Identifier
+	snap.Close()
+
+	_, err := New(ctx.CacheDir, repoID).Update(ctx, repo)
+	require.NoError(t, err)
+
+	ix, err := Load(ctx.CacheDir, repoID)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(ix.shardPath("paths", shardOf("/hosts")), []byte("garbage"), 0600))
+
+	// a shard that can't be read makes every snapshot look not indexed
+	_, indexed := ix.Match([]objects.MAC{id}, func(string) bool { return true })[id]
+	require.False(t, indexed)
+	_, indexed = ix.MatchName([]objects.MAC{id}, func(string) bool { return true })[id]
+	require.False(t, indexed)
+	require.Equal(t, []objects.MAC{id}, ix.Candidates([]objects.MAC{id}, "/nowhere"))
+
+	// until an update that needs the shard builds the index again
+	snap = ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("hosts", 0644, "127.0.0.1"),
+	})
+	id2 := snap.Header.Identifier
+	snap.Close()
+	changed, err := ix.Update(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, changed)
+	ids := []objects.MAC{id, id2}
+	require.Empty(t, ix.Candidates(ids, "/nowhere"))
+	require.ElementsMatch(t, ids, ix.Candidates(ids, "/hosts"))
+
+	// completion reads the file of the snapshot
+	require.NoError(t, os.WriteFile(ix.file(id), []byte("garbage"), 0600))
+	ix, err = Load(ctx.CacheDir, repoID)
+	require.NoError(t, err)
+	_, ok := ix.Children(id, "/")
+	require.False(t, ok)
+}
@@ -0,0 +1,214 @@
+// Package reclaim estimates how much space deleting snapshots gives
+// back.  Because of deduplication, only the packfiles no other snapshot
+// relies on are freed, once maintenance sweeps them.
+//
+// Estimates only read what is local: the packfiles of a snapshot come
+// from the maintenance cache, their size from the blob locations of the
+// state, so that no packfile is ever fetched.
+package reclaim
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/caching"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sync/errgroup"
+)
+
+// Estimate is an amount of packfiles and the bytes of the blobs they
+// hold, their own index and footer aside.  Unknown counts the packfiles
+// the state has no blob in, such as the leftovers of an aborted backup,
+// whose size can't be told without fetching them.
+type Estimate struct {
+	Packfiles int
+	Bytes     int64
+	Unknown   int
+}
+
+func (est Estimate) String() string {
+	s := fmt.Sprintf("%s in %d packfile(s)", humanize.IBytes(uint64(est.Bytes)), est.Packfiles)
+	if est.Unknown != 0 {
+		s += fmt.Sprintf(", %d of unknown size", est.Unknown)
+	}
+	return s
+}
+
+// snapshotPackfiles returns the packfiles a snapshot relies on, from the
+// maintenance cache when it knows the snapshot, which it is then told
+// about otherwise.
+func snapshotPackfiles(ctx *appcontext.AppContext, repo *repository.Repository, cache *caching.MaintenanceCache, snapshotID objects.MAC) ([]objects.MAC, error) {
+	var packfiles []objects.MAC
+
+	if cache != nil {
+		ok, err := cache.HasSnapshot(snapshotID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			for packfile, err := range cache.GetPackfiles(snapshotID) {
+				if err != nil {
+					return nil, err
+				}
+				packfiles = append(packfiles, packfile)
+			}
+			return packfiles, nil
+		}
+	}
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	iter, err := snap.ListPackfiles()
+	if err != nil {
+		return nil, err
+	}
+	for packfile, err := range iter {
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		packfiles = append(packfiles, packfile)
+	}
+
+	// the same entries maintenance would record for the snapshot
+	if cache != nil {
+		for _, packfile := range packfiles {
+			if err := cache.PutPackfile(snapshotID, packfile); err != nil {
+				return nil, err
+			}
+		}
+		cache.PutSnapshot(snapshotID, nil)
+	}
+	return packfiles, nil
+}
+
+// Exclusive returns the packfiles referenced by the given snapshots and by
+// no other live snapshot.  The packfiles of each snapshot are taken from
+// the maintenance cache, only the snapshots it doesn't know yet are
+// loaded.
+func Exclusive(ctx *appcontext.AppContext, repo *repository.Repository, snapshots []objects.MAC) ([]objects.MAC, error) {
+	deleting := make(map[objects.MAC]struct{}, len(snapshots))
+	for _, snapshotID := range snapshots {
+		deleting[snapshotID] = struct{}{}
+	}
+
+	// without the cache, say because maintenance has it open, the
+	// snapshots are all loaded
+	cache, err := repo.AppContext().GetCache().Maintenance(repo.Configuration().RepositoryID)
+	if err != nil {
+		ctx.GetLogger().Warn("could not open the maintenance cache: %s", err)
+		cache = nil
+	}
+
+	var mu sync.Mutex
+	freed := make(map[objects.MAC]struct{})
+	kept := make(map[objects.MAC]struct{})
+
+	wg := new(errgroup.Group)
+	wg.SetLimit(max(ctx.MaxConcurrency, 1))
+
+	for snapshotID, err := range repo.ListSnapshots() {
+		if err != nil {
+			return nil, err
+		}
+		_, isDeleting := deleting[snapshotID]
+
+		wg.Go(func() error {
+			packfiles, err := snapshotPackfiles(ctx, repo, cache, snapshotID)
+			if err != nil {
+				return fmt.Errorf("snapshot %x: %w", snapshotID[:4], err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, packfile := range packfiles {
+				if isDeleting {
+					freed[packfile] = struct{}{}
+				} else {
+					kept[packfile] = struct{}{}
+				}
+			}
+			return nil
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+
+	var exclusive []objects.MAC
+	for packfile := range freed {
+		if _, ok := kept[packfile]; !ok {
+			exclusive = append(exclusive, packfile)
+		}
+	}
+	return exclusive, nil
+}
+
+// Sizes returns the bytes of the blobs the state places in each of the
+// packfiles.  Packfiles the state has no blob in are missing from the
+// result.
+func Sizes(ctx *appcontext.AppContext, repo *repository.Repository, packfiles []objects.MAC) (map[objects.MAC]int64, error) {
+	wanted := make(map[objects.MAC]struct{}, len(packfiles))
+	for _, packfile := range packfiles {
+		wanted[packfile] = struct{}{}
+	}
+
+	sizes := make(map[objects.MAC]int64)
+	if len(wanted) == 0 {
+		return sizes, nil
+	}
+	for _, Type := range resources.Types() {
+		for entry, err := range repo.ListObjectsOfType(Type) {
+			if err != nil {
+				return nil, err
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if _, ok := wanted[entry.Location.Packfile]; ok {
+				sizes[entry.Location.Packfile] += int64(entry.Location.Length)
+			}
+		}
+	}
+	return sizes, nil
+}
+
+// Size adds up the packfiles as the state knows them.
+func Size(ctx *appcontext.AppContext, repo *repository.Repository, packfiles []objects.MAC) (Estimate, error) {
+	sizes, err := Sizes(ctx, repo, packfiles)
+	if err != nil {
+		return Estimate{}, err
+	}
+
+	est := Estimate{Packfiles: len(packfiles)}
+	for _, packfile := range packfiles {
+		size, ok := sizes[packfile]
+		if !ok {
+			est.Unknown++
+			continue
+		}
+		est.Bytes += size
+	}
+	return est, nil
+}
+
+// Snapshots estimates the space deleting the snapshots would give back.
+func Snapshots(ctx *appcontext.AppContext, repo *repository.Repository, snapshots []objects.MAC) (Estimate, error) {
+	exclusive, err := Exclusive(ctx, repo, snapshots)
+	if err != nil {
+		return Estimate{}, err
+	}
+	return Size(ctx, repo, exclusive)
+}
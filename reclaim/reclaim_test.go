@@ -0,0 +1,51 @@
+package reclaim
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExclusive(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	snap1 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "hello"),
+	})
+	snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("b.txt", 0644, "world"),
+	})
+	snap2.Close()
+
+	exclusive, err := Exclusive(ctx, repo, nil)
+	require.NoError(t, err)
+	require.Empty(t, exclusive)
+
+	// deleting everything frees every packfile the snapshots rely on
+	all, err := Exclusive(ctx, repo, []objects.MAC{snap1.Header.Identifier, snap2.Header.Identifier})
+	require.NoError(t, err)
+	require.NotEmpty(t, all)
+
+	one, err := Exclusive(ctx, repo, []objects.MAC{snap1.Header.Identifier})
+	require.NoError(t, err)
+	require.Subset(t, all, one)
+
+	est, err := Snapshots(ctx, repo, []objects.MAC{snap1.Header.Identifier, snap2.Header.Identifier})
+	require.NoError(t, err)
+	require.Equal(t, len(all), est.Packfiles)
+	require.Positive(t, est.Bytes)
+	require.Zero(t, est.Unknown)
+
+	// the maintenance cache now knows the snapshots, the answer is the same
+	again, err := Exclusive(ctx, repo, []objects.MAC{snap1.Header.Identifier})
+	require.NoError(t, err)
+	require.ElementsMatch(t, one, again)
+
+	// a packfile the state doesn't know can't be sized without fetching it
+	est, err = Size(ctx, repo, []objects.MAC{{0xff}})
+	require.NoError(t, err)
+	require.Equal(t, Estimate{Packfiles: 1, Unknown: 1}, est)
+}
@@ -0,0 +1,282 @@
+// Package scrub reads back what a repository stores, packfiles and state
+// files along with the ECC data the storage keeps for them, independently
+// of the snapshots referencing it.
+package scrub
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/repository/state"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"golang.org/x/sync/errgroup"
+)
+
+// Problems found with a packfile.
+const (
+	ProblemUnreadable    = "unreadable"
+	ProblemCorrupt       = "corrupt"
+	ProblemMissing       = "missing"
+	ProblemOrphan        = "orphan"
+	ProblemIndexMismatch = "index-mismatch"
+	ProblemECC           = "ecc"
+)
+
+// Blob is a blob and where it is stored.
+type Blob struct {
+	Type     resources.Type
+	MAC      objects.MAC
+	Location state.Location
+}
+
+// Key identifies a blob regardless of where it is stored.
+type Key struct {
+	Type resources.Type
+	MAC  objects.MAC
+}
+
+func (b Blob) Key() Key {
+	return Key{b.Type, b.MAC}
+}
+
+// Result is the outcome of verifying a packfile.
+type Result struct {
+	MAC     objects.MAC
+	Blobs   int
+	Size    int64
+	Problem string
+	Err     error
+
+	// Index is the packfile index, Damaged the blobs that failed to read
+	// back.
+	Index   []Blob
+	Damaged []Blob
+}
+
+// VerifyPackfile loads a packfile, which authenticates its footer and
+// index, then, with readBlobs, decodes every blob from the packfile just
+// loaded rather than fetching each again: each must decode, and chunks
+// must still hash to their MAC.  The ECC data of the packfile, if hasECC,
+// is authenticated as well.
+func VerifyPackfile(repo *repository.Repository, mac objects.MAC, readBlobs, hasECC bool) Result {
+	res := Result{MAC: mac}
+
+	p, err := repo.GetPackfile(mac)
+	if err != nil {
+		res.Problem, res.Err = ProblemUnreadable, fmt.Errorf("could not load packfile: %w", err)
+		return res
+	}
+
+	for _, entry := range p.Index {
+		res.Index = append(res.Index, Blob{
+			Type:     entry.Type,
+			MAC:      entry.MAC,
+			Location: state.Location{Packfile: mac, Offset: entry.Offset, Length: entry.Length},
+		})
+		res.Blobs++
+		res.Size += int64(entry.Length)
+	}
+	if !readBlobs {
+		return res
+	}
+
+	for _, blob := range res.Index {
+		if err := decodeBlob(repo, p.Blobs, blob); err != nil {
+			if res.Err == nil {
+				res.Problem, res.Err = ProblemCorrupt, err
+			}
+			res.Damaged = append(res.Damaged, blob)
+		}
+	}
+	if len(res.Damaged) > 1 {
+		res.Err = fmt.Errorf("%d damaged blobs, first: %w", len(res.Damaged), res.Err)
+	}
+	if hasECC && res.Err == nil {
+		if err := VerifyECC(repo, storage.StorageResourceECCPackfile, mac); err != nil {
+			res.Problem, res.Err = ProblemECC, err
+		}
+	}
+	return res
+}
+
+// VerifyECC reads back the ECC data kept for a packfile or state, which
+// is stored encoded like any other blob: decoding it authenticates it.
+func VerifyECC(repo *repository.Repository, resource storage.StorageResource, mac objects.MAC) error {
+	rd, err := repo.Store().Get(repo.AppContext(), resource, mac, nil)
+	if err != nil {
+		return fmt.Errorf("could not load ECC data: %w", err)
+	}
+	defer rd.Close()
+
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return fmt.Errorf("could not load ECC data: %w", err)
+	}
+	if _, err := repo.DecodeBuffer(data); err != nil {
+		return fmt.Errorf("ECC data: %w", err)
+	}
+	return nil
+}
+
+// withECC lists the packfiles or states the storage keeps ECC data for.
+func withECC(ctx *appcontext.AppContext, repo *repository.Repository, resource storage.StorageResource) (map[objects.MAC]struct{}, error) {
+	macs, err := repo.Store().List(ctx, resource)
+	if err != nil {
+		return nil, fmt.Errorf("could not list ECC data: %w", err)
+	}
+	set := make(map[objects.MAC]struct{}, len(macs))
+	for _, mac := range macs {
+		set[mac] = struct{}{}
+	}
+	return set, nil
+}
+
+// decodeBlob decodes a blob out of the data section of its packfile.
+func decodeBlob(repo *repository.Repository, blobs []byte, blob Blob) error {
+	start, end := uint64(blob.Location.Offset), uint64(blob.Location.Offset)+uint64(blob.Location.Length)
+	if end > uint64(len(blobs)) {
+		return fmt.Errorf("%s %x: offset %d length %d past the end of the packfile",
+			blob.Type, blob.MAC[:4], blob.Location.Offset, blob.Location.Length)
+	}
+	data, err := repo.DecodeBuffer(blobs[start:end])
+	if err != nil {
+		return fmt.Errorf("%s %x: %w", blob.Type, blob.MAC[:4], err)
+	}
+	return Validate(repo, blob.Type, blob.MAC, data)
+}
+
+// Validate checks decoded blob data against its MAC, which is only
+// possible for chunks: other blobs are authenticated when decoded.
+func Validate(repo *repository.Repository, Type resources.Type, mac objects.MAC, data []byte) error {
+	if Type == resources.RT_CHUNK && repo.ComputeMAC(data) != mac {
+		return fmt.Errorf("%s %x: MAC mismatch", Type, mac[:4])
+	}
+	return nil
+}
+
+// VerifyPackfiles verifies the packfiles ctx.MaxConcurrency at once,
+// returning the results in the same order.  Their ECC data is only read
+// along with the blobs.
+func VerifyPackfiles(ctx *appcontext.AppContext, repo *repository.Repository, packfiles []objects.MAC, readBlobs bool) ([]Result, error) {
+	results := make([]Result, len(packfiles))
+
+	var ecc map[objects.MAC]struct{}
+	if readBlobs {
+		var err error
+		if ecc, err = withECC(ctx, repo, storage.StorageResourceECCPackfile); err != nil {
+			return nil, err
+		}
+	}
+
+	wg := new(errgroup.Group)
+	wg.SetLimit(max(ctx.MaxConcurrency, 1))
+	for i, mac := range packfiles {
+		wg.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			_, hasECC := ecc[mac]
+			results[i] = VerifyPackfile(repo, mac, readBlobs, hasECC)
+			return nil
+		})
+	}
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ReadStates collects, from the given state files, where blobs are said
+// to live, grouped by packfile.  Reading a state file authenticates it,
+// and so is its ECC data; the ones that fail are returned with their
+// error.  A state whose ECC data alone fails still places its blobs.
+func ReadStates(ctx *appcontext.AppContext, repo *repository.Repository, stateIDs []objects.MAC) (map[objects.MAC][]Blob, map[objects.MAC]error, error) {
+	ecc, err := withECC(ctx, repo, storage.StorageResourceECCState)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	located := make(map[objects.MAC][]Blob)
+	failed := make(map[objects.MAC]error)
+	for _, stateID := range stateIDs {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		if err := readState(ctx, repo, stateID, located); err != nil {
+			failed[stateID] = err
+		} else if _, ok := ecc[stateID]; ok {
+			if err := VerifyECC(repo, storage.StorageResourceECCState, stateID); err != nil {
+				failed[stateID] = err
+			}
+		}
+	}
+	return located, failed, nil
+}
+
+func readState(ctx *appcontext.AppContext, repo *repository.Repository, stateID objects.MAC, located map[objects.MAC][]Blob) error {
+	rd, version, err := repo.GetState(stateID)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	scanCache, err := ctx.GetCache().Scan(objects.RandomMAC())
+	if err != nil {
+		return err
+	}
+	defer scanCache.Close()
+
+	st, err := state.FromStream(rd, version, scanCache)
+	if err != nil {
+		return err
+	}
+
+	// collect first so that a failing state leaves located untouched
+	var blobs []Blob
+	for _, Type := range resources.Types() {
+		for entry, err := range st.ListObjectsOfType(Type) {
+			if err != nil {
+				return err
+			}
+			blobs = append(blobs, Blob{Type: Type, MAC: entry.Blob, Location: entry.Location})
+		}
+	}
+	for _, blob := range blobs {
+		located[blob.Location.Packfile] = append(located[blob.Location.Packfile], blob)
+	}
+	return nil
+}
+
+// CheckIndex makes sure the index of a packfile holds every blob the
+// states place in it, at the same place, and that its entries do not
+// overlap.
+func CheckIndex(index []Blob, located []Blob) error {
+	byKey := make(map[Key][]state.Location, len(index))
+	for _, blob := range index {
+		byKey[blob.Key()] = append(byKey[blob.Key()], blob.Location)
+	}
+	for _, blob := range located {
+		if !slices.Contains(byKey[blob.Key()], blob.Location) {
+			return fmt.Errorf("%s %x at offset %d is not in the packfile index",
+				blob.Type, blob.MAC[:4], blob.Location.Offset)
+		}
+	}
+
+	sorted := slices.Clone(index)
+	slices.SortFunc(sorted, func(a, b Blob) int {
+		return cmp.Compare(a.Location.Offset, b.Location.Offset)
+	})
+	for i := 1; i < len(sorted); i++ {
+		prev, cur := sorted[i-1].Location, sorted[i].Location
+		if uint64(prev.Offset)+uint64(prev.Length) > uint64(cur.Offset) {
+			return fmt.Errorf("index entries overlap at offset %d", cur.Offset)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package scrub
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository/state"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckIndex(t *testing.T) {
+	pf := objects.MAC{0xff}
+	blob := func(mac byte, offset, length int) Blob {
+		return Blob{
+			Type:     resources.RT_CHUNK,
+			MAC:      objects.MAC{mac},
+			Location: state.Location{Packfile: pf, Offset: uint64(offset), Length: uint32(length)},
+		}
+	}
+
+	index := []Blob{blob(2, 10, 10), blob(1, 0, 10)}
+	require.NoError(t, CheckIndex(index, nil))
+	require.NoError(t, CheckIndex(index, []Blob{blob(1, 0, 10)}))
+
+	// not where the state says it is
+	require.Error(t, CheckIndex(index, []Blob{blob(1, 10, 10)}))
+	// not there at all
+	require.Error(t, CheckIndex(index, []Blob{blob(3, 20, 10)}))
+	// overlapping entries
+	require.Error(t, CheckIndex([]Blob{blob(1, 0, 15), blob(2, 10, 10)}, nil))
+}
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"os"
 	"path/filepath"
@@ -33,6 +34,7 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cached"
+	"github.com/PlakarKorp/plakar/pathindex"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/google/uuid"
@@ -338,6 +340,17 @@ func (cmd *Cached) rebuildJob(ctx *appcontext.AppContext, jobChan chan jobReq, r
 
 		repoID := repo.Configuration().RepositoryID
 
+		// The path index has its own job so that clients waiting for the
+		// state never wait behind it.  Requests coalesce: one update
+		// covers every state ingested before it started.
+		indexReq := make(chan struct{}, 1)
+		indexDone := make(chan struct{})
+		go cmd.pathIndexJob(ctx, repo, indexReq, indexDone)
+		defer func() {
+			close(indexReq)
+			<-indexDone
+		}()
+
 	jobLoop:
 		for {
 			select {
@@ -356,6 +369,13 @@ func (cmd *Cached) rebuildJob(ctx *appcontext.AppContext, jobChan chan jobReq, r
 					close(job.ch)
 				}
 
+				if err == nil {
+					select {
+					case indexReq <- struct{}{}:
+					default:
+					}
+				}
+
 				cmd.runningJobs <- jobDone
 
 			// Debounce a bit to avoid halting and creating too many jobs.
@@ -374,6 +394,34 @@ func (cmd *Cached) rebuildJob(ctx *appcontext.AppContext, jobChan chan jobReq, r
 
 }
 
+func (cmd *Cached) pathIndexJob(ctx *appcontext.AppContext, repo *repository.Repository, reqs chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	repoID := repo.Configuration().RepositoryID
+	index, err := pathindex.Load(ctx.CacheDir, repoID)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			ctx.GetLogger().Warn("discarding path index of %s: %v", repoID, err)
+		}
+		index = pathindex.New(ctx.CacheDir, repoID)
+	}
+
+	for range reqs {
+		cmd.runningJobs <- newJob
+
+		t0 := time.Now()
+		changed, err := index.Update(ctx, repo)
+		if err != nil {
+			ctx.GetLogger().Warn("failed to update path index: %v", err)
+		}
+		if changed {
+			ctx.GetLogger().Trace("cached", "path index updated (store=%s): %s", repoID, time.Since(t0))
+		}
+
+		cmd.runningJobs <- jobDone
+	}
+}
+
 func getSecret(ctx *appcontext.AppContext, secret []byte, storageConfig []byte) ([]byte, error) {
 	config, err := storage.NewConfigurationFromWrappedBytes(storageConfig)
 	if err != nil {
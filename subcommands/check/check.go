@@ -19,7 +19,9 @@ package check
 import (
 	"encoding/hex"
 	"fmt"
+	"time"
 
+	"github.com/PlakarKorp/go-human2duration"
 	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
@@ -37,6 +39,14 @@ type Check struct {
 	FastCheck     bool
 	NoVerify      bool
 	Snapshots     []string
+	Repository    bool
+	Sample        string
+	Period        string
+	Report        string
+	Format        string
+
+	samplePercent float64
+	period        time.Duration
 }
 
 func init() {
@@ -51,6 +61,11 @@ func (cmd *Check) CobraCommand() *cobra.Command {
 	}
 	c.Flags().BoolVar(&cmd.NoVerify, "no-verify", false, "disable signature verification")
 	c.Flags().BoolVar(&cmd.FastCheck, "fast", false, "enable fast checking (no digest verification)")
+	c.Flags().BoolVar(&cmd.Repository, "repository", false, "scrub every packfile and state of the repository, with their ECC data, instead of checking snapshots")
+	c.Flags().StringVar(&cmd.Sample, "sample", "", "verify this share of the packfiles, e.g. 5%, instead of checking snapshots")
+	c.Flags().StringVar(&cmd.Period, "period", "30d", "with -sample, verify every packfile at least once per period")
+	c.Flags().StringVar(&cmd.Report, "report", "", "write a report of what was checked and what is damaged to this file, - for stdout")
+	c.Flags().StringVar(&cmd.Format, "format", "json", "format of the report, only json is supported")
 	subcommands.InstallGoFlags(c.Flags(), cmd.LocateOptions.InstallLocateFlags)
 	return c
 }
@@ -61,6 +76,30 @@ func (cmd *Check) Parse(ctx *appcontext.AppContext, args []string) error {
 		return err
 	}
 
+	if cmd.Format != "json" {
+		return fmt.Errorf("-format: unsupported report format: %s", cmd.Format)
+	}
+
+	if cmd.Repository || cmd.Sample != "" {
+		if cmd.Repository && cmd.Sample != "" {
+			return fmt.Errorf("-repository and -sample cannot be combined")
+		}
+		if len(rest) != 0 || !cmd.LocateOptions.Empty() {
+			return fmt.Errorf("-repository and -sample check packfiles and cannot be combined with snapshots or filters")
+		}
+	}
+
+	if cmd.Sample != "" {
+		cmd.samplePercent, err = parsePercent(cmd.Sample)
+		if err != nil {
+			return fmt.Errorf("-sample: %w", err)
+		}
+		cmd.period, err = human2duration.ParseDuration(cmd.Period)
+		if err != nil || cmd.period <= 0 {
+			return fmt.Errorf("-period: invalid duration: %q", cmd.Period)
+		}
+	}
+
 	if len(rest) != 0 && !cmd.LocateOptions.Empty() {
 		ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
 	}
@@ -72,6 +111,13 @@ func (cmd *Check) Parse(ctx *appcontext.AppContext, args []string) error {
 }
 
 func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if cmd.Repository {
+		return cmd.executeRepository(ctx, repo)
+	}
+	if cmd.Sample != "" {
+		return cmd.executeSample(ctx, repo)
+	}
+
 	var snapshots []string
 	if len(cmd.Snapshots) == 0 {
 		snapshotIDs, err := locate.LocateSnapshotIDs(repo, cmd.LocateOptions)
@@ -116,6 +162,9 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 	emitter := repo.Emitter("check")
 	defer emitter.Close()
 
+	report := cmd.newReport(repo)
+	diag := newDiagnosis(repo, cmd.FastCheck)
+
 	var failures int
 	for _, arg := range snapshots {
 		snap, pathname, err := locate.OpenSnapshotByPath(repo, arg)
@@ -126,12 +175,17 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		snap.SetCheckCache(checkCache)
 
 		var failed bool
+		sr := SnapshotReport{
+			Snapshot: fmt.Sprintf("%x", snap.Header.Identifier),
+			Path:     pathname,
+		}
 		if !cmd.NoVerify && snap.Header.Identity.Identifier != uuid.Nil {
 			if ok, err := snap.Verify(); err != nil {
 				ctx.GetLogger().Warn("%s", err)
 			} else if !ok {
 				ctx.GetLogger().Info("snapshot %x signature verification failed", snap.Header.Identifier)
 				failed = true
+				sr.SignatureFailed = true
 			} else {
 				ctx.GetLogger().Info("snapshot %x signature verification succeeded", snap.Header.Identifier)
 			}
@@ -139,15 +193,30 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 
 		if err := snap.Check(pathname, opts); err != nil {
 			failed = true
+			sr.Error = err.Error()
+			if report != nil {
+				sr.Damaged, err = diag.snapshot(ctx, snap, pathname)
+				if err != nil {
+					ctx.GetLogger().Warn("check: could not diagnose snapshot %x: %v", snap.Header.Identifier[:4], err)
+				}
+			}
 		}
 
 		if failed {
 			failures++
 		}
+		sr.OK = !failed
+		if report != nil {
+			report.Snapshots = append(report.Snapshots, sr)
+		}
 
 		snap.Close()
 	}
 
+	if err := cmd.writeReport(ctx, report); err != nil {
+		return 1, err
+	}
+
 	if failures != 0 {
 		snapshots := "snapshots"
 		if failures == 1 {
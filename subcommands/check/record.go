@@ -0,0 +1,113 @@
+package check
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const RECORD_VERSION = "1.0.0"
+
+// verifyRecord remembers, across runs, when each packfile was first seen
+// and last verified, so that sampled checks can go for the packfiles that
+// waited the longest.  It lives in the cache directory: losing it only
+// means starting the rotation over.
+type verifyRecord struct {
+	file      string
+	Version   string
+	Packfiles map[objects.MAC]*packfileRecord
+}
+
+type packfileRecord struct {
+	FirstSeen    time.Time
+	LastVerified time.Time
+}
+
+// since is when the packfile was last known good, or first seen if it
+// never was verified.
+func (r *packfileRecord) since() time.Time {
+	if r.LastVerified.IsZero() {
+		return r.FirstSeen
+	}
+	return r.LastVerified
+}
+
+func newVerifyRecord(cacheDir string, repoID uuid.UUID) *verifyRecord {
+	return &verifyRecord{
+		file:      filepath.Join(cacheDir, "check", RECORD_VERSION, repoID.String()),
+		Version:   RECORD_VERSION,
+		Packfiles: make(map[objects.MAC]*packfileRecord),
+	}
+}
+
+// loadVerifyRecord reads the record of the repository, which is empty if
+// none was saved yet.
+func loadVerifyRecord(cacheDir string, repoID uuid.UUID) (*verifyRecord, error) {
+	rec := newVerifyRecord(cacheDir, repoID)
+
+	data, err := os.ReadFile(rec.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return rec, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var disk verifyRecord
+	if err := msgpack.Unmarshal(data, &disk); err != nil {
+		return nil, fmt.Errorf("could not decode verification record: %w", err)
+	}
+	if disk.Version == RECORD_VERSION && disk.Packfiles != nil {
+		rec.Packfiles = disk.Packfiles
+	}
+	return rec, nil
+}
+
+// sync makes the record match the packfiles of the repository.
+func (rec *verifyRecord) sync(packfiles []objects.MAC, now time.Time) {
+	current := make(map[objects.MAC]struct{}, len(packfiles))
+	for _, mac := range packfiles {
+		current[mac] = struct{}{}
+		if _, ok := rec.Packfiles[mac]; !ok {
+			rec.Packfiles[mac] = &packfileRecord{FirstSeen: now}
+		}
+	}
+	for mac := range rec.Packfiles {
+		if _, ok := current[mac]; !ok {
+			delete(rec.Packfiles, mac)
+		}
+	}
+}
+
+func (rec *verifyRecord) save() error {
+	data, err := msgpack.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(rec.file)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".record-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), rec.file)
+}
@@ -0,0 +1,267 @@
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+)
+
+// Problems found with a blob.
+const (
+	ProblemMissing     = "missing"
+	ProblemNotInState  = "missing-from-state"
+	ProblemDecrypt     = "decrypt"
+	ProblemMACMismatch = "mac-mismatch"
+)
+
+// Report is what -report writes: enough to decide between repairing,
+// restoring from a mirror or accepting the loss.
+type Report struct {
+	Repository string           `json:"repository"`
+	Timestamp  time.Time        `json:"timestamp"`
+	Snapshots  []SnapshotReport `json:"snapshots,omitempty"`
+	Packfiles  []PackfileReport `json:"packfiles,omitempty"`
+
+	// filled by -repository
+	States        []StateReport   `json:"states,omitempty"`
+	Duplicates    []DuplicateBlob `json:"duplicates,omitempty"`
+	DanglingBlobs int             `json:"dangling_blobs,omitempty"`
+}
+
+type SnapshotReport struct {
+	Snapshot        string        `json:"snapshot"`
+	Path            string        `json:"path"`
+	OK              bool          `json:"ok"`
+	SignatureFailed bool          `json:"signature_failed,omitempty"`
+	Error           string        `json:"error,omitempty"`
+	Damaged         []DamagedPath `json:"damaged,omitempty"`
+}
+
+// DamagedPath is a path that cannot be restored as it was backed up.
+type DamagedPath struct {
+	Path  string        `json:"path"`
+	Error string        `json:"error,omitempty"`
+	Blobs []DamagedBlob `json:"blobs,omitempty"`
+}
+
+type DamagedBlob struct {
+	Type     string `json:"type"`
+	MAC      string `json:"mac"`
+	Packfile string `json:"packfile,omitempty"`
+	Problem  string `json:"problem"`
+	Error    string `json:"error,omitempty"`
+}
+
+type PackfileReport struct {
+	Packfile string `json:"packfile"`
+	OK       bool   `json:"ok"`
+	Blobs    int    `json:"blobs"`
+	Size     int64  `json:"size"`
+	Problem  string `json:"problem,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type StateReport struct {
+	State string `json:"state"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// DuplicateBlob is a blob stored in more than one packfile, which wastes
+// space but is otherwise harmless.
+type DuplicateBlob struct {
+	Type      string   `json:"type"`
+	MAC       string   `json:"mac"`
+	Packfiles []string `json:"packfiles"`
+}
+
+// newReport returns the report to fill, or nil if none was asked for.
+func (cmd *Check) newReport(repo *repository.Repository) *Report {
+	if cmd.Report == "" {
+		return nil
+	}
+	return &Report{
+		Repository: repo.Configuration().RepositoryID.String(),
+		Timestamp:  time.Now(),
+	}
+}
+
+func (cmd *Check) writeReport(ctx *appcontext.AppContext, report *Report) error {
+	if report == nil {
+		return nil
+	}
+
+	var w io.Writer = ctx.Stdout
+	if cmd.Report != "-" {
+		fp, err := os.Create(cmd.Report)
+		if err != nil {
+			return fmt.Errorf("check: could not write report: %w", err)
+		}
+		defer fp.Close()
+		w = fp
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("check: could not write report: %w", err)
+	}
+	return nil
+}
+
+// diagnosis pins down what is damaged in a snapshot that failed its
+// check.  Blobs are located through the state, the packfile indexes are
+// only read for the blobs the state has no location for, since it means
+// fetching all of them.
+type diagnosis struct {
+	repo *repository.Repository
+	fast bool
+
+	located map[objects.MAC]objects.MAC
+	blobs   map[objects.MAC]*DamagedBlob
+}
+
+func newDiagnosis(repo *repository.Repository, fast bool) *diagnosis {
+	return &diagnosis{
+		repo:  repo,
+		fast:  fast,
+		blobs: make(map[objects.MAC]*DamagedBlob),
+	}
+}
+
+// locate finds the packfile holding a chunk and tells whether the state
+// knows it there, which reading the chunk requires.
+func (d *diagnosis) locate(mac objects.MAC) (objects.MAC, bool, bool, error) {
+	packfile, ok, err := d.repo.GetPackfileForBlob(resources.RT_CHUNK, mac)
+	if err != nil {
+		return objects.MAC{}, false, false, err
+	}
+	if ok {
+		return packfile, true, true, nil
+	}
+
+	if d.located == nil {
+		packfiles, err := d.repo.GetPackfiles()
+		if err != nil {
+			return objects.MAC{}, false, false, err
+		}
+		located := make(map[objects.MAC]objects.MAC)
+		for _, packfile := range packfiles {
+			p, err := d.repo.GetPackfile(packfile)
+			if err != nil {
+				// its blobs show up as missing, and the packfile
+				// in the sampled check
+				continue
+			}
+			for _, entry := range p.Index {
+				located[entry.MAC] = packfile
+			}
+		}
+		d.located = located
+	}
+	packfile, ok = d.located[mac]
+	return packfile, ok, false, nil
+}
+
+// chunk returns what is wrong with a chunk, or nil if it is fine.
+func (d *diagnosis) chunk(mac objects.MAC) (*DamagedBlob, error) {
+	if damage, ok := d.blobs[mac]; ok {
+		return damage, nil
+	}
+
+	packfile, ok, inState, err := d.locate(mac)
+	if err != nil {
+		return nil, err
+	}
+
+	damage := &DamagedBlob{
+		Type: resources.RT_CHUNK.String(),
+		MAC:  fmt.Sprintf("%x", mac),
+	}
+	if ok {
+		damage.Packfile = fmt.Sprintf("%x", packfile)
+	}
+
+	switch {
+	case !ok:
+		damage.Problem = ProblemMissing
+	case !inState:
+		// stored, but restoring can't find it without a location
+		damage.Problem = ProblemNotInState
+	case d.fast:
+		damage = nil
+	default:
+		data, err := d.readBlob(resources.RT_CHUNK, mac)
+		if err != nil {
+			damage.Problem, damage.Error = ProblemDecrypt, err.Error()
+		} else if d.repo.ComputeMAC(data) != mac {
+			damage.Problem = ProblemMACMismatch
+		} else {
+			damage = nil
+		}
+	}
+
+	d.blobs[mac] = damage
+	return damage, nil
+}
+
+func (d *diagnosis) readBlob(t resources.Type, mac objects.MAC) ([]byte, error) {
+	rd, err := d.repo.GetBlob(t, mac)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rd)
+}
+
+// snapshot lists the damaged paths below pathname.
+func (d *diagnosis) snapshot(ctx *appcontext.AppContext, snap *snapshot.Snapshot, pathname string) ([]DamagedPath, error) {
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	var damaged []DamagedPath
+	err = fs.WalkDir(pathname, func(p string, e *vfs.Entry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			damaged = append(damaged, DamagedPath{Path: p, Error: err.Error()})
+			return nil
+		}
+		if !e.Stat().Mode().IsRegular() {
+			return nil
+		}
+		if e.ResolvedObject == nil {
+			if e.Stat().Size() != 0 {
+				damaged = append(damaged, DamagedPath{Path: p, Error: "object is missing"})
+			}
+			return nil
+		}
+
+		var blobs []DamagedBlob
+		for _, chunk := range e.ResolvedObject.Chunks {
+			damage, err := d.chunk(chunk.ContentMAC)
+			if err != nil {
+				return err
+			}
+			if damage != nil {
+				blobs = append(blobs, *damage)
+			}
+		}
+		if len(blobs) != 0 {
+			damaged = append(damaged, DamagedPath{Path: p, Blobs: blobs})
+		}
+		return nil
+	})
+	return damaged, err
+}
@@ -0,0 +1,98 @@
+package check
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteCmdCheckReport(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-report", reportFile, "-format", "json"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	data, err := os.ReadFile(reportFile)
+	require.NoError(t, err)
+
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Equal(t, repo.Configuration().RepositoryID.String(), report.Repository)
+	require.Len(t, report.Snapshots, 1)
+	require.Equal(t, fmt.Sprintf("%x", snap.Header.Identifier), report.Snapshots[0].Snapshot)
+	require.True(t, report.Snapshots[0].OK)
+	require.Empty(t, report.Snapshots[0].Damaged)
+	require.Empty(t, report.Packfiles)
+}
+
+func TestExecuteCmdCheckSampleReport(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-sample", "100%", "-report", reportFile}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	data, err := os.ReadFile(reportFile)
+	require.NoError(t, err)
+
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Empty(t, report.Snapshots)
+	require.NotEmpty(t, report.Packfiles)
+	for _, p := range report.Packfiles {
+		require.True(t, p.OK, p.Error)
+		require.NotZero(t, p.Blobs)
+	}
+}
+
+func TestCheckParseReportFormat(t *testing.T) {
+	_, snap, ctx := generateSnapshot(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+	defer snap.Close()
+
+	require.NoError(t, (&Check{}).Parse(ctx, []string{"-report", "-"}))
+	require.Error(t, (&Check{}).Parse(ctx, []string{"-report", "-", "-format", "yaml"}))
+}
+
+func TestDiagnosisChunk(t *testing.T) {
+	repo, snap, _ := generateSnapshot(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+	defer snap.Close()
+
+	d := newDiagnosis(repo, false)
+	d.located = make(map[objects.MAC]objects.MAC)
+
+	// a chunk nowhere to be found
+	damage, err := d.chunk(objects.MAC{1})
+	require.NoError(t, err)
+	require.Equal(t, ProblemMissing, damage.Problem)
+
+	// a chunk only the packfile indexes know of can't be read, it is not
+	// a decryption failure
+	d.located[objects.MAC{2}] = objects.MAC{3}
+	damage, err = d.chunk(objects.MAC{2})
+	require.NoError(t, err)
+	require.Equal(t, ProblemNotInState, damage.Problem)
+	require.Equal(t, fmt.Sprintf("%x", objects.MAC{3}), damage.Packfile)
+}
@@ -0,0 +1,175 @@
+package check
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/exitcodes"
+	"github.com/PlakarKorp/plakar/scrub"
+	"github.com/dustin/go-humanize"
+)
+
+// executeRepository scrubs the whole repository, whether snapshots
+// reference the data or not: state files, every packfile in the storage
+// and how the two agree.
+func (cmd *Check) executeRepository(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	report := cmd.newReport(repo)
+
+	stateIDs, err := repo.GetStates()
+	if err != nil {
+		return 1, fmt.Errorf("check: could not list states: %w", err)
+	}
+	located, failedStates, err := scrub.ReadStates(ctx, repo, stateIDs)
+	if err != nil {
+		return 1, fmt.Errorf("check: %w", err)
+	}
+
+	failures := len(failedStates)
+	for _, stateID := range stateIDs {
+		err := failedStates[stateID]
+		if err != nil {
+			ctx.GetLogger().Error("check: state %x: %s", stateID, err)
+		}
+		if report != nil {
+			sr := StateReport{State: fmt.Sprintf("%x", stateID), OK: err == nil}
+			if err != nil {
+				sr.Error = err.Error()
+			}
+			report.States = append(report.States, sr)
+		}
+	}
+
+	packfiles, err := repo.GetPackfiles()
+	if err != nil {
+		return 1, fmt.Errorf("check: could not list packfiles: %w", err)
+	}
+
+	inState := make(map[objects.MAC]struct{})
+	for mac := range repo.ListPackfiles() {
+		inState[mac] = struct{}{}
+	}
+
+	results, err := scrub.VerifyPackfiles(ctx, repo, packfiles, !cmd.FastCheck)
+	if err != nil {
+		return 1, err
+	}
+
+	inStorage := make(map[objects.MAC]struct{}, len(packfiles))
+	indexed := make(map[scrub.Key][]objects.MAC)
+	var size int64
+	var orphans int
+	for i := range results {
+		res := &results[i]
+		inStorage[res.MAC] = struct{}{}
+		size += res.Size
+
+		for _, blob := range res.Index {
+			indexed[blob.Key()] = append(indexed[blob.Key()], res.MAC)
+		}
+		if res.Problem != scrub.ProblemUnreadable {
+			if err := scrub.CheckIndex(res.Index, located[res.MAC]); err != nil && res.Err == nil {
+				res.Problem, res.Err = scrub.ProblemIndexMismatch, err
+			}
+		}
+
+		if res.Err == nil {
+			if _, ok := inState[res.MAC]; !ok {
+				deleted, err := repo.HasDeletedPackfile(res.MAC)
+				if err != nil {
+					return 1, err
+				}
+				// coloured packfiles are maintenance's business
+				if !deleted {
+					res.Problem = scrub.ProblemOrphan
+					orphans++
+					ctx.GetLogger().Warn("check: packfile %x is not referenced by the state", res.MAC)
+				}
+			}
+		} else {
+			failures++
+			ctx.GetLogger().Error("check: packfile %x: %s", res.MAC, res.Err)
+		}
+
+		if report != nil {
+			pr := PackfileReport{
+				Packfile: fmt.Sprintf("%x", res.MAC),
+				OK:       res.Err == nil,
+				Blobs:    res.Blobs,
+				Size:     res.Size,
+				Problem:  res.Problem,
+			}
+			if res.Err != nil {
+				pr.Error = res.Err.Error()
+			}
+			report.Packfiles = append(report.Packfiles, pr)
+		}
+	}
+
+	for mac := range inState {
+		if _, ok := inStorage[mac]; ok {
+			continue
+		}
+		deleted, err := repo.HasDeletedPackfile(mac)
+		if err != nil {
+			return 1, err
+		}
+		if deleted {
+			continue
+		}
+		failures++
+		ctx.GetLogger().Error("check: packfile %x is referenced by the state but missing from the storage", mac)
+		if report != nil {
+			report.Packfiles = append(report.Packfiles, PackfileReport{
+				Packfile: fmt.Sprintf("%x", mac),
+				Problem:  scrub.ProblemMissing,
+			})
+		}
+	}
+
+	duplicates := 0
+	for key, macs := range indexed {
+		if len(macs) < 2 {
+			continue
+		}
+		duplicates++
+		if report != nil {
+			dup := DuplicateBlob{Type: key.Type.String(), MAC: fmt.Sprintf("%x", key.MAC)}
+			for _, mac := range macs {
+				dup.Packfiles = append(dup.Packfiles, fmt.Sprintf("%x", mac))
+			}
+			slices.Sort(dup.Packfiles)
+			report.Duplicates = append(report.Duplicates, dup)
+		}
+	}
+	if report != nil {
+		slices.SortFunc(report.Duplicates, func(a, b DuplicateBlob) int {
+			return strings.Compare(a.MAC, b.MAC)
+		})
+	}
+
+	dangling := 0
+	for _, err := range repo.ListOrphanBlobs() {
+		if err == nil {
+			dangling++
+		}
+	}
+	if report != nil {
+		report.DanglingBlobs = dangling
+	}
+
+	if err := cmd.writeReport(ctx, report); err != nil {
+		return 1, err
+	}
+
+	ctx.GetLogger().Info("check: scrubbed %d packfiles (%s) and %d states: %d orphan packfiles, %d duplicate blobs, %d dangling blobs",
+		len(packfiles), humanize.IBytes(uint64(size)), len(stateIDs), orphans, duplicates, dangling)
+
+	if failures != 0 {
+		return exitcodes.IntegrityFailure, fmt.Errorf("check failed with %d errors", failures)
+	}
+	return 0, nil
+}
@@ -0,0 +1,150 @@
+package check
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/storage"
+	"github.com/PlakarKorp/plakar/exitcodes"
+	"github.com/PlakarKorp/plakar/scrub"
+	"github.com/stretchr/testify/require"
+)
+
+// copyPackfile stores a copy of a packfile under a new MAC, which the
+// state knows nothing about.
+func copyPackfile(t *testing.T, repo *repository.Repository) objects.MAC {
+	t.Helper()
+	var source objects.MAC
+	for mac := range repo.ListPackfiles() {
+		source = mac
+		break
+	}
+	rd, err := repo.Store().Get(repo.AppContext(), storage.StorageResourcePackfile, source, nil)
+	require.NoError(t, err)
+	defer rd.Close()
+	raw, err := io.ReadAll(rd)
+	require.NoError(t, err)
+
+	orphan := objects.RandomMAC()
+	_, err = repo.Store().Put(repo.AppContext(), storage.StorageResourcePackfile, orphan, bytes.NewReader(raw))
+	require.NoError(t, err)
+	return orphan
+}
+
+func TestExecuteCmdCheckRepository(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repository", "-report", reportFile}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "check: scrubbed")
+
+	data, err := os.ReadFile(reportFile)
+	require.NoError(t, err)
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.NotEmpty(t, report.States)
+	require.NotEmpty(t, report.Packfiles)
+	for _, p := range report.Packfiles {
+		require.True(t, p.OK, p.Error)
+		require.Empty(t, p.Problem)
+	}
+	require.Empty(t, report.Duplicates)
+}
+
+func TestExecuteCmdCheckRepositoryOrphan(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+	orphan := copyPackfile(t, repo)
+
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repository", "-report", reportFile}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	data, err := os.ReadFile(reportFile)
+	require.NoError(t, err)
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+
+	found := false
+	for _, p := range report.Packfiles {
+		if p.Packfile == fmt.Sprintf("%x", orphan) {
+			found = true
+			require.Equal(t, scrub.ProblemOrphan, p.Problem)
+		}
+	}
+	require.True(t, found)
+	// the copy holds the same blobs as the original
+	require.NotEmpty(t, report.Duplicates)
+}
+
+func TestExecuteCmdCheckRepositoryECC(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	var packfile objects.MAC
+	for mac := range repo.ListPackfiles() {
+		packfile = mac
+		break
+	}
+	// ECC data that was never encoded cannot be authenticated
+	_, err := repo.Store().Put(repo.AppContext(), storage.StorageResourceECCPackfile, packfile, bytes.NewReader([]byte("not ecc data")))
+	require.NoError(t, err)
+
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repository", "-report", reportFile}))
+	status, err := cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, exitcodes.IntegrityFailure, status)
+
+	data, err := os.ReadFile(reportFile)
+	require.NoError(t, err)
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+
+	found := false
+	for _, p := range report.Packfiles {
+		if p.Packfile == fmt.Sprintf("%x", packfile) {
+			found = true
+			require.False(t, p.OK)
+			require.Equal(t, scrub.ProblemECC, p.Problem)
+		}
+	}
+	require.True(t, found)
+}
+
+func TestCheckParseRepository(t *testing.T) {
+	_, snap, ctx := generateSnapshot(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+	defer snap.Close()
+
+	require.NoError(t, (&Check{}).Parse(ctx, []string{"-repository"}))
+	require.Error(t, (&Check{}).Parse(ctx, []string{"-repository", "-sample", "5%"}))
+	require.Error(t, (&Check{}).Parse(ctx, []string{"-repository", "abcd"}))
+}
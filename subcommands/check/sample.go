@@ -0,0 +1,123 @@
+package check
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/exitcodes"
+	"github.com/PlakarKorp/plakar/scrub"
+	"github.com/dustin/go-humanize"
+)
+
+// parsePercent accepts "5%" as well as "5".
+func parsePercent(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid percentage: %q", s)
+	}
+	if v <= 0 || v > 100 || math.IsNaN(v) {
+		return 0, fmt.Errorf("percentage must be greater than 0 and at most 100: %q", s)
+	}
+	return v, nil
+}
+
+// selectPackfiles picks the packfiles to verify in this run: the share
+// asked for, or more if that is what it takes to verify every packfile
+// that went unverified for a whole period.  The ones that waited the
+// longest go first, ties broken at random so that a fresh record is
+// sampled evenly.
+func selectPackfiles(rec *verifyRecord, packfiles []objects.MAC, percent float64, period time.Duration, now time.Time, rnd *rand.Rand) (selected []objects.MAC, overdue int) {
+	candidates := slices.Clone(packfiles)
+	rnd.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	slices.SortStableFunc(candidates, func(a, b objects.MAC) int {
+		return rec.Packfiles[a].since().Compare(rec.Packfiles[b].since())
+	})
+
+	for _, mac := range candidates {
+		if !rec.Packfiles[mac].since().Add(period).After(now) {
+			overdue++
+		}
+	}
+
+	n := int(math.Ceil(float64(len(candidates)) * percent / 100))
+	return candidates[:min(max(n, overdue), len(candidates))], overdue
+}
+
+func (cmd *Check) executeSample(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	rec, err := loadVerifyRecord(ctx.CacheDir, repo.Configuration().RepositoryID)
+	if err != nil {
+		ctx.GetLogger().Warn("check: starting a new verification record: %v", err)
+		rec = newVerifyRecord(ctx.CacheDir, repo.Configuration().RepositoryID)
+	}
+
+	packfiles, err := repo.GetPackfiles()
+	if err != nil {
+		return 1, fmt.Errorf("check: could not list packfiles: %w", err)
+	}
+
+	now := time.Now()
+	rec.sync(packfiles, now)
+	selected, overdue := selectPackfiles(rec, packfiles, cmd.samplePercent, cmd.period, now, rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())))
+
+	results, err := scrub.VerifyPackfiles(ctx, repo, selected, true)
+	if err != nil {
+		return 1, err
+	}
+
+	report := cmd.newReport(repo)
+
+	var size int64
+	var failures int
+	for _, res := range results {
+		if report != nil {
+			pr := PackfileReport{
+				Packfile: fmt.Sprintf("%x", res.MAC),
+				OK:       res.Err == nil,
+				Blobs:    res.Blobs,
+				Size:     res.Size,
+				Problem:  res.Problem,
+			}
+			if res.Err != nil {
+				pr.Error = res.Err.Error()
+			}
+			report.Packfiles = append(report.Packfiles, pr)
+		}
+		if res.Err != nil {
+			failures++
+			ctx.GetLogger().Error("check: packfile %x: %s", res.MAC, res.Err)
+		} else {
+			rec.Packfiles[res.MAC].LastVerified = now
+			size += res.Size
+		}
+	}
+
+	if err := rec.save(); err != nil {
+		ctx.GetLogger().Warn("check: could not save verification record: %v", err)
+	}
+
+	if err := cmd.writeReport(ctx, report); err != nil {
+		return 1, err
+	}
+
+	ctx.GetLogger().Info("check: verified %d of %d packfiles (%s), %d overdue",
+		len(selected)-failures, len(packfiles), humanize.IBytes(uint64(size)), overdue)
+
+	if failures != 0 {
+		packfiles := "packfiles"
+		if failures == 1 {
+			packfiles = "packfile"
+		}
+		return exitcodes.IntegrityFailure, fmt.Errorf("check failed for %d %s", failures, packfiles)
+	}
+	return 0, nil
+}
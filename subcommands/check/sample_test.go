@@ -0,0 +1,121 @@
+package check
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePercent(t *testing.T) {
+	for in, want := range map[string]float64{"5%": 5, "5": 5, "0.5%": 0.5, "100%": 100} {
+		got, err := parsePercent(in)
+		require.NoError(t, err, in)
+		require.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"", "0", "0%", "101%", "-5%", "five"} {
+		_, err := parsePercent(in)
+		require.Error(t, err, in)
+	}
+}
+
+func TestVerifyRecordRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	id := uuid.New()
+
+	rec, err := loadVerifyRecord(dir, id)
+	require.NoError(t, err)
+	require.Empty(t, rec.Packfiles)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	a, b, c := objects.MAC{1}, objects.MAC{2}, objects.MAC{3}
+	rec.sync([]objects.MAC{a, b}, now)
+	rec.Packfiles[a].LastVerified = now
+	require.NoError(t, rec.save())
+
+	rec, err = loadVerifyRecord(dir, id)
+	require.NoError(t, err)
+	require.Len(t, rec.Packfiles, 2)
+	require.True(t, rec.Packfiles[a].LastVerified.Equal(now))
+	require.True(t, rec.Packfiles[b].LastVerified.IsZero())
+
+	// packfiles that went away are forgotten
+	rec.sync([]objects.MAC{b, c}, now)
+	require.Len(t, rec.Packfiles, 2)
+	require.NotContains(t, rec.Packfiles, a)
+	require.Contains(t, rec.Packfiles, c)
+}
+
+func TestSelectPackfiles(t *testing.T) {
+	now := time.Now()
+	period := 30 * 24 * time.Hour
+	rec := newVerifyRecord(t.TempDir(), uuid.New())
+
+	var packfiles []objects.MAC
+	for i := range 100 {
+		packfiles = append(packfiles, objects.MAC{byte(i)})
+	}
+	rec.sync(packfiles, now.Add(-period/2))
+	rnd := rand.New(rand.NewPCG(1, 2))
+
+	selected, overdue := selectPackfiles(rec, packfiles, 5, period, now, rnd)
+	require.Len(t, selected, 5)
+	require.Zero(t, overdue)
+
+	// the oldest packfiles go first
+	old := []objects.MAC{{10}, {20}, {30}}
+	for i, mac := range old {
+		rec.Packfiles[mac].LastVerified = now.Add(-period/2 - time.Duration(len(old)-i)*time.Hour)
+	}
+	selected, _ = selectPackfiles(rec, packfiles, 3, period, now, rnd)
+	require.Equal(t, old, selected)
+
+	// overdue packfiles are all verified, whatever the share asked for
+	for _, mac := range packfiles[:20] {
+		rec.Packfiles[mac].LastVerified = now.Add(-period - time.Hour)
+	}
+	selected, overdue = selectPackfiles(rec, packfiles, 5, period, now, rnd)
+	require.Equal(t, 20, overdue)
+	require.Len(t, selected, 20)
+	require.ElementsMatch(t, packfiles[:20], selected)
+}
+
+func TestExecuteCmdCheckSample(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-sample", "100%"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	rec, err := loadVerifyRecord(ctx.CacheDir, repo.Configuration().RepositoryID)
+	require.NoError(t, err)
+	require.NotEmpty(t, rec.Packfiles)
+	for _, p := range rec.Packfiles {
+		require.False(t, p.LastVerified.IsZero())
+	}
+	require.Contains(t, bufOut.String(), "check: verified")
+}
+
+func TestCheckParseSample(t *testing.T) {
+	_, snap, ctx := generateSnapshot(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+	defer snap.Close()
+
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-sample", "5%", "-period", "2w"}))
+	require.Equal(t, 5.0, cmd.samplePercent)
+	require.Equal(t, 14*24*time.Hour, cmd.period)
+
+	require.Error(t, (&Check{}).Parse(ctx, []string{"-sample", "0%"}))
+	require.Error(t, (&Check{}).Parse(ctx, []string{"-sample", "5%", "-period", "soon"}))
+	require.Error(t, (&Check{}).Parse(ctx, []string{"-sample", "5%", "abcd"}))
+}
@@ -44,10 +44,18 @@ func init() {
 
 func (cmd *Diff) CobraCommand() *cobra.Command {
 	c := &cobra.Command{
-		Use: "diff [OPTIONS] SNAPSHOT:PATH SNAPSHOT[:PATH]",
+		Use: "diff [OPTIONS] SNAPSHOT:PATH [SNAPSHOT[:PATH]]",
 	}
 	c.Flags().BoolVar(&cmd.Highlight, "highlight", false, "highlight output")
 	c.Flags().BoolVar(&cmd.Recursive, "recursive", false, "recursive diff of directories")
+	c.Flags().BoolVar(&cmd.Summary, "summary", false, "classify every path instead of showing content differences")
+	c.Flags().StringVar(&cmd.Format, "format", "text", "summary output format: text, json, csv")
+	c.Flags().BoolVar(&cmd.Unchanged, "unchanged", false, "also report unchanged paths in the summary")
+	c.Flags().BoolVar(&cmd.Metadata, "metadata", false, "report mode, owner, mtime, size, symlink target and xattr changes")
+	c.Flags().BoolVar(&cmd.DetectRenames, "detect-renames", false, "report removed and added files with the same content as renames")
+	c.Flags().IntVar(&cmd.RenameThreshold, "rename-threshold", 100, "with -detect-renames, minimum percentage of shared chunks to pair two files")
+	c.Flags().StringVar(&cmd.Source, "source", "", "compare the snapshot against this importer location or @source")
+	c.Flags().BoolVar(&cmd.Content, "content", false, "with -source, compare the data of files whose size and type match")
 	return c
 }
 
@@ -66,6 +74,27 @@ func (cmd *Diff) Parse(ctx *appcontext.AppContext, args []string) error {
 	} else {
 		return fmt.Errorf("needs at least a snapshot ID and/or snapshot file to diff")
 	}
+
+	switch cmd.Format {
+	case "text", "json", "csv":
+	default:
+		return fmt.Errorf("unsupported format: %s", cmd.Format)
+	}
+	if cmd.RenameThreshold < 1 || cmd.RenameThreshold > 100 {
+		return fmt.Errorf("-rename-threshold must be between 1 and 100")
+	}
+	if cmd.DetectRenames && cmd.Path2 == "" {
+		return fmt.Errorf("-detect-renames needs two snapshots to compare")
+	}
+	if cmd.Source != "" {
+		if cmd.Path2 != "" {
+			return fmt.Errorf("-source compares a single snapshot")
+		}
+	} else if cmd.Content {
+		return fmt.Errorf("-content is only valid with -source")
+	} else if cmd.Summary && cmd.Path2 == "" {
+		return fmt.Errorf("-summary needs two snapshots to compare")
+	}
 	cmd.RepositorySecret = ctx.GetSecret()
 
 	return nil
@@ -76,8 +105,25 @@ type Diff struct {
 
 	Highlight bool
 	Recursive bool
+	Summary   bool
+	Format    string
+	Unchanged bool
+	Metadata  bool
+	Source    string
+	Content   bool
 	Path1     string
 	Path2     string
+
+	DetectRenames   bool
+	RenameThreshold int
+
+	meta1 *metaSource
+	meta2 *metaSource
+
+	// renames maps the old path of each detected rename to its new one,
+	// and renamedTo the other way around.
+	renames   map[string]string
+	renamedTo map[string]string
 }
 
 func (cmd *Diff) Name() string {
@@ -96,13 +142,39 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 	}
 	id1 := fmt.Sprintf("%x", snap1.Header.GetIndexShortID())
 
+	if cmd.Source != "" {
+		imp, err := newSourceImporter(ctx, cmd.Source)
+		if err != nil {
+			return 1, fmt.Errorf("diff: failed to create an importer for %s: %w", cmd.Source, err)
+		}
+		defer imp.Close(ctx)
+
+		if pathname1 == "" {
+			pathname1 = imp.Root()
+		}
+		if err := cmd.diffSource(ctx, vfs1, path.Clean(pathname1), imp); err != nil {
+			return 1, fmt.Errorf("diff: %w", err)
+		}
+		return 0, nil
+	}
+
 	var pathname2 string
 	var id2 string
 	var vfs2 fs.FS
 
+	if cmd.Metadata {
+		cmd.meta1, err = newSnapshotMetaSource(repo, snap1, vfs1)
+		if err != nil {
+			return 1, fmt.Errorf("diff: could not load metadata for snapshot: %s", cmd.Path1)
+		}
+	}
+
 	if cmd.Path2 == "" {
 		vfs2 = os.DirFS("/")
 		id2 = "local"
+		if cmd.Metadata {
+			cmd.meta2 = newLocalMetaSource(vfs2)
+		}
 	} else {
 		var snap2 *snapshot.Snapshot
 		snap2, pathname2, err = locate.OpenSnapshotByPath(repo, cmd.Path2)
@@ -110,11 +182,18 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 			return 1, fmt.Errorf("diff: could not open snapshot: %s", cmd.Path2)
 		}
 		defer snap2.Close()
-		vfs2, err = snap2.Filesystem()
+		fs2, err := snap2.Filesystem()
 		if err != nil {
 			return 1, fmt.Errorf("diff: could not get filesystem for snapshot: %s", cmd.Path2)
 		}
+		vfs2 = fs2
 		id2 = fmt.Sprintf("%x", snap2.Header.GetIndexShortID())
+		if cmd.Metadata {
+			cmd.meta2, err = newSnapshotMetaSource(repo, snap2, fs2)
+			if err != nil {
+				return 1, fmt.Errorf("diff: could not load metadata for snapshot: %s", cmd.Path2)
+			}
+		}
 	}
 
 	if pathname1 == "" && pathname2 == "" {
@@ -126,6 +205,14 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		pathname2 = pathname1
 	}
 
+	if cmd.Summary {
+		err = cmd.summary(ctx, vfs1, pathname1, vfs2.(*vfs.Filesystem), pathname2)
+		if err != nil {
+			return 1, fmt.Errorf("diff: %w", err)
+		}
+		return 0, nil
+	}
+
 	var (
 		out     = ctx.Stdout
 		builder = strings.Builder{}
@@ -134,6 +221,14 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		out = &builder
 	}
 
+	if cmd.DetectRenames {
+		err = cmd.findRenames(ctx, vfs1, pathname1, vfs2.(*vfs.Filesystem), pathname2)
+		if err != nil {
+			return 1, fmt.Errorf("diff: %w", err)
+		}
+		cmd.printRenames(out)
+	}
+
 	err = cmd.diff_pathnames(out, id1, vfs1, pathname1, id2, vfs2, pathname2)
 	if err != nil {
 		return 1, fmt.Errorf("diff: could not diff pathnames: %w", err)
@@ -148,6 +243,104 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 	return 0, nil
 }
 
+func (cmd *Diff) summary(ctx *appcontext.AppContext, vfs1 *vfs.Filesystem, pathname1 string, vfs2 *vfs.Filesystem, pathname2 string) error {
+	w, err := newSummaryWriter(ctx.Stdout, cmd.Format)
+	if err != nil {
+		return err
+	}
+
+	write := func(c *Change) error {
+		if c.Status == StatusUnchanged && !cmd.Unchanged {
+			return nil
+		}
+		return w.Write(c)
+	}
+
+	// renames can only be told once both sides have been walked
+	var changes []*Change
+	emit := write
+	if cmd.DetectRenames {
+		emit = func(c *Change) error {
+			changes = append(changes, c)
+			return nil
+		}
+	}
+
+	err = summarize(ctx, vfs1, pathname1, vfs2, pathname2, func(c *Change) error {
+		if cmd.Metadata && c.Status != StatusAdded && c.Status != StatusRemoved {
+			c.Metadata, err = cmd.metadataChanges(c.Path, c.newPath)
+			if err != nil {
+				return err
+			}
+			if c.Status == StatusUnchanged && len(c.Metadata) != 0 {
+				c.Status = StatusMetadata
+			}
+		}
+		return emit(c)
+	})
+	if err != nil {
+		return err
+	}
+
+	if cmd.DetectRenames {
+		for _, c := range detectRenames(changes, cmd.RenameThreshold) {
+			if err := write(c); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// findRenames walks both trees the way -summary does to find the renames
+// the directory diffs then report instead of "Only in" lines.
+func (cmd *Diff) findRenames(ctx *appcontext.AppContext, vfs1 *vfs.Filesystem, pathname1 string, vfs2 *vfs.Filesystem, pathname2 string) error {
+	var changes []*Change
+	err := summarize(ctx, vfs1, pathname1, vfs2, pathname2, func(c *Change) error {
+		if c.Status == StatusAdded || c.Status == StatusRemoved {
+			changes = append(changes, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	cmd.renames = make(map[string]string)
+	cmd.renamedTo = make(map[string]string)
+	for _, c := range detectRenames(changes, cmd.RenameThreshold) {
+		if c.Status == StatusRenamed {
+			cmd.renames[c.Path] = c.NewPath
+			cmd.renamedTo[c.NewPath] = c.Path
+		}
+	}
+	return nil
+}
+
+func (cmd *Diff) printRenames(out io.Writer) {
+	from := make([]string, 0, len(cmd.renames))
+	for p := range cmd.renames {
+		from = append(from, p)
+	}
+	sort.Strings(from)
+	for _, p := range from {
+		fmt.Fprintf(out, "Renamed: %s -> %s\n", utils.SanitizeText(p), utils.SanitizeText(cmd.renames[p]))
+	}
+}
+
+// onlyIn reports a name found in a single directory, unless it is one end
+// of a rename that was already reported.
+func (cmd *Diff) onlyIn(out io.Writer, dir string, name string) {
+	full := path.Join(dir, name)
+	if _, ok := cmd.renames[full]; ok {
+		return
+	}
+	if _, ok := cmd.renamedTo[full]; ok {
+		return
+	}
+	fmt.Fprintf(out, "Only in %s: %s\n", dir, name)
+}
+
 func (cmd *Diff) diff_pathnames(out io.Writer, id1 string, vfs1 fs.FS, pathname1 string, id2 string, vfs2 fs.FS, pathname2 string) error {
 	fsobj1, err := vfs1.Open(pathname1)
 	if err != nil {
@@ -183,7 +376,11 @@ func (cmd *Diff) diff_pathnames(out io.Writer, id1 string, vfs1 fs.FS, pathname1
 	} else if st1.IsDir() || st2.IsDir() {
 		return fmt.Errorf("can't diff different file types")
 	} else {
-		return cmd.diff_readers(out, id1, pathname1, fsobj1, id2, pathname2, fsobj2)
+		err := cmd.diff_readers(out, id1, pathname1, fsobj1, id2, pathname2, fsobj2)
+		if err != nil {
+			return err
+		}
+		return cmd.diff_metadata(out, pathname1, pathname2)
 	}
 }
 
@@ -226,14 +423,19 @@ func (cmd *Diff) diff_directories_flat(out io.Writer, pathname1 string, fsobj1 f
 				fmt.Fprintf(out, "Common subdirectories: %s and %s\n", name, name)
 			} else if e1.IsDir() != e2.IsDir() {
 				fmt.Fprintf(out, "File type mismatch: %s (dir=%v) vs %s (dir=%v)\n", name, e1.IsDir(), name, e2.IsDir())
+				continue
+			}
+			err := cmd.diff_metadata(out, path.Join(pathname1, name), path.Join(pathname2, name))
+			if err != nil {
+				return err
 			}
 		} else {
-			fmt.Fprintf(out, "Only in %s: %s\n", pathname1, name)
+			cmd.onlyIn(out, pathname1, name)
 		}
 	}
 	for name := range map2 {
 		if !visited[name] {
-			fmt.Fprintf(out, "Only in %s: %s\n", pathname2, name)
+			cmd.onlyIn(out, pathname2, name)
 		}
 	}
 
@@ -286,14 +488,17 @@ func (cmd *Diff) diff_directories_recursive(out io.Writer, id1 string, fs1 fs.FS
 
 		switch {
 		case ok1 && !ok2:
-			fmt.Fprintf(out, "Only in %s: %s\n", path1, name)
+			cmd.onlyIn(out, path1, name)
 
 		case !ok1 && ok2:
-			fmt.Fprintf(out, "Only in %s: %s\n", path2, name)
+			cmd.onlyIn(out, path2, name)
 
 		case ok1 && ok2:
 			if e1.IsDir() && e2.IsDir() {
 				fmt.Fprintf(out, "Common subdirectories: %s and %s\n", full1, full2)
+				if err := cmd.diff_metadata(out, full1, full2); err != nil {
+					return err
+				}
 				err := cmd.diff_directories_recursive(out, id1, fs1, full1, id2, fs2, full2)
 				if err != nil {
 					return err
@@ -317,6 +522,14 @@ func (cmd *Diff) diff_directories_recursive(out io.Writer, id1 string, fs1 fs.FS
 				if err != nil {
 					return err
 				}
+				if err := cmd.diff_metadata(out, full1, full2); err != nil {
+					return err
+				}
+			} else if e1.Type() == e2.Type() {
+				// symlinks, devices and the like only have metadata
+				if err := cmd.diff_metadata(out, full1, full2); err != nil {
+					return err
+				}
 			} else {
 				fmt.Fprintf(out, "File type mismatch: %s vs %s\n", full1, full2)
 			}
@@ -335,18 +548,9 @@ func isbinary(rd io.Reader) bool {
 		return false
 	}
 
-	var buf [512]byte
+	var buf [utils.BinarySniffLen]byte
 	n, _ := rdt.ReadAt(buf[:], 0)
-	for _, ch := range buf[:n] {
-		if ch < ' ' && ch != '\t' && ch != '\n' && ch != '\r' && ch != '\f' {
-			return true
-		}
-		if ch == 0x7f {
-			return true
-		}
-	}
-
-	return false
+	return utils.IsBinary(buf[:n])
 }
 
 func binaryeq(rrd1 io.Reader, rrd2 io.Reader) (bool, error) {
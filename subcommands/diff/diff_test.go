@@ -3,11 +3,16 @@ package diff
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	_ "github.com/PlakarKorp/integrations/fs/exporter"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
 )
@@ -16,6 +21,56 @@ func init() {
 	os.Setenv("TZ", "UTC")
 }
 
+// fixture is a repository holding a snapshot of each of the given file
+// sets, with the output of the commands run against it captured.
+type fixture struct {
+	repo  *repository.Repository
+	ctx   *appcontext.AppContext
+	out   *bytes.Buffer
+	snaps []*snapshot.Snapshot
+}
+
+func newFixture(t *testing.T, sets ...[]ptesting.MockFile) *fixture {
+	t.Helper()
+	f := &fixture{out: bytes.NewBuffer(nil)}
+	f.repo, f.ctx = ptesting.GenerateRepository(t, f.out, bytes.NewBuffer(nil), nil)
+	for _, files := range sets {
+		snap := ptesting.GenerateSnapshot(t, f.repo, files)
+		t.Cleanup(func() { snap.Close() })
+		f.snaps = append(f.snaps, snap)
+	}
+	return f
+}
+
+// diff runs diff with args on /subdir of the first two snapshots and
+// returns what it printed.
+func (f *fixture) diff(t *testing.T, args ...string) string {
+	t.Helper()
+	for _, snap := range f.snaps[:2] {
+		args = append(args, hex.EncodeToString(snap.Header.GetIndexShortID())+":/subdir")
+	}
+
+	cmd := &Diff{}
+	require.NoError(t, cmd.Parse(f.ctx, args))
+	status, err := cmd.Execute(f.ctx, f.repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	return f.out.String()
+}
+
+// decodeChanges reads the changes of a -format json summary by path.
+func decodeChanges(t *testing.T, out string) map[string]Change {
+	t.Helper()
+	changes := make(map[string]Change)
+	dec := json.NewDecoder(strings.NewReader(out))
+	for dec.More() {
+		var c Change
+		require.NoError(t, dec.Decode(&c))
+		changes[c.Path] = c
+	}
+	return changes
+}
+
 func TestDiffName(t *testing.T) {
 	require.Equal(t, "diff", (&Diff{}).Name())
 }
@@ -0,0 +1,238 @@
+package diff
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/btree"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// metadata is what -metadata compares for a path, wherever it comes from.
+type metadata struct {
+	mode     fs.FileMode
+	size     int64
+	mtime    time.Time
+	uid      uint64
+	gid      uint64
+	hasOwner bool
+	target   string
+	// xattrs maps names to a digest of their value, nil when the side
+	// can't tell.
+	xattrs map[string]string
+}
+
+// FieldChange is one metadata difference of a path.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+func (f FieldChange) String() string {
+	return fmt.Sprintf("%s %s -> %s", f.Field, f.Old, f.New)
+}
+
+func compareMetadata(m1, m2 *metadata) []FieldChange {
+	var changes []FieldChange
+	add := func(field, old, new string) {
+		if old != new {
+			changes = append(changes, FieldChange{Field: field, Old: old, New: new})
+		}
+	}
+
+	add("mode", m1.mode.String(), m2.mode.String())
+	if m1.hasOwner && m2.hasOwner {
+		add("uid", strconv.FormatUint(m1.uid, 10), strconv.FormatUint(m2.uid, 10))
+		add("gid", strconv.FormatUint(m1.gid, 10), strconv.FormatUint(m2.gid, 10))
+	}
+	if !m1.mtime.Equal(m2.mtime) {
+		add("mtime", m1.mtime.UTC().Format(time.RFC3339Nano), m2.mtime.UTC().Format(time.RFC3339Nano))
+	}
+	if !m1.mode.IsDir() && !m2.mode.IsDir() {
+		add("size", strconv.FormatInt(m1.size, 10), strconv.FormatInt(m2.size, 10))
+	}
+	add("symlink", m1.target, m2.target)
+
+	if m1.xattrs != nil && m2.xattrs != nil {
+		names := make([]string, 0, len(m1.xattrs)+len(m2.xattrs))
+		for name := range m1.xattrs {
+			names = append(names, name)
+		}
+		for name := range m2.xattrs {
+			if _, ok := m1.xattrs[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			v1, ok1 := m1.xattrs[name]
+			v2, ok2 := m2.xattrs[name]
+			switch {
+			case !ok1:
+				add("xattr "+name, "(none)", v2)
+			case !ok2:
+				add("xattr "+name, v1, "(none)")
+			default:
+				add("xattr "+name, v1, v2)
+			}
+		}
+	}
+
+	return changes
+}
+
+// metaSource reads the metadata of one side of the diff.
+type metaSource struct {
+	fs fs.FS
+	// vfs is nil when comparing against the local filesystem.
+	vfs    *vfs.Filesystem
+	xattrs func(pathname string) (map[string]string, error)
+}
+
+func newSnapshotMetaSource(repo *repository.Repository, snap *snapshot.Snapshot, fsys *vfs.Filesystem) (*metaSource, error) {
+	xattrs, err := loadXattrs(repo, snap, fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &metaSource{fs: fsys, vfs: fsys, xattrs: xattrs}, nil
+}
+
+func newLocalMetaSource(fsys fs.FS) *metaSource {
+	return &metaSource{fs: fsys}
+}
+
+func (m *metaSource) lookup(pathname string) (*metadata, error) {
+	if m.vfs != nil {
+		entry, err := m.vfs.GetEntry(pathname)
+		if err != nil {
+			return nil, err
+		}
+		st := entry.Stat()
+		md := &metadata{
+			mode:     st.Mode(),
+			size:     st.Size(),
+			mtime:    st.ModTime(),
+			uid:      uint64(st.Uid()),
+			gid:      uint64(st.Gid()),
+			hasOwner: true,
+			target:   entry.SymlinkTarget,
+		}
+		md.xattrs, err = m.xattrs(entry.Path())
+		if err != nil {
+			return nil, err
+		}
+		return md, nil
+	}
+
+	name := strings.TrimPrefix(pathname, "/")
+	if name == "" {
+		name = "."
+	}
+	info, err := fs.Lstat(m.fs, name)
+	if err != nil {
+		return nil, err
+	}
+	md := &metadata{
+		mode:  info.Mode(),
+		size:  info.Size(),
+		mtime: info.ModTime(),
+	}
+	md.uid, md.gid, md.hasOwner = fileOwner(info)
+	if info.Mode()&fs.ModeSymlink != 0 {
+		md.target, err = fs.ReadLink(m.fs, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return md, nil
+}
+
+// loadXattrs opens the extended attributes index of a snapshot, the one
+// "diag xattr" walks, and returns a lookup of the attributes of a path.
+func loadXattrs(repo *repository.Repository, snap *snapshot.Snapshot, fsys *vfs.Filesystem) (func(string) (map[string]string, error), error) {
+	rd, err := repo.GetBlob(resources.RT_XATTR_BTREE, snap.Header.GetSource(0).VFS.Xattrs)
+	if err != nil {
+		return nil, err
+	}
+
+	store := repository.NewRepositoryStore[string, objects.MAC](repo, resources.RT_XATTR_NODE)
+	tree, err := btree.Deserialize(rd, store, vfs.PathCmp)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(pathname string) (map[string]string, error) {
+		xattrs := make(map[string]string)
+
+		it, err := tree.ScanFrom(pathname)
+		if err != nil {
+			return nil, err
+		}
+		for it.Next() {
+			key, xattrmac := it.Current()
+			if !strings.HasPrefix(key, pathname) {
+				break
+			}
+
+			xattr, err := fsys.ResolveXattr(xattrmac)
+			if err != nil {
+				return nil, err
+			}
+			if xattr.Path != pathname {
+				continue
+			}
+
+			value := "-"
+			if xattr.ResolvedObject != nil {
+				value = hex.EncodeToString(xattr.ResolvedObject.ContentMAC[:])
+			}
+			xattrs[xattr.Name] = value
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+		return xattrs, nil
+	}, nil
+}
+
+// diff_metadata prints the metadata differences of a path present on both
+// sides.
+func (cmd *Diff) diff_metadata(out io.Writer, pathname1 string, pathname2 string) error {
+	changes, err := cmd.metadataChanges(pathname1, pathname2)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		fmt.Fprintf(out, "Metadata differ for %s: %s\n", utils.SanitizeText(pathname1), utils.SanitizeText(change.String()))
+	}
+	return nil
+}
+
+func (cmd *Diff) metadataChanges(pathname1 string, pathname2 string) ([]FieldChange, error) {
+	if cmd.meta1 == nil || cmd.meta2 == nil {
+		return nil, nil
+	}
+
+	m1, err := cmd.meta1.lookup(pathname1)
+	if err != nil {
+		return nil, err
+	}
+	m2, err := cmd.meta2.lookup(pathname2)
+	if err != nil {
+		return nil, err
+	}
+	return compareMetadata(m1, m2), nil
+}
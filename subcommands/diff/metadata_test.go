@@ -0,0 +1,69 @@
+package diff
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareMetadata(t *testing.T) {
+	now := time.Now()
+	m1 := &metadata{mode: 0644, size: 3, mtime: now, uid: 1000, gid: 100, hasOwner: true,
+		xattrs: map[string]string{"user.a": "aa", "user.b": "bb"}}
+	m2 := &metadata{mode: 0600, size: 3, mtime: now, uid: 1001, gid: 100, hasOwner: true,
+		xattrs: map[string]string{"user.a": "cc", "user.c": "dd"}}
+
+	require.Equal(t, []FieldChange{
+		{Field: "mode", Old: "-rw-r--r--", New: "-rw-------"},
+		{Field: "uid", Old: "1000", New: "1001"},
+		{Field: "xattr user.a", Old: "aa", New: "cc"},
+		{Field: "xattr user.b", Old: "bb", New: "(none)"},
+		{Field: "xattr user.c", Old: "(none)", New: "dd"},
+	}, compareMetadata(m1, m2))
+
+	// without owner or xattrs on one side, they are not compared
+	m2.hasOwner = false
+	m2.xattrs = nil
+	m2.mode = 0644
+	require.Empty(t, compareMetadata(m1, m2))
+
+	dir := &metadata{mode: fs.ModeDir | 0755, size: 4096, mtime: now}
+	require.Empty(t, compareMetadata(dir, &metadata{mode: fs.ModeDir | 0755, size: 512, mtime: now}))
+
+	link := &metadata{mode: fs.ModeSymlink | 0777, mtime: now, target: "a"}
+	require.Equal(t, []FieldChange{{Field: "symlink", Old: "a", New: "b"}},
+		compareMetadata(link, &metadata{mode: fs.ModeSymlink | 0777, mtime: now, target: "b"}))
+}
+
+func metadataFixture(t *testing.T) *fixture {
+	return newFixture(t, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/same.txt", 0644, "same"),
+		ptesting.NewMockFile("subdir/perms.txt", 0644, "perms"),
+	}, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/same.txt", 0644, "same"),
+		ptesting.NewMockFile("subdir/perms.txt", 0600, "perms"),
+	})
+}
+
+func TestDiffMetadataFlat(t *testing.T) {
+	out := metadataFixture(t).diff(t, "-metadata")
+	require.Contains(t, out, "Metadata differ for /subdir/perms.txt: mode -rw-r--r-- -> -rw-------")
+	require.NotContains(t, out, "Metadata differ for /subdir/same.txt: mode")
+}
+
+func TestDiffMetadataRecursive(t *testing.T) {
+	out := metadataFixture(t).diff(t, "-metadata", "-recursive")
+	require.Contains(t, out, "Metadata differ for /subdir/perms.txt: mode -rw-r--r-- -> -rw-------")
+}
+
+func TestDiffMetadataSummary(t *testing.T) {
+	changes := decodeChanges(t, metadataFixture(t).diff(t, "-metadata", "-summary", "-format", "json"))
+	require.Equal(t, StatusMetadata, changes["/subdir/perms.txt"].Status)
+	require.Contains(t, changes["/subdir/perms.txt"].Metadata,
+		FieldChange{Field: "mode", Old: "-rw-r--r--", New: "-rw-------"})
+}
@@ -0,0 +1,276 @@
+package diff
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+// chunkSet returns the set of chunk MACs of a file, which is what partial
+// matches are scored on.
+func chunkSet(e *vfs.Entry) map[objects.MAC]struct{} {
+	set := make(map[objects.MAC]struct{})
+	if e == nil || e.ResolvedObject == nil {
+		return set
+	}
+	for _, chunk := range e.ResolvedObject.Chunks {
+		set[chunk.ContentMAC] = struct{}{}
+	}
+	return set
+}
+
+// similarity is the share of chunks two files have in common, in percent.
+func similarity(a, b map[objects.MAC]struct{}) int {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for mac := range a {
+		if _, ok := b[mac]; ok {
+			common++
+		}
+	}
+	return 200 * common / (len(a) + len(b))
+}
+
+func renameCandidate(c *Change) bool {
+	return c.entry != nil && c.entry.Stat().Mode().IsRegular() && c.entry.Stat().Size() != 0
+}
+
+func isEmptyFile(c *Change) bool {
+	return c.entry != nil && c.entry.Stat().Mode().IsRegular() && c.entry.Stat().Size() == 0
+}
+
+// detectRenames pairs removed and added files that have the same content
+// or, below a threshold of 100, enough chunks in common, and turns each
+// pair into a single renamed change.  A removed directory whose content
+// all went to one added directory is reported as a single rename too.
+// The result is sorted by path.
+func detectRenames(changes []*Change, threshold int) []*Change {
+	var removed, added []*Change
+	for _, c := range changes {
+		if !renameCandidate(c) {
+			continue
+		}
+		switch c.Status {
+		case StatusRemoved:
+			removed = append(removed, c)
+		case StatusAdded:
+			added = append(added, c)
+		}
+	}
+	if len(removed) == 0 || len(added) == 0 {
+		return changes
+	}
+
+	byMAC := make(map[string][]*Change)
+	for _, a := range added {
+		byMAC[a.NewMAC] = append(byMAC[a.NewMAC], a)
+	}
+
+	paired := make(map[*Change]*Change)
+	used := make(map[*Change]bool)
+	score := make(map[*Change]int)
+
+	for _, r := range removed {
+		var best *Change
+		for _, a := range byMAC[r.OldMAC] {
+			if used[a] {
+				continue
+			}
+			// several identical copies: keep the one that kept its name
+			if best == nil || path.Base(a.Path) == path.Base(r.Path) && path.Base(best.Path) != path.Base(r.Path) {
+				best = a
+			}
+		}
+		if best != nil {
+			paired[r], used[best], score[r] = best, true, 100
+		}
+	}
+
+	if threshold < 100 {
+		sets := make(map[*Change]map[objects.MAC]struct{})
+		for _, c := range append(slices.Clone(removed), added...) {
+			sets[c] = chunkSet(c.entry)
+		}
+		for _, r := range removed {
+			if _, ok := paired[r]; ok {
+				continue
+			}
+			var best *Change
+			bestScore := threshold - 1
+			for _, a := range added {
+				if used[a] {
+					continue
+				}
+				if s := similarity(sets[r], sets[a]); s > bestScore {
+					best, bestScore = a, s
+				}
+			}
+			if best != nil {
+				paired[r], used[best], score[r] = best, true, bestScore
+			}
+		}
+	}
+
+	if len(paired) == 0 {
+		return changes
+	}
+
+	renames := make(map[string]string)
+	for r, a := range paired {
+		renames[r.Path] = a.Path
+	}
+	dirs := renamedDirs(changes, renames)
+
+	result := make([]*Change, 0, len(changes))
+	for _, c := range changes {
+		switch {
+		case c.Status == StatusAdded && (used[c] || underAny(c.Path, dirs, true)):
+		case c.Status == StatusRemoved && underAny(c.Path, dirs, false):
+			if to, ok := dirs[c.Path]; ok {
+				result = append(result, &Change{
+					Path:    c.Path,
+					NewPath: to,
+					Status:  StatusRenamed,
+					newPath: to,
+				})
+			}
+		case paired[c] != nil:
+			a := paired[c]
+			result = append(result, &Change{
+				Path:       c.Path,
+				NewPath:    a.Path,
+				Status:     StatusRenamed,
+				OldSize:    c.OldSize,
+				NewSize:    a.NewSize,
+				SizeDelta:  a.NewSize - c.OldSize,
+				OldMAC:     c.OldMAC,
+				NewMAC:     a.NewMAC,
+				Similarity: score[c],
+				newPath:    a.Path,
+			})
+		default:
+			result = append(result, c)
+		}
+	}
+
+	slices.SortFunc(result, func(a, b *Change) int { return strings.Compare(a.Path, b.Path) })
+	return result
+}
+
+// renamedDirs finds removed directories that were moved as a whole: every
+// removed file below them was renamed to the same relative path below a
+// single added directory, and that directory holds nothing else.  Empty
+// files, which are never paired, must be found at the same relative path
+// with the same mode.
+func renamedDirs(changes []*Change, renames map[string]string) map[string]string {
+	var removedDirs []string
+	removed := make(map[string]*Change)
+	added := make(map[string]*Change)
+	for _, c := range changes {
+		switch c.Status {
+		case StatusRemoved:
+			removed[c.Path] = c
+			if c.entry != nil && c.entry.IsDir() {
+				removedDirs = append(removedDirs, c.Path)
+			}
+		case StatusAdded:
+			added[c.Path] = c
+		}
+	}
+	// outermost first, so that a moved tree is reported once
+	slices.SortFunc(removedDirs, func(a, b string) int { return len(a) - len(b) })
+
+	dirs := make(map[string]string)
+	for _, dir := range removedDirs {
+		if underAny(dir, dirs, false) {
+			continue
+		}
+
+		target := ""
+		ok := true
+		var empty []string
+		for p := range removed {
+			if !strings.HasPrefix(p, dir+"/") {
+				continue
+			}
+			rel := p[len(dir):]
+			to, renamed := renames[p]
+			if !renamed {
+				if removed[p].entry != nil && removed[p].entry.IsDir() {
+					continue
+				}
+				// empty files have no content to pair them on, they are
+				// matched by name once the target is known
+				if isEmptyFile(removed[p]) {
+					empty = append(empty, rel)
+					continue
+				}
+				ok = false
+				break
+			}
+			if !strings.HasSuffix(to, rel) {
+				ok = false
+				break
+			}
+			t := strings.TrimSuffix(to, rel)
+			if target == "" {
+				target = t
+			} else if t != target {
+				ok = false
+				break
+			}
+		}
+		if !ok || target == "" {
+			continue
+		}
+		if c, exists := added[target]; !exists || c.entry == nil || !c.entry.IsDir() {
+			continue
+		}
+		for _, rel := range empty {
+			a, exists := added[target+rel]
+			if !exists || !isEmptyFile(a) || a.entry.Stat().Mode() != removed[dir+rel].entry.Stat().Mode() {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		// the added directory must not hold anything that did not come
+		// from the removed one
+		for p := range added {
+			if !strings.HasPrefix(p, target+"/") {
+				continue
+			}
+			if _, exists := removed[dir+p[len(target):]]; !exists {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			dirs[dir] = target
+		}
+	}
+	return dirs
+}
+
+// underAny tells whether pathname is one of the renamed directories, or
+// below one, on the old side or, with added, on the new side.
+func underAny(pathname string, dirs map[string]string, added bool) bool {
+	for from, to := range dirs {
+		dir := from
+		if added {
+			dir = to
+		}
+		if pathname == dir || strings.HasPrefix(pathname, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package diff
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimilarity(t *testing.T) {
+	set := func(bytes ...byte) map[objects.MAC]struct{} {
+		s := make(map[objects.MAC]struct{})
+		for _, b := range bytes {
+			s[objects.MAC{b}] = struct{}{}
+		}
+		return s
+	}
+
+	require.Equal(t, 100, similarity(set(1, 2, 3), set(1, 2, 3)))
+	require.Equal(t, 50, similarity(set(1, 2), set(2, 3)))
+	require.Equal(t, 0, similarity(set(1), set(2)))
+	require.Equal(t, 0, similarity(set(), set(2)))
+}
+
+func renamesFixture(t *testing.T) *fixture {
+	return newFixture(t, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockDir("subdir/old"),
+		ptesting.NewMockFile("subdir/old/a.txt", 0644, "alpha"),
+		ptesting.NewMockFile("subdir/old/b.txt", 0644, "beta"),
+		ptesting.NewMockFile("subdir/old/empty", 0644, ""),
+		ptesting.NewMockFile("subdir/moved.txt", 0644, "moved"),
+		ptesting.NewMockFile("subdir/deleted.txt", 0644, "deleted"),
+	}, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockDir("subdir/new"),
+		ptesting.NewMockFile("subdir/new/a.txt", 0644, "alpha"),
+		ptesting.NewMockFile("subdir/new/b.txt", 0644, "beta"),
+		ptesting.NewMockFile("subdir/new/empty", 0644, ""),
+		ptesting.NewMockDir("subdir/elsewhere"),
+		ptesting.NewMockFile("subdir/elsewhere/moved.txt", 0644, "moved"),
+		ptesting.NewMockFile("subdir/created.txt", 0644, "created"),
+	})
+}
+
+func TestDiffDetectRenamesSummary(t *testing.T) {
+	changes := decodeChanges(t, renamesFixture(t).diff(t, "-detect-renames", "-summary", "-format", "json"))
+
+	require.Equal(t, StatusRenamed, changes["/subdir/old"].Status)
+	require.Equal(t, "/subdir/new", changes["/subdir/old"].NewPath)
+	require.NotContains(t, changes, "/subdir/old/a.txt")
+	require.NotContains(t, changes, "/subdir/new")
+	require.NotContains(t, changes, "/subdir/new/a.txt")
+	require.NotContains(t, changes, "/subdir/old/empty")
+	require.NotContains(t, changes, "/subdir/new/empty")
+
+	require.Equal(t, StatusRenamed, changes["/subdir/moved.txt"].Status)
+	require.Equal(t, "/subdir/elsewhere/moved.txt", changes["/subdir/moved.txt"].NewPath)
+	require.Equal(t, 100, changes["/subdir/moved.txt"].Similarity)
+	require.NotContains(t, changes, "/subdir/elsewhere/moved.txt")
+	require.Equal(t, StatusAdded, changes["/subdir/elsewhere"].Status)
+
+	require.Equal(t, StatusRemoved, changes["/subdir/deleted.txt"].Status)
+	require.Equal(t, StatusAdded, changes["/subdir/created.txt"].Status)
+}
+
+func TestDiffDetectRenamesRecursive(t *testing.T) {
+	out := renamesFixture(t).diff(t, "-detect-renames", "-recursive")
+
+	require.Contains(t, out, "Renamed: /subdir/old -> /subdir/new\n")
+	require.Contains(t, out, "Renamed: /subdir/moved.txt -> /subdir/elsewhere/moved.txt\n")
+	require.NotContains(t, out, "Only in /subdir: old\n")
+	require.NotContains(t, out, "Only in /subdir: new\n")
+	require.Contains(t, out, "Only in /subdir: deleted.txt\n")
+	require.Contains(t, out, "Only in /subdir: elsewhere\n")
+}
+
+func TestDiffDetectRenamesParse(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	cmd := &Diff{}
+	require.Error(t, cmd.Parse(ctx, []string{"-detect-renames", "a"}))
+
+	cmd = &Diff{}
+	require.Error(t, cmd.Parse(ctx, []string{"-detect-renames", "-rename-threshold", "0", "a", "b"}))
+
+	cmd = &Diff{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-detect-renames", "-rename-threshold", "60", "a", "b"}))
+	require.Equal(t, 60, cmd.RenameThreshold)
+}
@@ -0,0 +1,233 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/importer"
+	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+)
+
+// newSourceImporter builds the importer for -source, resolving @name
+// against the configured sources the same way backup does.
+func newSourceImporter(ctx *appcontext.AppContext, source string) (importer.Importer, error) {
+	opts := map[string]string{}
+	if strings.HasPrefix(source, "@") {
+		remote, ok := ctx.Config.GetSource(source[1:])
+		if !ok {
+			return nil, fmt.Errorf("could not resolve importer: %s", source)
+		}
+		if _, ok := remote["location"]; !ok {
+			return nil, fmt.Errorf("could not resolve importer location: %s", source)
+		}
+		maps.Copy(opts, remote)
+	} else {
+		opts["location"] = source
+	}
+
+	return importer.NewImporter(ctx.GetInner(), ctx.ImporterOpts(), opts)
+}
+
+func recordMetadata(fi *objects.FileInfo, target string) *metadata {
+	return &metadata{
+		mode:     fi.Lmode,
+		size:     fi.Lsize,
+		mtime:    fi.LmodTime,
+		uid:      fi.Luid,
+		gid:      fi.Lgid,
+		hasOwner: true,
+		target:   target,
+	}
+}
+
+func entryMetadata(e *vfs.Entry) *metadata {
+	st := e.Stat()
+	return &metadata{
+		mode:     st.Mode(),
+		size:     st.Size(),
+		mtime:    st.ModTime(),
+		uid:      uint64(st.Uid()),
+		gid:      uint64(st.Gid()),
+		hasOwner: true,
+		target:   e.SymlinkTarget,
+	}
+}
+
+func underPath(pathname, root string) bool {
+	return root == "/" || pathname == root || strings.HasPrefix(pathname, root+"/")
+}
+
+// diffSource compares the snapshot tree at root with what the importer
+// currently sees.  Records come in no particular order, so the changes are
+// collected and sorted before being written.
+func (cmd *Diff) diffSource(ctx *appcontext.AppContext, fs1 *vfs.Filesystem, root string, imp importer.Importer) error {
+	w, err := newSummaryWriter(ctx.Stdout, cmd.Format)
+	if err != nil {
+		return err
+	}
+
+	var (
+		size    = ctx.MaxConcurrency
+		records = make(chan *connectors.Record, size)
+		results chan *connectors.Result
+		seen    = make(map[string]struct{})
+		changes []*Change
+		failed  error
+		done    = make(chan struct{})
+	)
+	if imp.Flags()&location.FLAG_NEEDACK != 0 {
+		results = make(chan *connectors.Result, size)
+	}
+
+	go func() {
+		defer close(done)
+		if results != nil {
+			defer close(results)
+		}
+		for record := range records {
+			c, err := cmd.sourceChange(ctx, fs1, root, record)
+			if results == nil {
+				record.Close()
+			} else {
+				results <- record.Ok()
+			}
+			if err != nil {
+				if failed == nil {
+					failed = err
+				}
+				continue
+			}
+			if c != nil {
+				seen[c.Path] = struct{}{}
+				changes = append(changes, c)
+			}
+		}
+	}()
+
+	err = imp.Import(ctx, records, results)
+	<-done
+	if err != nil {
+		return err
+	}
+	if failed != nil {
+		return failed
+	}
+
+	err = fs1.WalkDir(root, func(p string, d *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, ok := seen[p]; !ok {
+			changes = append(changes, newChange(p, d, nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slices.SortFunc(changes, func(a, b *Change) int { return strings.Compare(a.Path, b.Path) })
+	for _, c := range changes {
+		if c.Status == StatusUnchanged && !cmd.Unchanged {
+			continue
+		}
+		if err := w.Write(c); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// sourceChange classifies one importer record against the snapshot.  It
+// returns nil for records outside of root and for extended attributes.
+func (cmd *Diff) sourceChange(ctx *appcontext.AppContext, fs1 *vfs.Filesystem, root string, record *connectors.Record) (*Change, error) {
+	if record.IsXattr {
+		return nil, nil
+	}
+	if record.Err != nil {
+		ctx.GetLogger().Warn("diff: %s: %s", record.Pathname, record.Err)
+		return nil, nil
+	}
+
+	pathname := path.Clean(record.Pathname)
+	if !underPath(pathname, root) {
+		return nil, nil
+	}
+
+	fi := &record.FileInfo
+	c := &Change{
+		Path:    pathname,
+		newPath: pathname,
+		NewSize: fi.Lsize,
+	}
+	if fi.Lmode.IsDir() {
+		c.NewSize = 0
+	}
+
+	e1, err := fs1.GetEntry(pathname)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.Status = StatusAdded
+		c.SizeDelta = c.NewSize
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	c.OldSize = entrySize(e1)
+	c.OldMAC = contentMAC(e1)
+	c.SizeDelta = c.NewSize - c.OldSize
+
+	m1, m2 := entryMetadata(e1), recordMetadata(fi, record.Target)
+	fields := compareMetadata(m1, m2)
+	if cmd.Metadata {
+		c.Metadata = fields
+	}
+
+	switch {
+	case m1.mode.Type() != m2.mode.Type(), m1.target != m2.target:
+		c.Status = StatusModified
+	case m1.mode.IsRegular() && m1.size != m2.size:
+		c.Status = StatusModified
+	case m1.mode.IsRegular() && cmd.Content:
+		same, err := sameSourceContent(fs1, pathname, record)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			c.Status = StatusModified
+		}
+	case m1.mode.IsRegular() && !m1.mtime.Equal(m2.mtime):
+		// without reading the data, a new mtime is all we have to go by
+		c.Status = StatusModified
+	}
+
+	if c.Status == "" {
+		c.Status = StatusUnchanged
+		if len(fields) != 0 {
+			c.Status = StatusMetadata
+		}
+	}
+	return c, nil
+}
+
+func sameSourceContent(fs1 *vfs.Filesystem, pathname string, record *connectors.Record) (bool, error) {
+	if record.Reader == nil {
+		return false, fmt.Errorf("%s: no content available from the source", pathname)
+	}
+
+	f, err := fs1.Open(pathname)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	return binaryeq(f, record.Reader)
+}
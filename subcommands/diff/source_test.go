@@ -0,0 +1,78 @@
+package diff
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func sourceFixture(t *testing.T, cmd *Diff) map[string]Change {
+	f := newFixture(t, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/same.txt", 0644, "same"),
+		ptesting.NewMockFile("subdir/edited.txt", 0644, "abc"),
+		ptesting.NewMockFile("subdir/grown.txt", 0644, "one"),
+		ptesting.NewMockFile("subdir/perms.txt", 0644, "perms"),
+		ptesting.NewMockFile("subdir/gone.txt", 0644, "gone"),
+		ptesting.NewMockFile("other/outside.txt", 0644, "outside"),
+	})
+	fs1, err := f.snaps[0].Filesystem()
+	require.NoError(t, err)
+
+	imp, err := ptesting.NewMockImporter(f.ctx, &connectors.Options{}, "mock", map[string]string{"location": "mock://place"})
+	require.NoError(t, err)
+	imp.(*ptesting.MockImporter).SetFiles([]ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/same.txt", 0644, "same"),
+		ptesting.NewMockFile("subdir/edited.txt", 0644, "xyz"),
+		ptesting.NewMockFile("subdir/grown.txt", 0644, "three"),
+		ptesting.NewMockFile("subdir/perms.txt", 0600, "perms"),
+		ptesting.NewMockFile("subdir/new.txt", 0644, "new"),
+	})
+
+	cmd.Format = "json"
+	require.NoError(t, cmd.diffSource(f.ctx, fs1, "/subdir", imp))
+	return decodeChanges(t, f.out.String())
+}
+
+func TestDiffSource(t *testing.T) {
+	changes := sourceFixture(t, &Diff{})
+
+	require.Equal(t, StatusAdded, changes["/subdir/new.txt"].Status)
+	require.Equal(t, StatusRemoved, changes["/subdir/gone.txt"].Status)
+	require.Equal(t, StatusModified, changes["/subdir/grown.txt"].Status)
+	require.Equal(t, int64(2), changes["/subdir/grown.txt"].SizeDelta)
+	require.Equal(t, StatusMetadata, changes["/subdir/perms.txt"].Status)
+	require.NotContains(t, changes, "/subdir/same.txt")
+	require.NotContains(t, changes, "/other/outside.txt")
+
+	// same size and mtime, so it looks unchanged without -content
+	require.NotContains(t, changes, "/subdir/edited.txt")
+}
+
+func TestDiffSourceContent(t *testing.T) {
+	changes := sourceFixture(t, &Diff{Content: true, Unchanged: true, Metadata: true})
+
+	require.Equal(t, StatusModified, changes["/subdir/edited.txt"].Status)
+	require.Equal(t, StatusUnchanged, changes["/subdir/same.txt"].Status)
+	require.Equal(t, []FieldChange{{Field: "mode", Old: "-rw-r--r--", New: "-rw-------"}},
+		changes["/subdir/perms.txt"].Metadata)
+}
+
+func TestDiffSourceParse(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	cmd := &Diff{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-source", "@home", "-content", "a"}))
+	require.Equal(t, "@home", cmd.Source)
+	require.True(t, cmd.Content)
+
+	cmd = &Diff{}
+	require.Error(t, cmd.Parse(ctx, []string{"-source", "@home", "a", "b"}))
+
+	cmd = &Diff{}
+	require.Error(t, cmd.Parse(ctx, []string{"-content", "a", "b"}))
+}
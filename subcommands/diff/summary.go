@@ -0,0 +1,323 @@
+package diff
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+const (
+	StatusAdded     = "added"
+	StatusRemoved   = "removed"
+	StatusModified  = "modified"
+	StatusMetadata  = "metadata"
+	StatusRenamed   = "renamed"
+	StatusUnchanged = "unchanged"
+)
+
+// Change is one line of a structural diff.  Content is compared through the
+// object MACs recorded in the vfs, so no file data is read.
+type Change struct {
+	Path      string `json:"path"`
+	Status    string `json:"status"`
+	OldSize   int64  `json:"old_size"`
+	NewSize   int64  `json:"new_size"`
+	SizeDelta int64  `json:"size_delta"`
+	OldMAC    string `json:"old_mac,omitempty"`
+	NewMAC    string `json:"new_mac,omitempty"`
+
+	// NewPath and Similarity are only set on renames, the latter being
+	// the share of chunks both sides have in common.
+	NewPath    string `json:"new_path,omitempty"`
+	Similarity int    `json:"similarity,omitempty"`
+
+	// Metadata is only filled with -metadata.
+	Metadata []FieldChange `json:"metadata,omitempty"`
+
+	// newPath is where the entry lives on the second side, which differs
+	// from Path when the two roots differ.
+	newPath string
+	// entry is the entry of the side the path exists on, preferring the
+	// second one.
+	entry *vfs.Entry
+}
+
+func contentMAC(e *vfs.Entry) string {
+	if e == nil || e.ResolvedObject == nil {
+		return ""
+	}
+	return hex.EncodeToString(e.ResolvedObject.ContentMAC[:])
+}
+
+func entrySize(e *vfs.Entry) int64 {
+	if e == nil || e.IsDir() {
+		return 0
+	}
+	return e.Stat().Size()
+}
+
+func newChange(pathname string, e1, e2 *vfs.Entry) *Change {
+	c := &Change{
+		Path:    pathname,
+		newPath: pathname,
+		OldSize: entrySize(e1),
+		NewSize: entrySize(e2),
+		OldMAC:  contentMAC(e1),
+		NewMAC:  contentMAC(e2),
+		entry:   e2,
+	}
+	if e2 == nil {
+		c.entry = e1
+	}
+	c.SizeDelta = c.NewSize - c.OldSize
+
+	switch {
+	case e1 == nil:
+		c.Status = StatusAdded
+	case e2 == nil:
+		c.Status = StatusRemoved
+	case !sameContent(e1, e2):
+		c.Status = StatusModified
+	case !sameMetadata(e1, e2):
+		c.Status = StatusMetadata
+	default:
+		c.Status = StatusUnchanged
+	}
+	return c
+}
+
+func sameContent(e1, e2 *vfs.Entry) bool {
+	st1, st2 := e1.Stat(), e2.Stat()
+	if st1.Mode().Type() != st2.Mode().Type() {
+		return false
+	}
+	if e1.SymlinkTarget != e2.SymlinkTarget {
+		return false
+	}
+	if st1.Mode().IsRegular() {
+		return st1.Size() == st2.Size() && contentMAC(e1) == contentMAC(e2)
+	}
+	return true
+}
+
+func sameMetadata(e1, e2 *vfs.Entry) bool {
+	st1, st2 := e1.Stat(), e2.Stat()
+	return st1.Mode() == st2.Mode() &&
+		st1.Uid() == st2.Uid() &&
+		st1.Gid() == st2.Gid() &&
+		st1.ModTime().Equal(st2.ModTime())
+}
+
+// children returns the entries of a directory by name.  The vfs can hand
+// back the same name twice, once as a directory and once not; keep the
+// directory, which is the one worth descending into.
+func children(fs *vfs.Filesystem, dir *vfs.Entry) (map[string]*vfs.Entry, error) {
+	dents, err := dir.Getdents(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*vfs.Entry)
+	for child, err := range dents {
+		if err != nil {
+			return nil, err
+		}
+		if prev, ok := entries[child.Name()]; ok && prev.IsDir() {
+			continue
+		}
+		entries[child.Name()] = child
+	}
+	return entries, nil
+}
+
+// summarize walks both trees side by side and hands emit a Change for every
+// path found on either side.
+func summarize(ctx *appcontext.AppContext, fs1 *vfs.Filesystem, path1 string, fs2 *vfs.Filesystem, path2 string, emit func(*Change) error) error {
+	e1, err := fs1.GetEntry(path1)
+	if err != nil {
+		return fmt.Errorf("could not open path %s: %w", path1, err)
+	}
+	e2, err := fs2.GetEntry(path2)
+	if err != nil {
+		return fmt.Errorf("could not open path %s: %w", path2, err)
+	}
+	return summarizeEntries(ctx, fs1, path1, e1, fs2, path2, e2, emit)
+}
+
+func summarizeEntries(ctx *appcontext.AppContext, fs1 *vfs.Filesystem, path1 string, e1 *vfs.Entry, fs2 *vfs.Filesystem, path2 string, e2 *vfs.Entry, emit func(*Change) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if e1 == nil || e2 == nil || e1.IsDir() != e2.IsDir() {
+		if e1 != nil {
+			if err := summarizeOneSide(fs1, path1, e1, StatusRemoved, emit); err != nil {
+				return err
+			}
+		}
+		if e2 != nil {
+			if err := summarizeOneSide(fs2, path2, e2, StatusAdded, emit); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	c := newChange(path1, e1, e2)
+	c.newPath = path2
+	if err := emit(c); err != nil {
+		return err
+	}
+	if !e1.IsDir() {
+		return nil
+	}
+
+	dents1, err := children(fs1, e1)
+	if err != nil {
+		return err
+	}
+	dents2, err := children(fs2, e2)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(dents1)+len(dents2))
+	for name := range dents1 {
+		names = append(names, name)
+	}
+	for name := range dents2 {
+		if _, ok := dents1[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		err := summarizeEntries(ctx,
+			fs1, path.Join(path1, name), dents1[name],
+			fs2, path.Join(path2, name), dents2[name], emit)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// summarizeOneSide reports a whole subtree that only exists in one snapshot.
+func summarizeOneSide(fs *vfs.Filesystem, pathname string, e *vfs.Entry, status string, emit func(*Change) error) error {
+	if !e.IsDir() {
+		return emit(oneSideChange(pathname, e, status))
+	}
+
+	return fs.WalkDir(pathname, func(p string, d *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		return emit(oneSideChange(p, d, status))
+	})
+}
+
+func oneSideChange(pathname string, e *vfs.Entry, status string) *Change {
+	if status == StatusAdded {
+		return newChange(pathname, nil, e)
+	}
+	return newChange(pathname, e, nil)
+}
+
+type summaryWriter interface {
+	Write(*Change) error
+	Flush() error
+}
+
+func newSummaryWriter(out io.Writer, format string) (summaryWriter, error) {
+	switch format {
+	case "", "text":
+		return &textSummary{out: out}, nil
+	case "json":
+		return &jsonSummary{enc: json.NewEncoder(out)}, nil
+	case "csv":
+		w := csv.NewWriter(out)
+		err := w.Write([]string{"path", "status", "old_size", "new_size", "size_delta", "old_mac", "new_mac", "metadata", "new_path", "similarity"})
+		return &csvSummary{w: w}, err
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+type textSummary struct {
+	out io.Writer
+}
+
+func (s *textSummary) Write(c *Change) error {
+	var delta string
+	if c.SizeDelta != 0 {
+		delta = fmt.Sprintf(" (%+d bytes)", c.SizeDelta)
+	}
+	var similar string
+	if c.Similarity != 0 && c.Similarity != 100 {
+		similar = fmt.Sprintf(" [%d%% similar]", c.Similarity)
+	}
+
+	pathname := utils.SanitizeText(c.Path)
+	if c.Status == StatusRenamed {
+		pathname += " -> " + utils.SanitizeText(c.NewPath)
+	}
+	_, err := fmt.Fprintf(s.out, "%-9s %s%s%s\n", c.Status, pathname, delta, similar)
+	if err != nil {
+		return err
+	}
+	for _, change := range c.Metadata {
+		_, err := fmt.Fprintf(s.out, "%-9s   %s\n", "", utils.SanitizeText(change.String()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *textSummary) Flush() error { return nil }
+
+type jsonSummary struct {
+	enc *json.Encoder
+}
+
+func (s *jsonSummary) Write(c *Change) error { return s.enc.Encode(c) }
+func (s *jsonSummary) Flush() error          { return nil }
+
+type csvSummary struct {
+	w *csv.Writer
+}
+
+func (s *csvSummary) Write(c *Change) error {
+	metadata := make([]string, 0, len(c.Metadata))
+	for _, change := range c.Metadata {
+		metadata = append(metadata, change.String())
+	}
+	return s.w.Write([]string{
+		c.Path,
+		c.Status,
+		strconv.FormatInt(c.OldSize, 10),
+		strconv.FormatInt(c.NewSize, 10),
+		strconv.FormatInt(c.SizeDelta, 10),
+		c.OldMAC,
+		c.NewMAC,
+		strings.Join(metadata, "; "),
+		c.NewPath,
+		strconv.Itoa(c.Similarity),
+	})
+}
+
+func (s *csvSummary) Flush() error {
+	s.w.Flush()
+	return s.w.Error()
+}
@@ -0,0 +1,72 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func summaryFixture(t *testing.T) *fixture {
+	return newFixture(t, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockDir("subdir/gone"),
+		ptesting.NewMockFile("subdir/gone/old.txt", 0644, "old"),
+		ptesting.NewMockFile("subdir/same.txt", 0644, "same"),
+		ptesting.NewMockFile("subdir/changed.txt", 0644, "one"),
+		ptesting.NewMockFile("subdir/perms.txt", 0644, "perms"),
+	}, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/same.txt", 0644, "same"),
+		ptesting.NewMockFile("subdir/changed.txt", 0644, "three"),
+		ptesting.NewMockFile("subdir/perms.txt", 0600, "perms"),
+		ptesting.NewMockFile("subdir/new.txt", 0644, "new"),
+	})
+}
+
+func TestDiffSummaryJSON(t *testing.T) {
+	changes := decodeChanges(t, summaryFixture(t).diff(t, "-summary", "-format", "json"))
+
+	require.Equal(t, StatusAdded, changes["/subdir/new.txt"].Status)
+	require.Equal(t, StatusRemoved, changes["/subdir/gone"].Status)
+	require.Equal(t, StatusRemoved, changes["/subdir/gone/old.txt"].Status)
+	require.Equal(t, StatusModified, changes["/subdir/changed.txt"].Status)
+	require.Equal(t, int64(2), changes["/subdir/changed.txt"].SizeDelta)
+	require.NotEqual(t, changes["/subdir/changed.txt"].OldMAC, changes["/subdir/changed.txt"].NewMAC)
+	require.Equal(t, StatusMetadata, changes["/subdir/perms.txt"].Status)
+	require.NotContains(t, changes, "/subdir/same.txt")
+}
+
+func TestDiffSummaryCSVUnchanged(t *testing.T) {
+	out := summaryFixture(t).diff(t, "-summary", "-format", "csv", "-unchanged")
+
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, "path", records[0][0])
+
+	statuses := make(map[string]string)
+	for _, record := range records[1:] {
+		statuses[record[0]] = record[1]
+	}
+	require.Equal(t, StatusUnchanged, statuses["/subdir/same.txt"])
+	require.Equal(t, StatusAdded, statuses["/subdir/new.txt"])
+}
+
+func TestDiffSummaryText(t *testing.T) {
+	out := summaryFixture(t).diff(t, "-summary")
+	require.Contains(t, out, "added     /subdir/new.txt (+3 bytes)")
+	require.Contains(t, out, "modified  /subdir/changed.txt (+2 bytes)")
+}
+
+func TestDiffSummaryParse(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	cmd := &Diff{}
+	require.Error(t, cmd.Parse(ctx, []string{"-summary", "a"}))
+
+	cmd = &Diff{}
+	require.Error(t, cmd.Parse(ctx, []string{"-summary", "-format", "xml", "a", "b"}))
+}
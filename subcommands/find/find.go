@@ -0,0 +1,288 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package find
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/hashing"
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Find{} }, 0, "find")
+}
+
+func (cmd *Find) CobraCommand() *cobra.Command {
+	cmd.LocateOptions = locate.NewDefaultLocateOptions()
+
+	c := &cobra.Command{
+		Use: "find [OPTIONS] -digest ALGO:HEX [SNAPSHOT[:PATH]]...",
+	}
+	c.Flags().StringVar(&cmd.Digest, "digest", "", "find the files whose content has this digest, e.g. SHA256:<hex>")
+	c.Flags().BoolVar(&cmd.Index, "index", true, "remember computed digests in the cache so later searches need not read the files again")
+	subcommands.InstallGoFlags(c.Flags(), cmd.LocateOptions.InstallLocateFlags)
+	return c
+}
+
+func (cmd *Find) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Digest == "" {
+		return fmt.Errorf("-digest is required")
+	}
+
+	algorithm, value, ok := strings.Cut(cmd.Digest, ":")
+	if !ok {
+		return fmt.Errorf("-digest: expected ALGO:HEX")
+	}
+	algorithm = strings.ToUpper(algorithm)
+	hasher := hashing.GetHasher(algorithm)
+	if hasher == nil {
+		return fmt.Errorf("unsupported hashing algorithm: %s", algorithm)
+	}
+	digest, err := hex.DecodeString(value)
+	if err != nil {
+		return fmt.Errorf("-digest: invalid hexadecimal value: %w", err)
+	}
+	if len(digest) != hasher.Size() {
+		return fmt.Errorf("-digest: a %s digest is %d bytes long, got %d", algorithm, hasher.Size(), len(digest))
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.algorithm = algorithm
+	cmd.digest = digest
+	cmd.Targets = rest
+
+	return nil
+}
+
+type Find struct {
+	subcommands.SubcommandBase
+
+	LocateOptions *locate.LocateOptions
+	Digest        string
+	Index         bool
+	Targets       []string
+
+	algorithm string
+	digest    []byte
+}
+
+// Occurrence is a file holding the searched content.
+type Occurrence struct {
+	Snapshot  objects.MAC
+	Timestamp time.Time
+	Path      string
+}
+
+func (cmd *Find) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	index := newDigestIndex(cmd.algorithm)
+	if cmd.Index {
+		var err error
+		index, err = loadDigestIndex(ctx.CacheDir, repo.Configuration().RepositoryID, cmd.algorithm)
+		if err != nil {
+			ctx.GetLogger().Warn("find: ignoring digest index: %v", err)
+			index = newDigestIndex(cmd.algorithm)
+		}
+	}
+
+	type target struct {
+		id       objects.MAC
+		pathname string
+	}
+	var targets []target
+	if len(cmd.Targets) == 0 {
+		snapshotIDs, err := locate.LocateSnapshotIDs(repo, cmd.LocateOptions)
+		if err != nil {
+			return 1, fmt.Errorf("find: could not fetch snapshots list: %w", err)
+		}
+		for _, snapshotID := range snapshotIDs {
+			targets = append(targets, target{snapshotID, "/"})
+		}
+	} else {
+		for _, arg := range cmd.Targets {
+			snap, pathname, err := locate.OpenSnapshotByPath(repo, arg)
+			if err != nil {
+				return 1, fmt.Errorf("find: %s: %w", arg, err)
+			}
+			if pathname == "" {
+				pathname = "/"
+			}
+			targets = append(targets, target{snap.Header.Identifier, pathname})
+			snap.Close()
+		}
+	}
+
+	var found []Occurrence
+	var failed error
+	for _, t := range targets {
+		occurrences, err := cmd.findInSnapshot(ctx, repo, index, t.id, t.pathname)
+		found = append(found, occurrences...)
+		if err != nil {
+			failed = err
+			break
+		}
+	}
+
+	// whatever got hashed is worth keeping, even if we stopped early
+	if err := index.save(); err != nil {
+		ctx.GetLogger().Warn("find: could not save digest index: %v", err)
+	}
+	if failed != nil {
+		return 1, fmt.Errorf("find: %w", failed)
+	}
+
+	slices.SortStableFunc(found, func(a, b Occurrence) int {
+		if c := a.Timestamp.Compare(b.Timestamp); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	for _, o := range found {
+		fmt.Fprintf(ctx.Stdout, "%s %x:%s\n", o.Timestamp.UTC().Format(time.RFC3339),
+			o.Snapshot[:4], utils.SanitizeText(o.Path))
+	}
+
+	if len(found) == 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// findInSnapshot hashes each distinct content below pathname that the
+// index does not know yet, ctx.MaxConcurrency at once, then reports the
+// files whose digest matches.
+func (cmd *Find) findInSnapshot(ctx *appcontext.AppContext, repo *repository.Repository, index *digestIndex, id objects.MAC, pathname string) ([]Occurrence, error) {
+	snap, err := snapshot.Load(repo, id)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		pathname string
+		entry    *vfs.Entry
+	}
+	var files []file
+	err = fs.WalkDir(pathname, func(p string, e *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if e.Stat().Mode().IsRegular() {
+			files = append(files, file{p, e})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	wg := new(errgroup.Group)
+	wg.SetLimit(max(ctx.MaxConcurrency, 1))
+	queued := make(map[objects.MAC]struct{})
+	for _, f := range files {
+		mac := contentMAC(f.entry)
+		if _, ok := index.get(mac); ok {
+			continue
+		}
+		if _, ok := queued[mac]; ok {
+			continue
+		}
+		queued[mac] = struct{}{}
+
+		wg.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			digest, err := cmd.hash(fs, f.entry)
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.pathname, err)
+			}
+			index.put(mac, digest)
+			return nil
+		})
+	}
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+
+	var found []Occurrence
+	for _, f := range files {
+		if digest, _ := index.get(contentMAC(f.entry)); bytes.Equal(digest, cmd.digest) {
+			found = append(found, Occurrence{
+				Snapshot:  snap.Header.Identifier,
+				Timestamp: snap.Header.Timestamp,
+				Path:      f.pathname,
+			})
+		}
+	}
+	return found, nil
+}
+
+// contentMAC identifies the content of a file; all empty files share the
+// zero MAC.
+func contentMAC(e *vfs.Entry) objects.MAC {
+	if e.ResolvedObject == nil {
+		return objects.MAC{}
+	}
+	return e.ResolvedObject.ContentMAC
+}
+
+func (cmd *Find) hash(fs *vfs.Filesystem, e *vfs.Entry) ([]byte, error) {
+	hasher := hashing.GetHasher(cmd.algorithm)
+	if e.ResolvedObject == nil {
+		return hasher.Sum(nil), nil
+	}
+
+	rd, err := e.Open(fs)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	if _, err := io.Copy(hasher, rd); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
@@ -0,0 +1,110 @@
+package find
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func sha256hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFindDigest(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+
+	snap1 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("docs"),
+		ptesting.NewMockFile("docs/report.pdf", 0644, "confidential"),
+		ptesting.NewMockFile("docs/notes.txt", 0644, "nothing to see"),
+	})
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("tmp"),
+		ptesting.NewMockFile("tmp/copy", 0644, "confidential"),
+		ptesting.NewMockFile("tmp/other", 0644, "confidential, edited"),
+	})
+	defer snap2.Close()
+
+	run := func(args ...string) (int, []string) {
+		bufOut.Reset()
+		cmd := &Find{}
+		require.NoError(t, cmd.Parse(ctx, args))
+		status, err := cmd.Execute(ctx, repo)
+		require.NoError(t, err)
+		return status, strings.Split(strings.Trim(bufOut.String(), "\n"), "\n")
+	}
+
+	status, lines := run("-digest", "sha256:"+sha256hex("confidential"))
+	require.Equal(t, 0, status)
+	require.Len(t, lines, 2)
+	// oldest snapshot first
+	require.True(t, strings.HasSuffix(lines[0], hex.EncodeToString(snap1.Header.Identifier[:4])+":/docs/report.pdf"))
+	require.True(t, strings.HasSuffix(lines[1], hex.EncodeToString(snap2.Header.Identifier[:4])+":/tmp/copy"))
+	require.True(t, strings.HasPrefix(lines[0], snap1.Header.Timestamp.UTC().Format("2006-01-02T15:04:05Z")))
+
+	// the digests were remembered, a second search reads no file
+	index, err := loadDigestIndex(ctx.CacheDir, repo.Configuration().RepositoryID, "SHA256")
+	require.NoError(t, err)
+	require.Len(t, index.digests, 3)
+
+	status, lines = run("-digest", "SHA256:"+sha256hex("nothing to see"), hex.EncodeToString(snap1.Header.Identifier[:4])+":/docs")
+	require.Equal(t, 0, status)
+	require.Len(t, lines, 1)
+	require.Contains(t, lines[0], ":/docs/notes.txt")
+
+	status, _ = run("-index=false", "-digest", "SHA256:"+sha256hex("absent"))
+	require.Equal(t, 1, status)
+}
+
+func TestFindDigestIndexIsUsed(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("file", 0644, "content"),
+	})
+	defer snap.Close()
+
+	fs, err := snap.Filesystem()
+	require.NoError(t, err)
+	entry, err := fs.GetEntry("/file")
+	require.NoError(t, err)
+
+	// a forged digest in the index is trusted rather than recomputed
+	forged := bytes.Repeat([]byte{0x42}, 32)
+	index, err := loadDigestIndex(ctx.CacheDir, repo.Configuration().RepositoryID, "SHA256")
+	require.NoError(t, err)
+	index.put(contentMAC(entry), forged)
+	require.NoError(t, index.save())
+
+	cmd := &Find{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-digest", "SHA256:" + hex.EncodeToString(forged)}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), ":/file")
+
+	require.NotEqual(t, objects.MAC{}, contentMAC(entry))
+}
+
+func TestFindParseErrors(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	for _, args := range [][]string{
+		{},
+		{"-digest", sha256hex("x")},
+		{"-digest", "NOPE:" + sha256hex("x")},
+		{"-digest", "SHA256:zz"},
+		{"-digest", "SHA256:abcd"},
+	} {
+		require.Error(t, (&Find{}).Parse(ctx, args), args)
+	}
+}
@@ -0,0 +1,113 @@
+package find
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/google/uuid"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const INDEX_VERSION = "1.0.0"
+
+// digestIndex remembers the digest of the contents already hashed, keyed
+// by their content MAC: VFS entries only carry the MAC, which depends on
+// the repository key, so a plain digest costs reading the file once.
+// Without a file, it only lives as long as the command.
+type digestIndex struct {
+	mu        sync.Mutex
+	file      string
+	algorithm string
+	digests   map[objects.MAC][]byte
+	dirty     bool
+}
+
+type digestIndexOnDisk struct {
+	Version   string
+	Algorithm string
+	Digests   map[objects.MAC][]byte
+}
+
+func newDigestIndex(algorithm string) *digestIndex {
+	return &digestIndex{
+		algorithm: algorithm,
+		digests:   make(map[objects.MAC][]byte),
+	}
+}
+
+func loadDigestIndex(cacheDir string, repoID uuid.UUID, algorithm string) (*digestIndex, error) {
+	ix := newDigestIndex(algorithm)
+	ix.file = filepath.Join(cacheDir, "digestindex", INDEX_VERSION, repoID.String()+"-"+algorithm)
+
+	data, err := os.ReadFile(ix.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var disk digestIndexOnDisk
+	if err := msgpack.Unmarshal(data, &disk); err != nil {
+		return nil, fmt.Errorf("could not decode digest index: %w", err)
+	}
+	if disk.Version == INDEX_VERSION && disk.Algorithm == algorithm && disk.Digests != nil {
+		ix.digests = disk.Digests
+	}
+	return ix, nil
+}
+
+func (ix *digestIndex) get(mac objects.MAC) ([]byte, bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	digest, ok := ix.digests[mac]
+	return digest, ok
+}
+
+func (ix *digestIndex) put(mac objects.MAC, digest []byte) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.digests[mac] = digest
+	ix.dirty = true
+}
+
+// save writes the index back if anything was added, replacing the file
+// atomically.
+func (ix *digestIndex) save() error {
+	if ix.file == "" || !ix.dirty {
+		return nil
+	}
+
+	data, err := msgpack.Marshal(&digestIndexOnDisk{
+		Version:   INDEX_VERSION,
+		Algorithm: ix.algorithm,
+		Digests:   ix.digests,
+	})
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(ix.file)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".digestindex-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ix.file)
+}
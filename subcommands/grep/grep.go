@@ -0,0 +1,333 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package grep
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Grep{} }, 0, "grep")
+}
+
+func (cmd *Grep) CobraCommand() *cobra.Command {
+	cmd.LocateOptions = locate.NewDefaultLocateOptions()
+
+	c := &cobra.Command{
+		Use: "grep [OPTIONS] PATTERN [SNAPSHOT[:PATH]]...",
+	}
+	c.Flags().BoolVar(&cmd.IgnoreCase, "i", false, "case-insensitive matching")
+	c.Flags().BoolVar(&cmd.Extended, "E", false, "PATTERN is a regular expression rather than a fixed string")
+	c.Flags().BoolVar(&cmd.FilesOnly, "l", false, "only list the files that match")
+	c.Flags().BoolVar(&cmd.Decompress, "decompress", false, "search inside gzip and bzip2 compressed files")
+	c.Flags().StringVar(&cmd.Size, "size", "", "only search files of this size: +N, -N or N with an optional k, M, G or T suffix")
+	c.Flags().StringVar(&cmd.ContentType, "content-type", "", "only search files whose content type matches this glob, e.g. text/*")
+	subcommands.InstallGoFlags(c.Flags(), cmd.LocateOptions.InstallLocateFlags)
+	return c
+}
+
+func (cmd *Grep) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) == 0 {
+		return fmt.Errorf("a pattern is required")
+	}
+
+	expr := rest[0]
+	if !cmd.Extended {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if cmd.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	cmd.re, err = regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	if cmd.Size != "" {
+		cmd.size, err = utils.ParseSizeFilter(cmd.Size)
+		if err != nil {
+			return fmt.Errorf("-size: %w", err)
+		}
+	}
+	if _, err := path.Match(cmd.ContentType, ""); err != nil {
+		return fmt.Errorf("-content-type: %w", err)
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Pattern = rest[0]
+	cmd.Targets = rest[1:]
+
+	return nil
+}
+
+type Grep struct {
+	subcommands.SubcommandBase
+
+	LocateOptions *locate.LocateOptions
+	IgnoreCase    bool
+	Extended      bool
+	FilesOnly     bool
+	Decompress    bool
+	Size          string
+	ContentType   string
+	Pattern       string
+	Targets       []string
+
+	re   *regexp.Regexp
+	size *utils.SizeFilter
+}
+
+// result is the output for one file, which is searched concurrently with
+// others but printed in walk order.
+type result struct {
+	out     bytes.Buffer
+	matched bool
+	err     error
+}
+
+func (cmd *Grep) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	type target struct {
+		snap     *snapshot.Snapshot
+		pathname string
+	}
+
+	var targets []target
+	defer func() {
+		for _, t := range targets {
+			t.snap.Close()
+		}
+	}()
+
+	if len(cmd.Targets) == 0 {
+		snapshotIDs, err := locate.LocateSnapshotIDs(repo, cmd.LocateOptions)
+		if err != nil {
+			return 1, fmt.Errorf("grep: could not fetch snapshots list: %w", err)
+		}
+		for _, snapshotID := range snapshotIDs {
+			snap, err := snapshot.Load(repo, snapshotID)
+			if err != nil {
+				return 1, fmt.Errorf("grep: could not load snapshot: %w", err)
+			}
+			targets = append(targets, target{snap, "/"})
+		}
+	} else {
+		for _, arg := range cmd.Targets {
+			snap, pathname, err := locate.OpenSnapshotByPath(repo, arg)
+			if err != nil {
+				return 1, fmt.Errorf("grep: %s: %w", arg, err)
+			}
+			if pathname == "" {
+				pathname = "/"
+			}
+			targets = append(targets, target{snap, pathname})
+		}
+	}
+
+	matched, failed := false, 0
+	for _, t := range targets {
+		m, f, err := cmd.grepSnapshot(ctx, t.snap, t.pathname)
+		if err != nil {
+			return 1, err
+		}
+		matched = matched || m
+		failed += f
+	}
+
+	if failed != 0 {
+		return 1, fmt.Errorf("errors occurred")
+	}
+	if !matched {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// grepSnapshot searches the files below pathname, ctx.MaxConcurrency of
+// them at once.
+func (cmd *Grep) grepSnapshot(ctx *appcontext.AppContext, snap *snapshot.Snapshot, pathname string) (bool, int, error) {
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return false, 0, fmt.Errorf("grep: could not get filesystem: %w", err)
+	}
+	shortID := fmt.Sprintf("%x", snap.Header.Identifier[0:4])
+
+	var (
+		matched bool
+		failed  int
+		pending = make(chan chan *result, max(ctx.MaxConcurrency, 1))
+		done    = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for ch := range pending {
+			r := <-ch
+			if r.err != nil {
+				ctx.GetLogger().Error("grep: %s", r.err)
+				failed++
+				continue
+			}
+			matched = matched || r.matched
+			ctx.Stdout.Write(r.out.Bytes())
+		}
+	}()
+
+	err = fs.WalkDir(pathname, func(p string, e *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !cmd.wanted(e) {
+			return nil
+		}
+
+		ch := make(chan *result, 1)
+		pending <- ch
+		go func() {
+			ch <- cmd.grepFile(fs, shortID+":"+utils.SanitizeText(p), e)
+		}()
+		return nil
+	})
+	close(pending)
+	<-done
+
+	if err != nil {
+		return matched, failed, fmt.Errorf("grep: %w", err)
+	}
+	return matched, failed, nil
+}
+
+func (cmd *Grep) wanted(e *vfs.Entry) bool {
+	if !e.Stat().Mode().IsRegular() {
+		return false
+	}
+	if cmd.size != nil && !cmd.size.Match(e.Stat().Size()) {
+		return false
+	}
+	if cmd.ContentType != "" {
+		if e.ResolvedObject == nil {
+			return false
+		}
+		ok, _ := path.Match(cmd.ContentType, contentType(e))
+		return ok
+	}
+	return true
+}
+
+func contentType(e *vfs.Entry) string {
+	if e.ResolvedObject == nil {
+		return ""
+	}
+	ct, _, _ := strings.Cut(e.ResolvedObject.ContentType, ";")
+	return strings.TrimSpace(ct)
+}
+
+// compression guesses how a file is compressed from its content type or,
+// failing that, its name.
+func compression(e *vfs.Entry) string {
+	switch contentType(e) {
+	case "application/gzip", "application/x-gzip":
+		return "gzip"
+	case "application/x-bzip2":
+		return "bzip2"
+	}
+	switch path.Ext(e.Name()) {
+	case ".gz", ".tgz":
+		return "gzip"
+	case ".bz2", ".tbz2":
+		return "bzip2"
+	}
+	return ""
+}
+
+// grepFile searches a file, name being its snapshot:path as printed.
+func (cmd *Grep) grepFile(fs *vfs.Filesystem, name string, e *vfs.Entry) *result {
+	r := &result{}
+
+	file, err := e.Open(fs)
+	if err != nil {
+		r.err = fmt.Errorf("%s: %w", name, err)
+		return r
+	}
+	defer file.Close()
+
+	var rd io.Reader = file
+	if cmd.Decompress {
+		switch compression(e) {
+		case "gzip":
+			gz, err := gzip.NewReader(rd)
+			if err != nil {
+				r.err = fmt.Errorf("%s: %w", name, err)
+				return r
+			}
+			defer gz.Close()
+			rd = gz
+		case "bzip2":
+			rd = bzip2.NewReader(rd)
+		}
+	}
+
+	br := bufio.NewReader(rd)
+	head, _ := br.Peek(utils.BinarySniffLen)
+	if utils.IsBinary(head) {
+		return r
+	}
+
+	for lineno := 1; ; lineno++ {
+		line, err := br.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		if cmd.re.Match(line) && (len(line) != 0 || err == nil) {
+			r.matched = true
+			if cmd.FilesOnly {
+				fmt.Fprintf(&r.out, "%s\n", name)
+				return r
+			}
+			fmt.Fprintf(&r.out, "%s:%d:%s\n", name, lineno, utils.SanitizeText(string(line)))
+		}
+		if errors.Is(err, io.EOF) {
+			return r
+		}
+		if err != nil {
+			r.err = fmt.Errorf("%s: %w", name, err)
+			return r
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package grep
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	os.Setenv("TZ", "UTC")
+}
+
+func gzipped(t *testing.T, content string) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.String()
+}
+
+func generateSnapshot(t *testing.T, bufOut *bytes.Buffer) (*repository.Repository, *snapshot.Snapshot, *appcontext.AppContext) {
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("etc"),
+		ptesting.NewMockFile("etc/app.conf", 0644, "user = admin\npassword = hunter2\n"),
+		ptesting.NewMockFile("etc/other.conf", 0644, "Password = Secret\n"),
+		ptesting.NewMockFile("etc/blob.bin", 0644, "\x00\x01password = hunter2\n"),
+		ptesting.NewMockDir("var"),
+		ptesting.NewMockFile("var/app.log.gz", 0644, gzipped(t, "login ok\npassword = hunter2\n")),
+	})
+	return repo, snap, ctx
+}
+
+func runGrep(t *testing.T, args ...string) (int, []string) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap, ctx := generateSnapshot(t, bufOut)
+	defer snap.Close()
+
+	cmd := &Grep{}
+	require.NoError(t, cmd.Parse(ctx, args))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+
+	output := strings.Trim(bufOut.String(), "\n")
+	if output == "" {
+		return status, nil
+	}
+	return status, strings.Split(output, "\n")
+}
+
+func TestGrepFixedString(t *testing.T) {
+	status, lines := runGrep(t, "hunter2")
+	require.Equal(t, 0, status)
+	// the binary file and the compressed one are skipped
+	require.Len(t, lines, 1)
+	require.Regexp(t, `^[0-9a-f]{8}:/etc/app.conf:2:password = hunter2$`, lines[0])
+
+	// without -E, the pattern is not a regular expression
+	status, lines = runGrep(t, "hunter.")
+	require.Equal(t, 1, status)
+	require.Empty(t, lines)
+}
+
+func TestGrepRegexAndCase(t *testing.T) {
+	_, lines := runGrep(t, "-E", "^pass")
+	require.Len(t, lines, 1)
+
+	_, lines = runGrep(t, "-i", "-E", "^pass")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[1], "/etc/other.conf:1:Password = Secret")
+}
+
+func TestGrepFilesOnly(t *testing.T) {
+	_, lines := runGrep(t, "-l", "-i", "password")
+	require.Len(t, lines, 2)
+	require.Regexp(t, `^[0-9a-f]{8}:/etc/app.conf$`, lines[0])
+	require.Regexp(t, `^[0-9a-f]{8}:/etc/other.conf$`, lines[1])
+}
+
+func TestGrepDecompress(t *testing.T) {
+	_, lines := runGrep(t, "-decompress", "hunter2")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[1], "/var/app.log.gz:2:password = hunter2")
+}
+
+func TestGrepFilters(t *testing.T) {
+	// app.conf is 31 bytes, other.conf 18
+	_, lines := runGrep(t, "-l", "-i", "-size", "-20", "password")
+	require.Len(t, lines, 1)
+	require.Contains(t, lines[0], "/etc/other.conf")
+
+	status, lines := runGrep(t, "-content-type", "image/*", "hunter2")
+	require.Equal(t, 1, status)
+	require.Empty(t, lines)
+}
+
+func TestGrepSnapshotPath(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap, ctx := generateSnapshot(t, bufOut)
+	defer snap.Close()
+
+	shortID := hex.EncodeToString(snap.Header.GetIndexShortID())
+	cmd := &Grep{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-i", "password", shortID + ":/etc/other.conf"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Equal(t, shortID+":/etc/other.conf:1:Password = Secret\n", bufOut.String())
+}
+
+func TestGrepParseErrors(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	require.Error(t, (&Grep{}).Parse(ctx, []string{}))
+	require.Error(t, (&Grep{}).Parse(ctx, []string{"-E", "("}))
+	require.Error(t, (&Grep{}).Parse(ctx, []string{"-size", "x", "foo"}))
+	require.Error(t, (&Grep{}).Parse(ctx, []string{"-content-type", "[", "foo"}))
+}
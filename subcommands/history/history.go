@@ -0,0 +1,204 @@
+package history
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	phistory "github.com/PlakarKorp/plakar/history"
+	"github.com/PlakarKorp/plakar/pathindex"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &History{} }, 0, "history-of")
+}
+
+func (cmd *History) CobraCommand() *cobra.Command {
+	cmd.LocateOptions = locate.NewDefaultLocateOptions()
+
+	c := &cobra.Command{
+		Use: "history-of [OPTIONS] PATH",
+	}
+	c.Flags().IntVar(&cmd.Cat, "cat", 0, "print version N of the file")
+	c.Flags().IntVar(&cmd.Restore, "restore", 0, "restore version N of the file")
+	c.Flags().StringVar(&cmd.Target, "to", "", "where -restore writes the file")
+	subcommands.InstallGoFlags(c.Flags(), cmd.LocateOptions.InstallLocateFlags)
+	return c
+}
+
+func (cmd *History) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) != 1 {
+		return fmt.Errorf("a single path is required")
+	}
+	if cmd.Cat < 0 || cmd.Restore < 0 {
+		return fmt.Errorf("versions are numbered from 1")
+	}
+	if cmd.Cat != 0 && cmd.Restore != 0 {
+		return fmt.Errorf("-cat and -restore are mutually exclusive")
+	}
+	if cmd.Target != "" && cmd.Restore == 0 {
+		return fmt.Errorf("-to is only valid with -restore")
+	}
+
+	pathname := filepath.ToSlash(rest[0])
+	if !path.IsAbs(pathname) {
+		pathname = path.Join(filepath.ToSlash(ctx.CWD), pathname)
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Path = path.Clean(pathname)
+
+	return nil
+}
+
+type History struct {
+	subcommands.SubcommandBase
+
+	LocateOptions *locate.LocateOptions
+	Cat           int
+	Restore       int
+	Target        string
+	Path          string
+}
+
+func (cmd *History) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	snapshotIDs, err := locate.LocateSnapshotIDs(repo, cmd.LocateOptions)
+	if err != nil {
+		return 1, fmt.Errorf("history-of: could not fetch snapshots list: %w", err)
+	}
+
+	if index, err := pathindex.Load(ctx.CacheDir, repo.Configuration().RepositoryID); err == nil {
+		snapshotIDs = index.Candidates(snapshotIDs, cmd.Path)
+	}
+
+	versions, err := phistory.Versions(repo, snapshotIDs, cmd.Path)
+	if err != nil {
+		return 1, fmt.Errorf("history-of: %s: %w", cmd.Path, err)
+	}
+
+	if n := max(cmd.Cat, cmd.Restore); n != 0 {
+		if n > len(versions) {
+			return 1, fmt.Errorf("history-of: %s: no version %d, there are %d", cmd.Path, n, len(versions))
+		}
+		if cmd.Cat != 0 {
+			err = cmd.cat(ctx, repo, &versions[n-1])
+		} else {
+			err = cmd.restore(ctx, repo, &versions[n-1])
+		}
+		if err != nil {
+			return 1, fmt.Errorf("history-of: %w", err)
+		}
+		return 0, nil
+	}
+
+	for _, v := range versions {
+		var seen string
+		if len(v.Snapshots) > 1 {
+			seen = fmt.Sprintf(" (%d snapshots, until %s)", len(v.Snapshots), v.LastSeen.UTC().Format(time.RFC3339))
+		}
+		fmt.Fprintf(ctx.Stdout, "%3d %s %s %s % 8s %s%s\n",
+			v.Index,
+			v.Timestamp.UTC().Format(time.RFC3339),
+			hex.EncodeToString(v.Snapshot[:4]),
+			v.Mode,
+			humanize.IBytes(uint64(v.Size)),
+			hex.EncodeToString(v.ContentMAC[:4]),
+			seen)
+	}
+	return 0, nil
+}
+
+// open returns the content of a version, which must be a regular file.
+func (cmd *History) open(repo *repository.Repository, v *phistory.Version) (*snapshot.Snapshot, io.ReadCloser, error) {
+	if !v.Mode.IsRegular() {
+		return nil, nil, fmt.Errorf("%s: not a regular file", cmd.Path)
+	}
+
+	snap, err := snapshot.Load(repo, v.Snapshot)
+	if err != nil {
+		return nil, nil, err
+	}
+	fs, err := snap.Filesystem()
+	if err != nil {
+		snap.Close()
+		return nil, nil, err
+	}
+	entry, err := fs.GetEntry(cmd.Path)
+	if err != nil {
+		snap.Close()
+		return nil, nil, err
+	}
+	rd, err := entry.Open(fs)
+	if err != nil {
+		snap.Close()
+		return nil, nil, err
+	}
+	return snap, rd, nil
+}
+
+func (cmd *History) cat(ctx *appcontext.AppContext, repo *repository.Repository, v *phistory.Version) error {
+	snap, rd, err := cmd.open(repo, v)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+	defer rd.Close()
+
+	_, err = io.Copy(ctx.Stdout, rd)
+	return err
+}
+
+// restore writes a version next to the current directory, or to -to, and
+// never overwrites an existing file.
+func (cmd *History) restore(ctx *appcontext.AppContext, repo *repository.Repository, v *phistory.Version) error {
+	target := cmd.Target
+	if target == "" {
+		target = filepath.Join(ctx.CWD, fmt.Sprintf("%s.%d", path.Base(cmd.Path), v.Index))
+	} else if info, err := os.Stat(target); err == nil && info.IsDir() {
+		target = filepath.Join(target, path.Base(cmd.Path))
+	}
+
+	snap, rd, err := cmd.open(repo, v)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+	defer rd.Close()
+
+	fp, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, v.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fp, rd); err != nil {
+		fp.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(target, v.ModTime, v.ModTime); err != nil {
+		return err
+	}
+
+	ctx.GetLogger().Info("history-of: restored version %d of %s from %x to %s",
+		v.Index, utils.SanitizeText(cmd.Path), v.Snapshot[:4], target)
+	return nil
+}
@@ -0,0 +1,101 @@
+package history
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	os.Setenv("TZ", "UTC")
+}
+
+func generateHistory(t *testing.T, bufOut *bytes.Buffer) (*repository.Repository, *appcontext.AppContext) {
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	for _, content := range []string{"first", "first", "second"} {
+		snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+			ptesting.NewMockDir("etc"),
+			ptesting.NewMockFile("etc/app.conf", 0644, content),
+		})
+		snap.Close()
+	}
+	return repo, ctx
+}
+
+func TestHistoryList(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := generateHistory(t, bufOut)
+
+	cmd := &History{}
+	require.NoError(t, cmd.Parse(ctx, []string{"/etc/app.conf"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	lines := strings.Split(strings.Trim(bufOut.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	require.True(t, strings.HasPrefix(lines[0], "  1 "))
+	require.Contains(t, lines[0], "(2 snapshots, until ")
+	require.True(t, strings.HasPrefix(lines[1], "  2 "))
+}
+
+func TestHistoryCat(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := generateHistory(t, bufOut)
+
+	cmd := &History{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-cat", "2", "/etc/app.conf"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Equal(t, "second", bufOut.String())
+
+	cmd = &History{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-cat", "3", "/etc/app.conf"}))
+	_, err = cmd.Execute(ctx, repo)
+	require.Error(t, err)
+}
+
+func TestHistoryRestore(t *testing.T) {
+	repo, ctx := generateHistory(t, bytes.NewBuffer(nil))
+	dir := t.TempDir()
+
+	cmd := &History{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-restore", "1", "-to", dir, "/etc/app.conf"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	data, err := os.ReadFile(filepath.Join(dir, "app.conf"))
+	require.NoError(t, err)
+	require.Equal(t, "first", string(data))
+
+	// never overwrites
+	_, err = cmd.Execute(ctx, repo)
+	require.Error(t, err)
+}
+
+func TestHistoryParse(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	cmd := &History{}
+	require.Error(t, cmd.Parse(ctx, []string{}))
+
+	cmd = &History{}
+	require.Error(t, cmd.Parse(ctx, []string{"-cat", "1", "-restore", "1", "/a"}))
+
+	cmd = &History{}
+	require.Error(t, cmd.Parse(ctx, []string{"-to", "/tmp", "/a"}))
+
+	ctx.CWD = "/etc"
+	cmd = &History{}
+	require.NoError(t, cmd.Parse(ctx, []string{"nginx/../app.conf"}))
+	require.Equal(t, "/etc/app.conf", cmd.Path)
+}
@@ -0,0 +1,24 @@
+package hold
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRegisteredFactory looks the commands up through the registry, which
+// invokes the factory closures registered in init().
+func TestRegisteredFactory(t *testing.T) {
+	cmd, _, _ := subcommands.Lookup([]string{"hold", "add"})
+	require.IsType(t, &HoldAdd{}, cmd)
+
+	cmd, _, _ = subcommands.Lookup([]string{"hold", "list"})
+	require.IsType(t, &HoldList{}, cmd)
+
+	cmd, _, _ = subcommands.Lookup([]string{"hold", "release"})
+	require.IsType(t, &HoldRelease{}, cmd)
+
+	cmd, _, _ = subcommands.Lookup([]string{"hold"})
+	require.IsType(t, &Hold{}, cmd)
+}
@@ -0,0 +1,261 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package hold
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/go-human2duration"
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/hold"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &HoldAdd{} }, 0, "hold", "add")
+	subcommands.Register(func() subcommands.Subcommand { return &HoldList{} }, 0, "hold", "list")
+	subcommands.Register(func() subcommands.Subcommand { return &HoldRelease{} }, 0, "hold", "release")
+	subcommands.Register(func() subcommands.Subcommand { return &Hold{} }, 0, "hold")
+}
+
+type Hold struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *Hold) CobraCommand() *cobra.Command {
+	return &cobra.Command{
+		Use: "hold",
+	}
+}
+
+func (cmd *Hold) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) > 0 {
+		return fmt.Errorf("invalid argument: %s", rest[0])
+	}
+	return fmt.Errorf("no action specified")
+}
+
+func (cmd *Hold) Execute(ctx *appcontext.AppContext, _ *repository.Repository) (int, error) {
+	return 1, fmt.Errorf("no action specified")
+}
+
+// parseUntil reads the end of a hold, either a date or a duration from
+// now.
+func parseUntil(value string, now time.Time) (time.Time, error) {
+	if d, err := human2duration.ParseDuration(value); err == nil {
+		return now.Add(d), nil
+	}
+	return utils.ParseTimeFlag(value)
+}
+
+type HoldAdd struct {
+	subcommands.SubcommandBase
+
+	Until    string
+	Reason   string
+	Snapshot string
+
+	until time.Time
+}
+
+func (cmd *HoldAdd) CobraCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use: "hold add SNAPSHOT -until DATE [-reason TEXT]",
+	}
+	c.Flags().StringVar(&cmd.Until, "until", "", "keep the snapshot until this date, or for this long, e.g. 2030-01-01 or 7y")
+	c.Flags().StringVar(&cmd.Reason, "reason", "", "why the snapshot is on hold")
+	return c
+}
+
+func (cmd *HoldAdd) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) != 1 {
+		return fmt.Errorf("exactly one snapshot is required")
+	}
+	if cmd.Until == "" {
+		return fmt.Errorf("-until is required")
+	}
+	cmd.until, err = parseUntil(cmd.Until, time.Now())
+	if err != nil {
+		return fmt.Errorf("-until: %w", err)
+	}
+	if !cmd.until.After(time.Now()) {
+		return fmt.Errorf("-until: %s is in the past", cmd.Until)
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Snapshot = rest[0]
+
+	return nil
+}
+
+func (cmd *HoldAdd) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	snap, pathname, err := locate.OpenSnapshotByPath(repo, cmd.Snapshot)
+	if err != nil {
+		return 1, fmt.Errorf("hold: %s: %w", cmd.Snapshot, err)
+	}
+	id := snap.Header.Identifier
+	snap.Close()
+	if pathname != "" && pathname != "/" {
+		return 1, fmt.Errorf("hold: %s: snapshots are held as a whole", cmd.Snapshot)
+	}
+
+	holds, err := hold.Load(repo)
+	if err != nil {
+		return 1, fmt.Errorf("hold: %w", err)
+	}
+
+	now := time.Now()
+	if err := holds.Check(id, cmd.until); err != nil {
+		// never shorten an active hold by accident
+		return 1, fmt.Errorf("hold: %x: %w", id[:4], err)
+	}
+	if err := holds.Put(hold.Hold{
+		Snapshot: id,
+		Until:    cmd.until,
+		Reason:   cmd.Reason,
+		Created:  now,
+	}); err != nil {
+		return 1, fmt.Errorf("hold: %w", err)
+	}
+
+	ctx.GetLogger().Info("hold: snapshot %x is on hold until %s", id[:4], cmd.until.UTC().Format(time.RFC3339))
+	return 0, nil
+}
+
+type HoldList struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *HoldList) CobraCommand() *cobra.Command {
+	return &cobra.Command{
+		Use: "hold list",
+	}
+}
+
+func (cmd *HoldList) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+func (cmd *HoldList) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	holds, err := hold.Load(repo)
+	if err != nil {
+		return 1, fmt.Errorf("hold: %w", err)
+	}
+
+	now := time.Now()
+	for _, h := range holds.List() {
+		status := "active"
+		if !h.Active(now) {
+			status = "expired"
+		}
+		fmt.Fprintf(ctx.Stdout, "%s %x %-7s %s\n", h.Until.UTC().Format(time.RFC3339),
+			h.Snapshot[:4], status, utils.SanitizeText(h.Reason))
+	}
+	return 0, nil
+}
+
+type HoldRelease struct {
+	subcommands.SubcommandBase
+
+	Snapshots []string
+}
+
+func (cmd *HoldRelease) CobraCommand() *cobra.Command {
+	return &cobra.Command{
+		Use: "hold release SNAPSHOT...",
+	}
+}
+
+func (cmd *HoldRelease) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) == 0 {
+		return fmt.Errorf("at least one snapshot is required")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Snapshots = rest
+
+	return nil
+}
+
+func (cmd *HoldRelease) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	holds, err := hold.Load(repo)
+	if err != nil {
+		return 1, fmt.Errorf("hold: %w", err)
+	}
+
+	// resolved against the holds rather than the repository, the snapshot
+	// may be gone already
+	var released []objects.MAC
+	for _, prefix := range cmd.Snapshots {
+		var matches []objects.MAC
+		for _, h := range holds.List() {
+			if strings.HasPrefix(hex.EncodeToString(h.Snapshot[:]), strings.ToLower(prefix)) {
+				matches = append(matches, h.Snapshot)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return 1, fmt.Errorf("hold: %s: no such hold", prefix)
+		case 1:
+			released = append(released, matches[0])
+		default:
+			return 1, fmt.Errorf("hold: %s: ambiguous snapshot identifier", prefix)
+		}
+	}
+
+	for _, id := range released {
+		if _, err := holds.Release(id); err != nil {
+			return 1, fmt.Errorf("hold: %w", err)
+		}
+		ctx.GetLogger().Info("hold: released snapshot %x", id[:4])
+	}
+	return 0, nil
+}
@@ -0,0 +1,101 @@
+package hold
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/subcommands/prune"
+	"github.com/PlakarKorp/plakar/subcommands/rm"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHoldAddListRelease(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "hello"),
+	})
+	snap.Close()
+	newer := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("b.txt", 0644, "world"),
+	})
+	newer.Close()
+	shortID := hex.EncodeToString(snap.Header.GetIndexShortID())
+
+	add := &HoldAdd{}
+	require.NoError(t, add.Parse(ctx, []string{shortID, "-until", "2h", "-reason", "litigation"}))
+	status, err := add.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	list := &HoldList{}
+	require.NoError(t, list.Parse(ctx, nil))
+	status, err = list.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), shortID+" active  litigation")
+
+	// rm refuses to delete a held snapshot, even with -apply
+	rmCmd := &rm.Rm{}
+	require.NoError(t, rmCmd.Parse(ctx, []string{"-apply", shortID}))
+	status, err = rmCmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+	require.Contains(t, err.Error(), "on hold")
+
+	// prune keeps it whatever the policy says
+	pruneCmd := &prune.Prune{}
+	require.NoError(t, pruneCmd.Parse(ctx, []string{"-apply", "--per-minute=1"}))
+	status, err = pruneCmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NotContains(t, bufOut.String(), "prune: removal of")
+
+	release := &HoldRelease{}
+	require.NoError(t, release.Parse(ctx, []string{shortID}))
+	status, err = release.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	rmCmd = &rm.Rm{}
+	require.NoError(t, rmCmd.Parse(ctx, []string{"-apply", shortID}))
+	status, err = rmCmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+}
+
+func TestHoldAddParse(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	cmd := &HoldAdd{}
+	require.Error(t, cmd.Parse(ctx, []string{"abcd"}))
+
+	cmd = &HoldAdd{}
+	require.Error(t, cmd.Parse(ctx, []string{"-until", "2h"}))
+
+	cmd = &HoldAdd{}
+	require.Error(t, cmd.Parse(ctx, []string{"abcd", "-until", "2001-01-01"}))
+
+	cmd = &HoldAdd{}
+	require.NoError(t, cmd.Parse(ctx, []string{"abcd", "-until", "2099-01-01"}))
+}
+
+func TestParseUntil(t *testing.T) {
+	now := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+
+	until, err := parseUntil("2h", now)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(2*time.Hour), until)
+
+	until, err = parseUntil("2030-01-01", now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), until)
+
+	_, err = parseUntil("someday", now)
+	require.Error(t, err)
+}
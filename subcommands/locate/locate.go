@@ -17,14 +17,21 @@
 package locate
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"path"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+	"time"
 
 	plocate "github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/pathindex"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/spf13/cobra"
@@ -41,6 +48,14 @@ func (cmd *Locate) CobraCommand() *cobra.Command {
 		Use: "locate [OPTIONS] PATTERN...",
 	}
 	c.Flags().StringVar(&cmd.Snapshot, "snapshot", "", "snapshot to locate in")
+	c.Flags().BoolVar(&cmd.Regex, "regex", false, "patterns are regular expressions matched against the full path")
+	c.Flags().BoolVar(&cmd.IgnoreCase, "i", false, "case-insensitive matching")
+	c.Flags().StringVar(&cmd.optSize, "size", "", "only match files of this size: +N, -N or N with an optional k, M, G or T suffix")
+	c.Flags().Var(subcommands.GoValue(utils.NewTimeFlag(&cmd.Newer)), "newer", "only match entries modified after this date or duration")
+	c.Flags().Var(subcommands.GoValue(utils.NewTimeFlag(&cmd.Older)), "older", "only match entries modified before this date or duration")
+	c.Flags().StringVar(&cmd.Type, "type", "", "only match entries of these types: f (file), d (directory), l (symlink)")
+	c.Flags().StringVar(&cmd.Format, "format", "text", "output format: text, json")
+	c.Flags().BoolVar(&cmd.LatestOnly, "latest-only", false, "only report a path in the most recent snapshot holding it")
 	subcommands.InstallGoFlags(c.Flags(), cmd.LocateOptions.InstallLocateFlags)
 	return c
 }
@@ -55,6 +70,21 @@ func (cmd *Locate) Parse(ctx *appcontext.AppContext, args []string) error {
 		ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
 	}
 
+	if cmd.optSize != "" {
+		if _, err := utils.ParseSizeFilter(cmd.optSize); err != nil {
+			return fmt.Errorf("-size: %w", err)
+		}
+		cmd.Size = cmd.optSize
+	}
+	if strings.Trim(cmd.Type, "fdl") != "" {
+		return fmt.Errorf("-type: invalid type %q, expected a combination of f, d and l", cmd.Type)
+	}
+	switch cmd.Format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("unsupported format: %s", cmd.Format)
+	}
+
 	cmd.RepositorySecret = ctx.GetSecret()
 	cmd.Patterns = rest
 
@@ -66,7 +96,28 @@ type Locate struct {
 
 	LocateOptions *plocate.LocateOptions
 	Snapshot      string
+	Regex         bool
+	IgnoreCase    bool
+	Size          string
+	Newer         time.Time
+	Older         time.Time
+	Type          string
+	Format        string
+	LatestOnly    bool
 	Patterns      []string
+
+	optSize string
+}
+
+// Match is a located entry, as printed by -format json.
+type Match struct {
+	Snapshot          string    `json:"snapshot"`
+	SnapshotTimestamp time.Time `json:"snapshot_timestamp"`
+	Path              string    `json:"path"`
+	Type              string    `json:"type"`
+	Mode              string    `json:"mode"`
+	Size              int64     `json:"size"`
+	ModTime           time.Time `json:"mtime"`
 }
 
 func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -82,7 +133,51 @@ func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 		snapshots = append(snapshots, snapshotIDs...)
 	}
 
+	matchers := make([]*matcher, 0, len(cmd.Patterns))
+	for _, pattern := range cmd.Patterns {
+		m, err := compilePattern(pattern, cmd.Regex, cmd.IgnoreCase)
+		if err != nil {
+			return 1, fmt.Errorf("locate: could not match pattern: %w", err)
+		}
+		matchers = append(matchers, m)
+	}
+
+	filter := &filters{newer: cmd.Newer, older: cmd.Older, types: cmd.Type}
+	if cmd.Size != "" {
+		filter.size, _ = utils.ParseSizeFilter(cmd.Size)
+	}
+	if len(matchers) == 0 && filter.empty() {
+		// without a pattern, only the filters can select entries
+		return 0, nil
+	}
+
+	// with -latest-only, the newest snapshot holding a path wins and
+	// nothing is printed before all snapshots were seen
+	latest := make(map[string]*Match)
+	needEntry := !filter.empty() || cmd.Format == "json" || cmd.LatestOnly
+
+	enc := json.NewEncoder(ctx.Stdout)
+	report := func(m *Match) error {
+		if cmd.Format == "json" {
+			return enc.Encode(m)
+		}
+		_, err := fmt.Fprintf(ctx.Stdout, "%s:%s\n", m.Snapshot[:8], utils.SanitizeText(m.Path))
+		return err
+	}
+
+	// the path index, when cached has built one, tells which snapshots
+	// hold matching paths without walking them
+	var hits map[objects.MAC][]string
+	if index, err := pathindex.Load(ctx.CacheDir, repo.Configuration().RepositoryID); err == nil {
+		hits = indexHits(index, snapshots, matchers)
+	}
+
 	for _, snapshotID := range snapshots {
+		paths, indexed := hits[snapshotID]
+		if indexed && len(paths) == 0 {
+			continue
+		}
+
 		snap, err := snapshot.Load(repo, snapshotID)
 		if err != nil {
 			return 1, fmt.Errorf("locate: could not get snapshot: %w", err)
@@ -93,35 +188,100 @@ func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 			snap.Close()
 			return 1, fmt.Errorf("locate: could not get filesystem: %w", err)
 		}
-		for pathname, err := range fs.Pathnames() {
+
+		var pathnames iter.Seq2[string, error] = fs.Pathnames()
+		if indexed {
+			pathnames = func(yield func(string, error) bool) {
+				for _, p := range paths {
+					if !yield(p, nil) {
+						return
+					}
+				}
+			}
+		}
+		for pathname, err := range pathnames {
 			if err != nil {
 				snap.Close()
 				return 1, fmt.Errorf("locate: could not get pathname: %w", err)
 			}
 
 			if err := ctx.Err(); err != nil {
+				snap.Close()
 				return 1, err
 			}
 
-			for _, pattern := range cmd.Patterns {
-				matched := false
-				if path.Base(pathname) == pattern {
-					matched = true
+			if len(matchers) != 0 && !matchAny(matchers, pathname) {
+				continue
+			}
+
+			m := &Match{
+				Snapshot:          hex.EncodeToString(snap.Header.Identifier[:]),
+				SnapshotTimestamp: snap.Header.Timestamp,
+				Path:              pathname,
+			}
+			if needEntry {
+				entry, err := fs.GetEntry(pathname)
+				if err != nil {
+					snap.Close()
+					return 1, fmt.Errorf("locate: %s: %w", pathname, err)
 				}
-				if !matched {
-					matched, err := path.Match(pattern, path.Base(pathname))
-					if err != nil {
-						snap.Close()
-						return 1, fmt.Errorf("locate: could not match pattern: %w", err)
-					}
-					if !matched {
-						continue
-					}
+				info := entry.Stat()
+				if !filter.match(info) {
+					continue
 				}
-				fmt.Fprintf(ctx.Stdout, "%x:%s\n", snap.Header.Identifier[0:4], utils.SanitizeText(pathname))
+				m.Type = entryType(info.Mode())
+				m.Mode = info.Mode().String()
+				m.ModTime = info.ModTime()
+				if !info.IsDir() {
+					m.Size = info.Size()
+				}
+			}
+
+			if cmd.LatestOnly {
+				if prev, ok := latest[pathname]; !ok || prev.SnapshotTimestamp.Before(m.SnapshotTimestamp) {
+					latest[pathname] = m
+				}
+				continue
+			}
+			if err := report(m); err != nil {
+				snap.Close()
+				return 1, err
 			}
 		}
 		snap.Close()
 	}
+
+	if cmd.LatestOnly {
+		for _, pathname := range slices.Sorted(maps.Keys(latest)) {
+			if err := report(latest[pathname]); err != nil {
+				return 1, err
+			}
+		}
+	}
 	return 0, nil
 }
+
+func indexHits(index *pathindex.Index, snapshots []objects.MAC, matchers []*matcher) map[objects.MAC][]string {
+	if len(matchers) == 0 {
+		return index.Match(snapshots, func(string) bool { return true })
+	}
+	for _, m := range matchers {
+		if !m.basename {
+			return index.Match(snapshots, func(pathname string) bool {
+				return matchAny(matchers, pathname)
+			})
+		}
+	}
+	return index.MatchName(snapshots, func(name string) bool {
+		return matchAny(matchers, name)
+	})
+}
+
+func matchAny(matchers []*matcher, pathname string) bool {
+	for _, m := range matchers {
+		if m.match(pathname) {
+			return true
+		}
+	}
+	return false
+}
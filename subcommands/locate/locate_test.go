@@ -3,7 +3,9 @@ package locate
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"os"
+	"slices"
 	"strings"
 	"testing"
 
@@ -11,6 +13,7 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/pathindex"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
 )
@@ -140,3 +143,149 @@ func TestExecuteCmdLocateWithSnapshotId(t *testing.T) {
 	lines := strings.Split(strings.Trim(output, "\n"), "\n")
 	require.Equal(t, 1, len(lines))
 }
+
+func runLocate(t *testing.T, args ...string) []string {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap, ctx := generateSnapshot(t, bufOut, bytes.NewBuffer(nil))
+	defer snap.Close()
+
+	cmd := &Locate{}
+	require.NoError(t, cmd.Parse(ctx, args))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	output := strings.Trim(bufOut.String(), "\n")
+	if output == "" {
+		return nil
+	}
+	return strings.Split(output, "\n")
+}
+
+func TestLocateFullPathGlob(t *testing.T) {
+	lines := runLocate(t, "subdir/**/*.txt")
+	require.Len(t, lines, 2)
+	require.True(t, strings.HasSuffix(lines[0], "/subdir/dummy.txt"))
+	require.True(t, strings.HasSuffix(lines[1], "/subdir/foo.txt"))
+
+	// absolute patterns are anchored at the root
+	require.Len(t, runLocate(t, "/subdir/*.txt"), 2)
+	require.Empty(t, runLocate(t, "/dummy.txt"))
+	require.Len(t, runLocate(t, "/**/another_subdir/*"), 1)
+}
+
+func TestLocateRegexAndCase(t *testing.T) {
+	lines := runLocate(t, "-regex", `_subdir/b.r\.txt$`)
+	require.Len(t, lines, 1)
+	require.True(t, strings.HasSuffix(lines[0], "/another_subdir/bar.txt"))
+
+	require.Empty(t, runLocate(t, "FOO.TXT"))
+	require.Len(t, runLocate(t, "-i", "FOO.TXT"), 1)
+}
+
+func TestLocatePrintsOncePerPath(t *testing.T) {
+	require.Len(t, runLocate(t, "foo.txt", "*.txt", "f*"), 3)
+}
+
+func TestLocateFilters(t *testing.T) {
+	// "hello foo" is 9 bytes, "hello dummy" 11
+	lines := runLocate(t, "-size", "-10", "*.txt")
+	require.Len(t, lines, 2)
+
+	lines = runLocate(t, "-type", "d", "*subdir")
+	require.Len(t, lines, 2)
+
+	// filters alone select every matching entry
+	lines = runLocate(t, "-size", "+10")
+	require.Len(t, lines, 2)
+	require.True(t, strings.HasSuffix(lines[0], "/subdir/dummy.txt") || strings.HasSuffix(lines[1], "/subdir/dummy.txt"))
+}
+
+func TestLocateParseErrors(t *testing.T) {
+	_, _, ctx := generateSnapshot(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+
+	require.Error(t, (&Locate{}).Parse(ctx, []string{"-size", "12X"}))
+	require.Error(t, (&Locate{}).Parse(ctx, []string{"-type", "x"}))
+	require.Error(t, (&Locate{}).Parse(ctx, []string{"-format", "xml"}))
+}
+
+func TestLocateJSON(t *testing.T) {
+	lines := runLocate(t, "-format", "json", "foo.txt")
+	require.Len(t, lines, 1)
+
+	var m Match
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &m))
+	require.True(t, strings.HasSuffix(m.Path, "/subdir/foo.txt"))
+	require.Equal(t, "f", m.Type)
+	require.Equal(t, int64(9), m.Size)
+	require.Len(t, m.Snapshot, 64)
+	require.False(t, m.SnapshotTimestamp.IsZero())
+}
+
+func TestLocateLatestOnly(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap1, ctx := generateSnapshot(t, bufOut, bytes.NewBuffer(nil))
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "hello again foo"),
+	})
+	defer snap2.Close()
+
+	cmd := &Locate{}
+	require.NoError(t, cmd.Parse(ctx, []string{"foo.txt"}))
+	_, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.Trim(bufOut.String(), "\n"), "\n"), 2)
+
+	bufOut.Reset()
+	cmd = &Locate{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-latest-only", "-format", "json", "foo.txt"}))
+	_, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.Trim(bufOut.String(), "\n"), "\n")
+	require.Len(t, lines, 1)
+	var m Match
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &m))
+	require.Equal(t, hex.EncodeToString(snap2.Header.Identifier[:]), m.Snapshot)
+	require.Equal(t, int64(15), m.Size)
+}
+
+func TestLocateWithPathIndex(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap, ctx := generateSnapshot(t, bufOut, bytes.NewBuffer(nil))
+	defer snap.Close()
+
+	run := func(args ...string) string {
+		bufOut.Reset()
+		cmd := &Locate{}
+		require.NoError(t, cmd.Parse(ctx, args))
+		status, err := cmd.Execute(ctx, repo)
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+		// the index and the snapshot may not walk paths in the same order
+		lines := strings.Split(bufOut.String(), "\n")
+		slices.Sort(lines)
+		return strings.Join(lines, "\n")
+	}
+
+	queries := [][]string{
+		{"*.txt"},
+		{"subdir/**"},
+		{"-type", "d"},
+		{"-format", "json", "-latest-only", "foo.txt"},
+	}
+	var want []string
+	for _, args := range queries {
+		want = append(want, run(args...))
+	}
+
+	index := pathindex.New(ctx.CacheDir, repo.Configuration().RepositoryID)
+	_, err := index.Update(ctx, repo)
+	require.NoError(t, err)
+
+	for i, args := range queries {
+		require.Equal(t, want[i], run(args...), args)
+	}
+}
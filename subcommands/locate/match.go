@@ -0,0 +1,147 @@
+package locate
+
+import (
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// matcher is a compiled pattern.  Patterns without a slash are matched
+// against the base name, like they always were, the others against the
+// full path.
+type matcher struct {
+	re       *regexp.Regexp
+	basename bool
+}
+
+func (m *matcher) match(pathname string) bool {
+	if m.basename {
+		return m.re.MatchString(path.Base(pathname))
+	}
+	return m.re.MatchString(pathname)
+}
+
+func compilePattern(pattern string, isRegex, fold bool) (*matcher, error) {
+	var flags string
+	if fold {
+		flags = "(?i)"
+	}
+
+	if isRegex {
+		re, err := regexp.Compile(flags + pattern)
+		if err != nil {
+			return nil, err
+		}
+		return &matcher{re: re}, nil
+	}
+
+	basename := !strings.Contains(pattern, "/")
+	if !basename && !strings.HasPrefix(pattern, "/") {
+		// a relative path can match at any depth
+		pattern = "**/" + pattern
+	}
+
+	expr, err := globToRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(flags + "^" + expr + "$")
+	if err != nil {
+		return nil, err
+	}
+	return &matcher{re: re, basename: basename}, nil
+}
+
+// globToRegexp translates a shell glob to a regular expression.  On top of
+// what path.Match understands, "**" matches across slashes and "**/"
+// matches any number of directories, none included.
+func globToRegexp(pattern string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return "", path.ErrBadPattern
+			}
+			class := pattern[i+1 : i+1+end]
+			if class == "" || class == "!" || class == "^" {
+				return "", path.ErrBadPattern
+			}
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 == len(pattern) {
+				return "", path.ErrBadPattern
+			}
+			i++
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	return sb.String(), nil
+}
+
+func entryType(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "d"
+	case mode&fs.ModeSymlink != 0:
+		return "l"
+	case mode.IsRegular():
+		return "f"
+	default:
+		return "o"
+	}
+}
+
+// filters are the conditions on the entry itself, not its name.
+type filters struct {
+	size  *utils.SizeFilter
+	newer time.Time
+	older time.Time
+	types string
+}
+
+func (f *filters) empty() bool {
+	return f.size == nil && f.newer.IsZero() && f.older.IsZero() && f.types == ""
+}
+
+func (f *filters) match(info fs.FileInfo) bool {
+	if f.types != "" && !strings.Contains(f.types, entryType(info.Mode())) {
+		return false
+	}
+	if f.size != nil && (info.IsDir() || !f.size.Match(info.Size())) {
+		return false
+	}
+	if !f.newer.IsZero() && !info.ModTime().After(f.newer) {
+		return false
+	}
+	if !f.older.IsZero() && !info.ModTime().Before(f.older) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package locate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompilePattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern  string
+		regex    bool
+		fold     bool
+		pathname string
+		match    bool
+	}{
+		{"*.go", false, false, "/src/main.go", true},
+		{"*.go", false, false, "/src/main.c", false},
+		{"main.go", false, false, "/src/main.go", true},
+		{"src/*.go", false, false, "/home/src/main.go", true},
+		{"src/*.go", false, false, "/src/pkg/main.go", false},
+		{"src/**/*.go", false, false, "/src/main.go", true},
+		{"src/**/*.go", false, false, "/src/a/b/main.go", true},
+		{"/src/**", false, false, "/src/a/b", true},
+		{"/src/*.go", false, false, "/home/src/main.go", false},
+		{"[!a-m]*.go", false, false, "/src/zeta.go", true},
+		{"[!a-m]*.go", false, false, "/src/beta.go", false},
+		{"file?.txt", false, false, "/file1.txt", true},
+		{`\*.txt`, false, false, "/*.txt", true},
+		{"README", false, true, "/readme", true},
+		{"README", false, false, "/readme", false},
+		{`/src/.*\.go$`, true, false, "/src/a/main.go", true},
+		{`^/src/.*\.GO$`, true, true, "/src/a/main.go", true},
+	} {
+		m, err := compilePattern(tc.pattern, tc.regex, tc.fold)
+		require.NoError(t, err, tc.pattern)
+		require.Equal(t, tc.match, m.match(tc.pathname), "%s ~ %s", tc.pattern, tc.pathname)
+	}
+}
+
+func TestCompilePatternErrors(t *testing.T) {
+	for _, pattern := range []string{"[invalid", "[]", `foo\`} {
+		_, err := compilePattern(pattern, false, false)
+		require.Error(t, err, pattern)
+	}
+	_, err := compilePattern("(", true, false)
+	require.Error(t, err)
+}
@@ -0,0 +1,21 @@
+package lock
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRegisteredFactory looks the commands up through the registry, which
+// invokes the factory closures registered in init().
+func TestRegisteredFactory(t *testing.T) {
+	cmd, _, _ := subcommands.Lookup([]string{"lock", "list"})
+	require.IsType(t, &LockList{}, cmd)
+
+	cmd, _, _ = subcommands.Lookup([]string{"lock", "break"})
+	require.IsType(t, &LockBreak{}, cmd)
+
+	cmd, _, _ = subcommands.Lookup([]string{"lock"})
+	require.IsType(t, &Lock{}, cmd)
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package lock
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/lock"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &LockList{} }, 0, "lock", "list")
+	subcommands.Register(func() subcommands.Subcommand { return &LockBreak{} }, 0, "lock", "break")
+	subcommands.Register(func() subcommands.Subcommand { return &Lock{} }, 0, "lock")
+}
+
+type Lock struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *Lock) CobraCommand() *cobra.Command {
+	return &cobra.Command{
+		Use: "lock",
+	}
+}
+
+func (cmd *Lock) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) > 0 {
+		return fmt.Errorf("invalid argument: %s", rest[0])
+	}
+	return fmt.Errorf("no action specified")
+}
+
+func (cmd *Lock) Execute(ctx *appcontext.AppContext, _ *repository.Repository) (int, error) {
+	return 1, fmt.Errorf("no action specified")
+}
+
+type LockList struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *LockList) CobraCommand() *cobra.Command {
+	return &cobra.Command{
+		Use: "lock list",
+	}
+}
+
+func (cmd *LockList) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+func (cmd *LockList) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	locks, err := lock.List(repo)
+	if err != nil {
+		return 1, fmt.Errorf("lock: %w", err)
+	}
+
+	now := time.Now()
+	for _, info := range locks {
+		pid := "-"
+		if info.PID != 0 {
+			pid = fmt.Sprint(info.PID)
+		}
+
+		status := "stale"
+		if !info.Stale {
+			status = "expires in " + info.Expires().Sub(now).Round(time.Second).String()
+		}
+
+		fmt.Fprintf(ctx.Stdout, "%x %-9s %s pid %s age %s %s\n", info.ID[:4], info.Type(),
+			utils.SanitizeText(info.Hostname), pid, now.Sub(info.Renewed).Round(time.Second), status)
+	}
+	return 0, nil
+}
+
+type LockBreak struct {
+	subcommands.SubcommandBase
+
+	Force bool
+	Lock  string
+}
+
+func (cmd *LockBreak) CobraCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use: "lock break [-force] ID",
+	}
+	c.Flags().BoolVar(&cmd.Force, "force", false, "break the lock even if it is live")
+	return c
+}
+
+func (cmd *LockBreak) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) != 1 {
+		return fmt.Errorf("exactly one lock is required")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Lock = strings.ToLower(rest[0])
+
+	return nil
+}
+
+func (cmd *LockBreak) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	ids, err := repo.GetLocks()
+	if err != nil {
+		return 1, fmt.Errorf("lock: %w", err)
+	}
+
+	var matches []objects.MAC
+	for _, id := range ids {
+		if strings.HasPrefix(hex.EncodeToString(id[:]), cmd.Lock) {
+			matches = append(matches, id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 1, fmt.Errorf("lock: %s: no such lock", cmd.Lock)
+	case 1:
+	default:
+		return 1, fmt.Errorf("lock: %s: ambiguous lock identifier", cmd.Lock)
+	}
+
+	if err := lock.Break(repo, matches[0], cmd.Force); err != nil {
+		if errors.Is(err, lock.ErrInUse) {
+			return 1, fmt.Errorf("lock: %w, use -force to break it anyway", err)
+		}
+		return 1, fmt.Errorf("lock: %w", err)
+	}
+
+	ctx.GetLogger().Info("lock: broke lock %x", matches[0][:4])
+	return 0, nil
+}
@@ -0,0 +1,78 @@
+package lock
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func installLock(t *testing.T, repo *repository.Repository, hostname string, when time.Time) objects.MAC {
+	t.Helper()
+	id := objects.RandomMAC()
+	l := repository.NewExclusiveLock(hostname)
+	l.Timestamp = when
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, l.SerializeToStream(buf))
+	_, err := repo.PutLock(id, buf)
+	require.NoError(t, err)
+	return id
+}
+
+func TestLockListBreak(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	live := installLock(t, repo, "backup-host[4242]", time.Now())
+	stale := installLock(t, repo, "crashed-host", time.Now().Add(-time.Hour))
+
+	list := &LockList{}
+	require.NoError(t, list.Parse(ctx, nil))
+	status, err := list.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	out := bufOut.String()
+	require.Contains(t, out, hex.EncodeToString(stale[:4])+" exclusive crashed-host pid - age 1h0m0s stale")
+	require.Regexp(t, hex.EncodeToString(live[:4])+` exclusive backup-host pid 4242 age \d+s expires in \S+`, out)
+
+	// a live lock is only broken by force
+	brk := &LockBreak{}
+	require.NoError(t, brk.Parse(ctx, []string{hex.EncodeToString(live[:4])}))
+	_, err = brk.Execute(ctx, repo)
+	require.ErrorContains(t, err, "use -force")
+
+	brk = &LockBreak{}
+	require.NoError(t, brk.Parse(ctx, []string{hex.EncodeToString(stale[:4])}))
+	status, err = brk.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	brk = &LockBreak{}
+	require.NoError(t, brk.Parse(ctx, []string{"-force", hex.EncodeToString(live[:])}))
+	_, err = brk.Execute(ctx, repo)
+	require.NoError(t, err)
+
+	locks, err := repo.GetLocks()
+	require.NoError(t, err)
+	require.Empty(t, locks)
+
+	brk = &LockBreak{}
+	require.NoError(t, brk.Parse(ctx, []string{hex.EncodeToString(live[:4])}))
+	_, err = brk.Execute(ctx, repo)
+	require.ErrorContains(t, err, "no such lock")
+}
+
+func TestLockParse(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	require.Error(t, (&Lock{}).Parse(ctx, nil))
+	require.Error(t, (&LockList{}).Parse(ctx, []string{"extra"}))
+	require.Error(t, (&LockBreak{}).Parse(ctx, nil))
+	require.Error(t, (&LockBreak{}).Parse(ctx, []string{"a", "b"}))
+}
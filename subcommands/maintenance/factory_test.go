@@ -14,3 +14,12 @@ func TestRegisteredFactory(t *testing.T) {
 	require.NotNil(t, cmd)
 	require.IsType(t, &Maintenance{}, cmd)
 }
+
+func TestRegisteredSubcommands(t *testing.T) {
+	cmd, _, _ := subcommands.Lookup([]string{"maintenance", "status"})
+	require.IsType(t, &MaintenanceStatus{}, cmd)
+
+	cmd, _, rest := subcommands.Lookup([]string{"maintenance", "uncolour", "abcd"})
+	require.IsType(t, &MaintenanceUncolour{}, cmd)
+	require.Equal(t, []string{"abcd"}, rest)
+}
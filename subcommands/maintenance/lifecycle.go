@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package maintenance
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/PlakarKorp/go-human2duration"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/reclaim"
+	"github.com/dustin/go-humanize"
+)
+
+// Store configuration keys, set with plakar store set.
+const (
+	gracePeriodKey = "maintenance_grace_period"
+	noDeletionKey  = "maintenance_no_deletion"
+)
+
+func parseGracePeriod(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		if d, err = human2duration.ParseDuration(value); err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s is negative", value)
+	}
+	return d, nil
+}
+
+// gracePeriod tells how long coloured packfiles are kept before being
+// swept: the flag if given, else the store configuration, else the
+// PLAKAR_GRACEPERIOD environment variable, else the default.  An invalid
+// flag or store setting is an error, an invalid environment variable is
+// ignored as it always was.
+func gracePeriod(ctx *appcontext.AppContext, flag string) (time.Duration, error) {
+	if flag != "" {
+		d, err := parseGracePeriod(flag)
+		if err != nil {
+			return 0, fmt.Errorf("-grace-period: %w", err)
+		}
+		return d, nil
+	}
+	if value, ok := ctx.StoreConfig[gracePeriodKey]; ok {
+		d, err := parseGracePeriod(value)
+		if err != nil {
+			return 0, fmt.Errorf("store option %s: %w", gracePeriodKey, err)
+		}
+		return d, nil
+	}
+	if d, err := time.ParseDuration(os.Getenv("PLAKAR_GRACEPERIOD")); err == nil {
+		return d, nil
+	}
+	return defaultDuration, nil
+}
+
+// noDeletion tells whether the sweep pass must leave the packfiles in the
+// store, which is the case if the flag, the store configuration or the
+// PLAKAR_NODELETION environment variable says so.
+func noDeletion(ctx *appcontext.AppContext, flag bool) (bool, error) {
+	if flag {
+		return true, nil
+	}
+	if value, ok := ctx.StoreConfig[noDeletionKey]; ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, fmt.Errorf("store option %s: invalid boolean %q", noDeletionKey, value)
+		}
+		if b {
+			return true, nil
+		}
+	}
+	b, _ := strconv.ParseBool(os.Getenv("PLAKAR_NODELETION"))
+	return b, nil
+}
+
+// humanDuration formats a duration in days past a day, 7d or 2d2h0m0s.
+func humanDuration(duration time.Duration) string {
+	if duration.Hours() <= 24 {
+		return duration.String()
+	}
+
+	days := duration / (24 * time.Hour)
+	left := duration - (days * 24 * time.Hour)
+
+	s := fmt.Sprintf("%dd", days)
+	if left != 0 {
+		s += left.String()
+	}
+	return s
+}
+
+// estimatedBytes prints the size of the packfiles of an estimate, along
+// with how many of them the state can't tell the size of.
+func estimatedBytes(est reclaim.Estimate) string {
+	if est.Unknown == 0 {
+		return humanize.IBytes(uint64(est.Bytes))
+	}
+	return fmt.Sprintf("%s, %d packfiles of unknown size", humanize.IBytes(uint64(est.Bytes)), est.Unknown)
+}
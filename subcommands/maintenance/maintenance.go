@@ -17,19 +17,25 @@
 package maintenance
 
 import (
-	"bytes"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"strconv"
 	"time"
 
+	"github.com/PlakarKorp/go-human2duration"
 	"github.com/PlakarKorp/kloset/caching"
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/hold"
+	"github.com/PlakarKorp/plakar/lock"
+	"github.com/PlakarKorp/plakar/reclaim"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/trash"
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
 )
@@ -37,13 +43,22 @@ import (
 const defaultDuration = 7 * 24 * time.Hour
 
 func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &MaintenanceStatus{} }, 0, "maintenance", "status")
+	subcommands.Register(func() subcommands.Subcommand { return &MaintenanceUncolour{} }, 0, "maintenance", "uncolour")
 	subcommands.Register(func() subcommands.Subcommand { return &Maintenance{} }, 0, "maintenance")
 }
 
 func (cmd *Maintenance) CobraCommand() *cobra.Command {
-	return &cobra.Command{
-		Use: "maintenance",
+	c := &cobra.Command{
+		Use: "maintenance [-dry-run] [-grace-period DURATION] [-no-deletion] [-repack [-threshold PERCENT]] [-trash-retention DURATION]",
 	}
+	c.Flags().BoolVar(&cmd.DryRun, "dry-run", false, "report what would be coloured and swept without changing the repository")
+	c.Flags().StringVar(&cmd.GracePeriod, "grace-period", "", "keep coloured packfiles this long before sweeping them, e.g. 7d")
+	c.Flags().BoolVar(&cmd.NoDeletion, "no-deletion", false, "sweep packfiles from the state but leave them in the store")
+	c.Flags().StringVar(&cmd.TrashRetention, "trash-retention", "", "keep deleted snapshots recoverable for at least this long, e.g. 30d")
+	c.Flags().BoolVar(&cmd.Repack, "repack", false, "rewrite the live blobs of under-used packfiles into new ones")
+	c.Flags().StringVar(&cmd.Threshold, "threshold", fmt.Sprintf("%d%%", defaultRepackThreshold), "repack the packfiles whose live data is below this share")
+	return c
 }
 
 func (cmd *Maintenance) Parse(ctx *appcontext.AppContext, args []string) error {
@@ -51,6 +66,33 @@ func (cmd *Maintenance) Parse(ctx *appcontext.AppContext, args []string) error {
 		return err
 	}
 
+	if cmd.TrashRetention != "" {
+		retention, err := human2duration.ParseDuration(cmd.TrashRetention)
+		if err != nil {
+			return fmt.Errorf("-trash-retention: %w", err)
+		}
+		if retention < 0 {
+			return fmt.Errorf("-trash-retention: %s is negative", cmd.TrashRetention)
+		}
+		cmd.trashRetention = retention
+	}
+
+	var err error
+	if cmd.gracePeriod, err = gracePeriod(ctx, cmd.GracePeriod); err != nil {
+		return err
+	}
+	if cmd.noDeletion, err = noDeletion(ctx, cmd.NoDeletion); err != nil {
+		return err
+	}
+
+	if cmd.Repack {
+		threshold, err := parseThreshold(cmd.Threshold)
+		if err != nil {
+			return fmt.Errorf("-threshold: %w", err)
+		}
+		cmd.repackThreshold = threshold
+	}
+
 	cmd.RepositorySecret = ctx.GetSecret()
 
 	return nil
@@ -59,13 +101,34 @@ func (cmd *Maintenance) Parse(ctx *appcontext.AppContext, args []string) error {
 type Maintenance struct {
 	subcommands.SubcommandBase
 
-	repository    *repository.Repository
-	maintenanceID objects.MAC
-	cutoff        time.Time
+	DryRun         bool
+	GracePeriod    string
+	NoDeletion     bool
+	TrashRetention string
+	Repack         bool
+	Threshold      string
+
+	repository      *repository.Repository
+	maintenanceID   objects.MAC
+	cutoff          time.Time
+	trashRetention  time.Duration
+	gracePeriod     time.Duration
+	noDeletion      bool
+	repackThreshold int
+	staleSnapshots  []objects.MAC
 }
 
 // Builds the local cache of snapshot -> packfiles
 func (cmd *Maintenance) updateCache(ctx *appcontext.AppContext, cache *caching.MaintenanceCache) error {
+	holds, err := hold.Load(cmd.repository)
+	if err != nil {
+		return err
+	}
+	held := make(map[objects.MAC]struct{})
+	for _, snapshotID := range holds.Held(time.Now()) {
+		held[snapshotID] = struct{}{}
+	}
+
 	wg := new(errgroup.Group)
 	wg.SetLimit(ctx.MaxConcurrency)
 
@@ -74,42 +137,7 @@ func (cmd *Maintenance) updateCache(ctx *appcontext.AppContext, cache *caching.M
 			return err
 		}
 		wg.Go(func() error {
-			snapshot, err := snapshot.Load(cmd.repository, snapshotID)
-			if err != nil {
-				return err
-			}
-			defer snapshot.Close()
-
-			ok, err := cache.HasSnapshot(snapshotID)
-			if err != nil {
-				return err
-			}
-
-			if ok {
-				return nil
-			}
-
-			iter, err := snapshot.ListPackfiles()
-			if err != nil {
-				return err
-			}
-
-			for packfile, err := range iter {
-				if err != nil {
-					return err
-				}
-
-				if err := ctx.Err(); err != nil {
-					return err
-				}
-
-				if err := cache.PutPackfile(snapshotID, packfile); err != nil {
-					return err
-				}
-			}
-
-			cache.PutSnapshot(snapshotID, nil)
-			return nil
+			return cmd.cacheSnapshot(ctx, cache, snapshotID)
 		})
 	}
 
@@ -117,9 +145,38 @@ func (cmd *Maintenance) updateCache(ctx *appcontext.AppContext, cache *caching.M
 		return err
 	}
 
+	bin, err := trash.Load(cmd.repository)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	deleted := make(map[objects.MAC]struct{})
+
 	// While ListSnapshots doesn't return deleted snapshots, we still need to
 	// go over them to remove previously added one to our local cache.
+	// Snapshots on hold are the exception: they may have been deleted by
+	// someone unaware of the hold, their packfiles must stay.  So are the
+	// snapshots deleted less than the trash retention ago, which must remain
+	// recoverable.
 	for snapshotID := range cmd.repository.ListDeletedSnapShots() {
+		deleted[snapshotID] = struct{}{}
+		deletedAt := bin.Deleted(snapshotID, now)
+
+		if _, ok := held[snapshotID]; ok {
+			if err := cmd.cacheSnapshot(ctx, cache, snapshotID); err != nil {
+				return fmt.Errorf("snapshot %x is on hold: %w", snapshotID[:4], err)
+			}
+			continue
+		}
+
+		if now.Sub(deletedAt) < cmd.trashRetention {
+			// the deletion may only be new to us, if the snapshot can't be
+			// loaded it was swept already and there is nothing to retain
+			if err := cmd.cacheSnapshot(ctx, cache, snapshotID); err == nil {
+				continue
+			}
+		}
+
 		ok, err := cache.HasSnapshot(snapshotID)
 		if err != nil {
 			return err
@@ -133,6 +190,52 @@ func (cmd *Maintenance) updateCache(ctx *appcontext.AppContext, cache *caching.M
 		cache.DeleteSnapshot(snapshotID)
 	}
 
+	bin.Keep(func(snapshotID objects.MAC) bool {
+		_, ok := deleted[snapshotID]
+		return ok
+	})
+	if cmd.DryRun {
+		return nil
+	}
+	return bin.Save()
+}
+
+func (cmd *Maintenance) cacheSnapshot(ctx *appcontext.AppContext, cache *caching.MaintenanceCache, snapshotID objects.MAC) error {
+	snapshot, err := snapshot.Load(cmd.repository, snapshotID)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	ok, err := cache.HasSnapshot(snapshotID)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		return nil
+	}
+
+	iter, err := snapshot.ListPackfiles()
+	if err != nil {
+		return err
+	}
+
+	for packfile, err := range iter {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := cache.PutPackfile(snapshotID, packfile); err != nil {
+			return err
+		}
+	}
+
+	cache.PutSnapshot(snapshotID, nil)
 	return nil
 }
 
@@ -197,6 +300,7 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 	repoWriter := cmd.repository.NewRepositoryWriter(sc, stateID, repository.DefaultType, "")
 
 	coloredPackfiles := 0
+	var toColour []objects.MAC
 	for packfile := range packfiles {
 		if cache.HasPackfile(packfile) {
 			continue
@@ -209,33 +313,30 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 
 		if !has {
 			coloredPackfiles++
+			if cmd.DryRun {
+				toColour = append(toColour, packfile)
+				continue
+			}
 			if err := repoWriter.DeleteStateResource(resources.RT_PACKFILE, packfile); err != nil {
 				return err
 			}
 		}
 	}
 
-	fmt.Fprintf(ctx.Stdout, "maintenance: Coloured %d packfiles (%d orphaned) for deletion\n", coloredPackfiles, orphanedPackfiles)
-
-	if coloredPackfiles > 0 {
-		duration, err := time.ParseDuration(os.Getenv("PLAKAR_GRACEPERIOD"))
+	if cmd.DryRun {
+		est, err := reclaim.Size(ctx, cmd.repository, toColour)
 		if err != nil {
-			duration = defaultDuration
+			return err
 		}
+		fmt.Fprintf(ctx.Stdout, "maintenance: Would colour %d packfiles (%d orphaned) for deletion, %s\n",
+			coloredPackfiles, orphanedPackfiles, estimatedBytes(est))
+		return nil
+	}
 
-		humanDuration := duration.String()
-		if duration.Hours() > 24 {
-			days := duration / (24 * time.Hour)
-			left := duration - (days * 24 * time.Hour)
-
-			humanDuration = fmt.Sprintf("%dd", days)
-
-			if left != 0 {
-				humanDuration += left.String()
-			}
-		}
+	fmt.Fprintf(ctx.Stdout, "maintenance: Coloured %d packfiles (%d orphaned) for deletion\n", coloredPackfiles, orphanedPackfiles)
 
-		fmt.Fprintf(ctx.Stdout, "Coloured packfiles are scheduled to be removed in %s\n", humanDuration)
+	if coloredPackfiles > 0 {
+		fmt.Fprintf(ctx.Stdout, "Coloured packfiles are scheduled to be removed in %s\n", humanDuration(cmd.gracePeriod))
 
 		if err := repoWriter.CommitTransaction(stateID); err != nil {
 			return err
@@ -246,8 +347,6 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 }
 
 func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.MaintenanceCache) error {
-	noDeletion, _ := strconv.ParseBool(os.Getenv("PLAKAR_NODELETION"))
-
 	stateID := objects.RandomMAC()
 	sc, err := cmd.repository.AppContext().GetCache().Scan(stateID)
 	if err != nil {
@@ -267,11 +366,19 @@ func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.Mai
 		// because we could have had a concurrent backup with the coloring
 		// phase.
 		if cache.HasPackfile(packfileMAC) {
+			if cmd.DryRun {
+				continue
+			}
 			fmt.Fprintf(ctx.Stderr, "maintenance: Concurrent backup used %x, uncolouring the packfile.\n", packfileMAC)
 			repoWriter.UncolourPackfile(packfileMAC)
 			continue
 		}
 
+		if cmd.DryRun {
+			toDelete[packfileMAC] = struct{}{}
+			continue
+		}
+
 		// First thing we remove the packfile entry from our state, this means
 		// that now effectively all of its blob are unreachable
 		if err := repoWriter.RemovePackfile(packfileMAC); err != nil {
@@ -283,6 +390,15 @@ func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.Mai
 		toDelete[packfileMAC] = struct{}{}
 	}
 
+	if cmd.DryRun {
+		est, err := reclaim.Size(ctx, cmd.repository, slices.Collect(maps.Keys(toDelete)))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(ctx.Stdout, "maintenance: Would sweep %d packfiles, %s\n", len(toDelete), estimatedBytes(est))
+		return nil
+	}
+
 	// Second garbage collect dangling blobs in our state.
 	// Note: This is not the blobs from the packfile we just removed, since we
 	// do not operate on our local state, it's the responsability of the
@@ -311,7 +427,7 @@ func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.Mai
 		}
 	}
 
-	if !noDeletion {
+	if !cmd.noDeletion {
 		for packfileMAC := range toDelete {
 			if err := cmd.repository.DeletePackfile(packfileMAC); err != nil {
 				fmt.Fprintf(ctx.Stderr, "maintenance: Sweep pass failed to delete packfile %x, skipping it\n", packfileMAC)
@@ -336,20 +452,19 @@ func (cmd *Maintenance) Execute(ctx *appcontext.AppContext, repo *repository.Rep
 
 	cmd.repository = repo
 
-	// This need to be configurable per repo, but we don't have a mechanism yet (comes in a PR soon!)
-	duration, err := time.ParseDuration(os.Getenv("PLAKAR_GRACEPERIOD"))
-	if err != nil {
-		duration = defaultDuration
-	}
-
-	cmd.cutoff = time.Now().Add(-duration)
+	cmd.cutoff = time.Now().Add(-cmd.gracePeriod)
 
 	cmd.maintenanceID = objects.RandomMAC()
-	done, err := cmd.Lock()
-	if err != nil {
-		return 1, err
+
+	// a dry run writes nothing to the repository, it doesn't need to keep
+	// backups away
+	if !cmd.DryRun {
+		done, err := cmd.Lock()
+		if err != nil {
+			return 1, err
+		}
+		defer cmd.Unlock(done)
 	}
-	defer cmd.Unlock(done)
 
 	cache, err := repo.AppContext().GetCache().Maintenance(repo.Configuration().RepositoryID)
 	if err != nil {
@@ -367,106 +482,32 @@ func (cmd *Maintenance) Execute(ctx *appcontext.AppContext, repo *repository.Rep
 		return 1, err
 	}
 
+	if cmd.Repack {
+		if err := cmd.repackPass(ctx, cache); err != nil {
+			fmt.Fprintf(ctx.Stderr, "maintenance: Repack pass failed %s\n", err)
+			return 1, err
+		}
+	}
+
 	if err := cmd.sweepPass(ctx, cache); err != nil {
 		fmt.Fprintf(ctx.Stderr, "maintenance: Sweep pass failed %s\n", err)
 		return 1, err
 	}
+	cmd.dropStaleSnapshots(cache)
 
 	return 0, nil
 }
 
-func (cmd *Maintenance) Lock() (chan bool, error) {
+func (cmd *Maintenance) Lock() (*lock.Lease, error) {
 	lockless, _ := strconv.ParseBool(os.Getenv("PLAKAR_LOCKLESS"))
-	lockDone := make(chan bool)
 	if lockless {
-		return lockDone, nil
+		return nil, nil
 	}
 
 	cmd.repository.NoStateToLocalDisk = true
-	lock := repository.NewExclusiveLock(cmd.repository.AppContext().Hostname)
-
-	buffer := &bytes.Buffer{}
-	err := lock.SerializeToStream(buffer)
-	if err != nil {
-		return nil, err
-	}
-
-	_, err = cmd.repository.PutLock(cmd.maintenanceID, buffer)
-	if err != nil {
-		return nil, err
-	}
-
-	// We installed the lock, now let's see if there is a conflicting exclusive lock or not.
-	locksID, err := cmd.repository.GetLocks()
-	if err != nil {
-		// We still need to delete it, and we need to do so manually.
-		cmd.repository.DeleteLock(cmd.maintenanceID)
-		return nil, err
-	}
-
-	for _, lockID := range locksID {
-		if lockID == cmd.maintenanceID {
-			continue
-		}
-
-		rd, err := cmd.repository.GetLock(lockID)
-		if err != nil {
-			cmd.repository.DeleteLock(cmd.maintenanceID)
-			return nil, err
-		}
-
-		lock, err := repository.NewLockFromStream(rd)
-		rd.Close()
-		if err != nil {
-			cmd.repository.DeleteLock(cmd.maintenanceID)
-			return nil, err
-		}
-
-		/* Kick out stale locks */
-		if lock.IsStale() {
-			err := cmd.repository.DeleteLock(lockID)
-			if err != nil {
-				cmd.repository.DeleteLock(cmd.maintenanceID)
-				return nil, err
-			}
-
-			continue
-		}
-
-		// There is a lock in place, we need to abort.
-		err = cmd.repository.DeleteLock(cmd.maintenanceID)
-		if err != nil {
-			return nil, err
-		}
-
-		return nil, fmt.Errorf("can't take exclusive lock, repository is already locked")
-	}
-
-	// The following bit is a "ping" mechanism, Lock() is a bit badly named at this point,
-	// we are just refreshing the existing lock so that the watchdog doesn't removes us.
-	go func() {
-		for {
-			select {
-			case <-lockDone:
-				cmd.repository.DeleteLock(cmd.maintenanceID)
-				return
-			case <-time.After(repository.LOCK_REFRESH_RATE):
-				lock := repository.NewExclusiveLock(cmd.repository.AppContext().Hostname)
-
-				buffer := &bytes.Buffer{}
-
-				// We ignore errors here on purpose, it's tough to handle them
-				// correctly, and if they happen we will be ripped by the
-				// watchdog anyway.
-				lock.SerializeToStream(buffer)
-				cmd.repository.PutLock(cmd.maintenanceID, buffer)
-			}
-		}
-	}()
-
-	return lockDone, nil
+	return lock.Acquire(cmd.repository, cmd.maintenanceID)
 }
 
-func (cmd *Maintenance) Unlock(ping chan bool) {
-	close(ping)
+func (cmd *Maintenance) Unlock(lease *lock.Lease) {
+	lease.Release()
 }
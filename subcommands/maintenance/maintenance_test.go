@@ -340,6 +340,68 @@ func TestColourPassMarksUnreferencedPackfiles(t *testing.T) {
 	require.Equal(t, storeBefore, storePackfiles(t, repo), "store contents must be unchanged within grace period")
 }
 
+func TestTrashRetentionDefersColouring(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap1 := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap1"))
+	ptesting.GenerateSnapshot(t, repo, extraFiles("only-in-two"), ptesting.WithName("snap2"))
+
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, snap1.Header.GetIndexID())
+
+	bufOut.Reset()
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-trash-retention", "30d"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// the snapshot was deleted moments ago, it must remain recoverable
+	require.Contains(t, bufOut.String(), "Coloured 0 packfiles (0 orphaned)")
+
+	cmd = &Maintenance{}
+	require.Error(t, cmd.Parse(ctx, []string{"-trash-retention", "soon"}))
+}
+
+func TestDryRunColoursNothing(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap1 := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap1"))
+	ptesting.GenerateSnapshot(t, repo, extraFiles("only-in-two"), ptesting.WithName("snap2"))
+
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, snap1.Header.GetIndexID())
+
+	bufOut.Reset()
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-dry-run"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Regexp(t, `maintenance: Would colour [1-9]\d* packfiles \(0 orphaned\) for deletion, [1-9][\d.]* \S*B`, bufOut.String())
+	require.Contains(t, bufOut.String(), "maintenance: Would sweep 0 packfiles, 0 B")
+
+	require.NoError(t, repo.RebuildState())
+	require.Empty(t, colouredPackfiles(t, repo), "a dry run must not colour anything")
+}
+
+func TestDryRunLeavesTrash(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap1 := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap1"))
+	ptesting.GenerateSnapshot(t, repo, extraFiles("only-in-two"), ptesting.WithName("snap2"))
+
+	// deleted behind the trash's back: a real run would record it
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, snap1.Header.GetIndexID())
+	before, err := repo.ListMetadata("trash")
+	require.NoError(t, err)
+
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-dry-run"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	after, err := repo.ListMetadata("trash")
+	require.NoError(t, err)
+	require.ElementsMatch(t, before, after, "a dry run must not write the trash")
+}
+
 func TestColourPassDetectsOrphanInsideGrace(t *testing.T) {
 	// Default 7d grace. Synthetic orphan with a recent footer timestamp is
 	// inside the grace window and must NOT be reported as orphaned (and not
@@ -878,3 +940,92 @@ func TestNoStrayMessagesWhenNothingToDo(t *testing.T) {
 	require.NotContains(t, errOut, "Concurrent backup",
 		"no concurrent-backup warning when nothing was coloured")
 }
+
+func TestParseRepackThreshold(t *testing.T) {
+	_, ctx, _, _ := freshRepo(t)
+
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repack"}))
+	require.Equal(t, defaultRepackThreshold, cmd.repackThreshold)
+
+	cmd = &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repack", "-threshold", "30%"}))
+	require.Equal(t, 30, cmd.repackThreshold)
+
+	for _, threshold := range []string{"0%", "101%", "half"} {
+		cmd = &Maintenance{}
+		require.Error(t, cmd.Parse(ctx, []string{"-repack", "-threshold", threshold}), threshold)
+	}
+}
+
+func TestRepackLeavesFullPackfiles(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap1"))
+	ptesting.GenerateSnapshot(t, repo, extraFiles("two"), ptesting.WithName("snap2"))
+
+	status, err, _, _ := runMaintenance(t, ctx, repo, bufOut, bufErr)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// every blob of every packfile is referenced, there is nothing to gain
+	bufOut.Reset()
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repack", "-dry-run"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "maintenance: Would repack 0 packfiles below 50% usage, rewriting 0 B to reclaim 0 B")
+
+	storeBefore := storePackfiles(t, repo)
+	bufOut.Reset()
+	cmd = &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repack", "-threshold", "1%"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "maintenance: Repacked 0 packfiles")
+	require.Equal(t, storeBefore, storePackfiles(t, repo))
+}
+
+func TestRepackedSnapshots(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap1 := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap1"))
+	snap2 := ptesting.GenerateSnapshot(t, repo, extraFiles("two"), ptesting.WithName("snap2"))
+	id1, id2 := snap1.Header.Identifier, snap2.Header.Identifier
+	snap1.Close()
+	snap2.Close()
+
+	status, err, _, _ := runMaintenance(t, ctx, repo, bufOut, bufErr)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	cache, err := repo.AppContext().GetCache().Maintenance(repo.Configuration().RepositoryID)
+	require.NoError(t, err)
+
+	packfiles := func(snapshotID objects.MAC) map[objects.MAC]struct{} {
+		set := make(map[objects.MAC]struct{})
+		for packfile, err := range cache.GetPackfiles(snapshotID) {
+			require.NoError(t, err)
+			set[packfile] = struct{}{}
+		}
+		return set
+	}
+	of1, of2 := packfiles(id1), packfiles(id2)
+	require.NotEmpty(t, of2)
+
+	// nothing repacked, nothing to forget
+	stale, err := repackedSnapshots(cache, []objects.MAC{id1, id2}, nil)
+	require.NoError(t, err)
+	require.Empty(t, stale)
+
+	// only the snapshots relying on a repacked packfile are forgotten
+	for packfile := range of2 {
+		stale, err := repackedSnapshots(cache, []objects.MAC{id1, id2}, []repackCandidate{{packfile: packfile}})
+		require.NoError(t, err)
+		if _, shared := of1[packfile]; shared {
+			require.ElementsMatch(t, []objects.MAC{id1, id2}, stale)
+		} else {
+			require.Equal(t, []objects.MAC{id2}, stale)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package maintenance
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/caching"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/scrub"
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sync/errgroup"
+)
+
+const defaultRepackThreshold = 50
+
+// parseThreshold reads a percentage such as 50%, the sign being optional.
+func parseThreshold(value string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid percentage %q", value)
+	}
+	if n <= 0 || n > 100 {
+		return 0, fmt.Errorf("%d%% is not between 1%% and 100%%", n)
+	}
+	return n, nil
+}
+
+// keptSnapshots returns the snapshots whose packfiles the cache keeps: the
+// live ones, along with the deleted ones on hold or still in the trash.
+func (cmd *Maintenance) keptSnapshots(cache *caching.MaintenanceCache) ([]objects.MAC, error) {
+	var kept []objects.MAC
+	for snapshotID, err := range cmd.repository.ListSnapshots() {
+		if err != nil {
+			return nil, err
+		}
+		kept = append(kept, snapshotID)
+	}
+	for snapshotID := range cmd.repository.ListDeletedSnapShots() {
+		ok, err := cache.HasSnapshot(snapshotID)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			kept = append(kept, snapshotID)
+		}
+	}
+	return kept, nil
+}
+
+// liveBlobs collects the blobs the kept snapshots reference.
+func (cmd *Maintenance) liveBlobs(ctx *appcontext.AppContext, snapshots []objects.MAC) (map[scrub.Key]struct{}, error) {
+	var mu sync.Mutex
+	live := make(map[scrub.Key]struct{})
+
+	wg := new(errgroup.Group)
+	wg.SetLimit(max(ctx.MaxConcurrency, 1))
+	for _, snapshotID := range snapshots {
+		wg.Go(func() error {
+			snap, err := snapshot.Load(cmd.repository, snapshotID)
+			if err != nil {
+				return fmt.Errorf("snapshot %x: %w", snapshotID[:4], err)
+			}
+			defer snap.Close()
+
+			iter, err := snap.ListBlobs()
+			if err != nil {
+				return err
+			}
+			for blob, err := range iter {
+				if err != nil {
+					return err
+				}
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				mu.Lock()
+				live[scrub.Key{Type: blob.Type, MAC: blob.MAC}] = struct{}{}
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+	return live, nil
+}
+
+// repackCandidate is a packfile less used than the threshold and the live
+// blobs to move out of it.
+type repackCandidate struct {
+	packfile  objects.MAC
+	size      int64
+	liveBytes int64
+	live      []scrub.Blob
+}
+
+// repackPass rewrites the live blobs of the packfiles whose share of live
+// bytes is below the threshold into new packfiles, commits a state that
+// points at the new locations and colours the old packfiles, which the
+// sweep pass then deletes once the grace period is over.
+//
+// Only the packfiles the kept snapshots reference are considered, the
+// others are the business of the colour pass.  Their usage comes from the
+// state, only the live blobs of the repacked ones are fetched.
+func (cmd *Maintenance) repackPass(ctx *appcontext.AppContext, cache *caching.MaintenanceCache) error {
+	snapshots, err := cmd.keptSnapshots(cache)
+	if err != nil {
+		return err
+	}
+	live, err := cmd.liveBlobs(ctx, snapshots)
+	if err != nil {
+		return err
+	}
+
+	packfiles := make(map[objects.MAC]struct{})
+	for packfile := range cmd.repository.ListPackfiles() {
+		if !cache.HasPackfile(packfile) {
+			continue
+		}
+		coloured, err := cmd.repository.HasDeletedPackfile(packfile)
+		if err != nil {
+			return err
+		}
+		if !coloured {
+			packfiles[packfile] = struct{}{}
+		}
+	}
+
+	located, err := cmd.locatedBlobs(ctx, packfiles)
+	if err != nil {
+		return err
+	}
+
+	var candidates []repackCandidate
+	var moved, freed int64
+	for packfile, blobs := range located {
+		c := repackCandidate{packfile: packfile}
+		for _, blob := range blobs {
+			c.size += int64(blob.Location.Length)
+			if _, ok := live[blob.Key()]; ok {
+				c.live = append(c.live, blob)
+				c.liveBytes += int64(blob.Location.Length)
+			}
+		}
+		if c.size == 0 || c.liveBytes*100 >= c.size*int64(cmd.repackThreshold) {
+			continue
+		}
+		candidates = append(candidates, c)
+		moved += c.liveBytes
+		freed += c.size - c.liveBytes
+	}
+
+	if cmd.DryRun {
+		fmt.Fprintf(ctx.Stdout, "maintenance: Would repack %d packfiles below %d%% usage, rewriting %s to reclaim %s\n",
+			len(candidates), cmd.repackThreshold, humanize.IBytes(uint64(moved)), humanize.IBytes(uint64(freed)))
+		return nil
+	}
+
+	if len(candidates) == 0 {
+		fmt.Fprintf(ctx.Stdout, "maintenance: Repacked 0 packfiles\n")
+		return nil
+	}
+
+	stateID := objects.RandomMAC()
+	sc, err := cmd.repository.AppContext().GetCache().Scan(stateID)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+	repoWriter := cmd.repository.NewRepositoryWriter(sc, stateID, repository.DefaultType, "")
+
+	for _, c := range candidates {
+		for _, blob := range c.live {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			data, err := readPackfileBlob(cmd.repository, blob)
+			if err != nil {
+				return fmt.Errorf("could not read %s %x from packfile %x: %w", blob.Type, blob.MAC[:4], c.packfile, err)
+			}
+			if err := repoWriter.PutBlob(blob.Type, blob.MAC, data); err != nil {
+				return fmt.Errorf("could not rewrite %s %x: %w", blob.Type, blob.MAC[:4], err)
+			}
+			if err := repoWriter.RemoveBlob(blob.Type, blob.MAC, c.packfile); err != nil {
+				return fmt.Errorf("could not drop %s %x from packfile %x: %w", blob.Type, blob.MAC[:4], c.packfile, err)
+			}
+		}
+		if err := repoWriter.DeleteStateResource(resources.RT_PACKFILE, c.packfile); err != nil {
+			return err
+		}
+	}
+
+	repoWriter.PackerManager.Wait()
+	if err := repoWriter.CommitTransaction(stateID); err != nil {
+		return err
+	}
+
+	// The cache still ties the snapshots to the old packfiles, which would
+	// have the sweep pass uncolour them.  What it knows of the snapshots
+	// relying on a repacked packfile is dropped once the sweep pass is done
+	// with it, the next run resolves them again.
+	stale, err := repackedSnapshots(cache, snapshots, candidates)
+	if err != nil {
+		return err
+	}
+	cmd.staleSnapshots = stale
+
+	fmt.Fprintf(ctx.Stdout, "maintenance: Repacked %d packfiles below %d%% usage, rewrote %s, %s to be reclaimed\n",
+		len(candidates), cmd.repackThreshold, humanize.IBytes(uint64(moved)), humanize.IBytes(uint64(freed)))
+	return nil
+}
+
+// locatedBlobs returns the blobs the state places in each of the given
+// packfiles, which sizes them without fetching any, as reclaim.Sizes
+// does.
+func (cmd *Maintenance) locatedBlobs(ctx *appcontext.AppContext, packfiles map[objects.MAC]struct{}) (map[objects.MAC][]scrub.Blob, error) {
+	located := make(map[objects.MAC][]scrub.Blob)
+	if len(packfiles) == 0 {
+		return located, nil
+	}
+	for _, Type := range resources.Types() {
+		for entry, err := range cmd.repository.ListObjectsOfType(Type) {
+			if err != nil {
+				return nil, err
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if _, ok := packfiles[entry.Location.Packfile]; ok {
+				located[entry.Location.Packfile] = append(located[entry.Location.Packfile],
+					scrub.Blob{Type: Type, MAC: entry.Blob, Location: entry.Location})
+			}
+		}
+	}
+	return located, nil
+}
+
+func readPackfileBlob(repo *repository.Repository, blob scrub.Blob) ([]byte, error) {
+	rd, err := repo.GetPackfileBlob(blob.Location)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	if err := scrub.Validate(repo, blob.Type, blob.MAC, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// repackedSnapshots returns the snapshots the cache ties to one of the
+// repacked packfiles.
+func repackedSnapshots(cache *caching.MaintenanceCache, snapshots []objects.MAC, candidates []repackCandidate) ([]objects.MAC, error) {
+	repacked := make(map[objects.MAC]struct{}, len(candidates))
+	for _, c := range candidates {
+		repacked[c.packfile] = struct{}{}
+	}
+
+	var stale []objects.MAC
+	for _, snapshotID := range snapshots {
+		for packfile, err := range cache.GetPackfiles(snapshotID) {
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := repacked[packfile]; ok {
+				stale = append(stale, snapshotID)
+				break
+			}
+		}
+	}
+	return stale, nil
+}
+
+// dropStaleSnapshots forgets what the cache knows of the snapshots whose
+// packfiles were repacked.
+func (cmd *Maintenance) dropStaleSnapshots(cache *caching.MaintenanceCache) {
+	for _, snapshotID := range cmd.staleSnapshots {
+		cache.DeleletePackfiles(snapshotID)
+		cache.DeleteSnapshot(snapshotID)
+	}
+}
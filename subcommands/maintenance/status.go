@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package maintenance
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/reclaim"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+// colouredPackfile is a packfile the colour pass selected for deletion.
+type colouredPackfile struct {
+	mac      objects.MAC
+	coloured time.Time
+}
+
+func listColoured(repo *repository.Repository) []colouredPackfile {
+	var coloured []colouredPackfile
+	for packfileMAC, colouredAt := range repo.ListColouredPackfiles() {
+		coloured = append(coloured, colouredPackfile{mac: packfileMAC, coloured: colouredAt})
+	}
+	slices.SortFunc(coloured, func(a, b colouredPackfile) int {
+		if c := a.coloured.Compare(b.coloured); c != 0 {
+			return c
+		}
+		return slices.Compare(a.mac[:], b.mac[:])
+	})
+	return coloured
+}
+
+type MaintenanceStatus struct {
+	subcommands.SubcommandBase
+
+	GracePeriod string
+
+	gracePeriod time.Duration
+}
+
+func (cmd *MaintenanceStatus) CobraCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use: "maintenance status [-grace-period DURATION]",
+	}
+	c.Flags().StringVar(&cmd.GracePeriod, "grace-period", "", "compute the scheduled deletions with this grace period, e.g. 7d")
+	return c
+}
+
+func (cmd *MaintenanceStatus) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	if cmd.gracePeriod, err = gracePeriod(ctx, cmd.GracePeriod); err != nil {
+		return err
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+func (cmd *MaintenanceStatus) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	coloured := listColoured(repo)
+
+	macs := make([]objects.MAC, 0, len(coloured))
+	for _, p := range coloured {
+		macs = append(macs, p.mac)
+	}
+	sizes, err := reclaim.Sizes(ctx, repo, macs)
+	if err != nil {
+		return 1, fmt.Errorf("maintenance: %w", err)
+	}
+
+	// a packfile the state has no blob in, such as one left by an aborted
+	// backup, is listed without its size
+	now := time.Now()
+	var bytes int64
+	unknown := 0
+	for _, p := range coloured {
+		size := "size unknown"
+		if n, ok := sizes[p.mac]; ok {
+			size = humanize.IBytes(uint64(n))
+			bytes += n
+		} else {
+			unknown++
+		}
+
+		scheduled := p.coloured.Add(cmd.gracePeriod)
+		when := scheduled.UTC().Format(time.RFC3339)
+		if !scheduled.After(now) {
+			when = "due"
+		}
+		fmt.Fprintf(ctx.Stdout, "%x coloured %s, %s, deletion %s\n", p.mac, p.coloured.UTC().Format(time.RFC3339), size, when)
+	}
+
+	summary := fmt.Sprintf("maintenance: %d coloured packfiles, %s pending deletion", len(coloured), humanize.IBytes(uint64(bytes)))
+	if unknown != 0 {
+		summary += fmt.Sprintf(", %d of unknown size", unknown)
+	}
+	fmt.Fprintln(ctx.Stdout, summary)
+	return 0, nil
+}
+
+type MaintenanceUncolour struct {
+	subcommands.SubcommandBase
+
+	Packfile string
+}
+
+func (cmd *MaintenanceUncolour) CobraCommand() *cobra.Command {
+	return &cobra.Command{
+		Use: "maintenance uncolour PACKFILE",
+	}
+}
+
+func (cmd *MaintenanceUncolour) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) != 1 {
+		return fmt.Errorf("exactly one packfile is required")
+	}
+	prefix := strings.ToLower(rest[0])
+	if _, err := hex.DecodeString(prefix + strings.Repeat("0", len(prefix)%2)); err != nil || prefix == "" {
+		return fmt.Errorf("invalid packfile %q", rest[0])
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Packfile = prefix
+
+	return nil
+}
+
+// lookup resolves the packfile among the coloured ones, by a prefix of its
+// MAC as status prints it.
+func (cmd *MaintenanceUncolour) lookup(repo *repository.Repository) (objects.MAC, error) {
+	var found []objects.MAC
+	for _, p := range listColoured(repo) {
+		if strings.HasPrefix(hex.EncodeToString(p.mac[:]), cmd.Packfile) {
+			found = append(found, p.mac)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return objects.MAC{}, fmt.Errorf("%s: no coloured packfile", cmd.Packfile)
+	case 1:
+		return found[0], nil
+	default:
+		return objects.MAC{}, fmt.Errorf("%s: ambiguous, %d coloured packfiles match", cmd.Packfile, len(found))
+	}
+}
+
+func (cmd *MaintenanceUncolour) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	// a maintenance run in progress could be sweeping the very packfile
+	m := &Maintenance{repository: repo, maintenanceID: objects.RandomMAC()}
+	done, err := m.Lock()
+	if err != nil {
+		return 1, fmt.Errorf("maintenance: %w", err)
+	}
+	defer m.Unlock(done)
+
+	packfileMAC, err := cmd.lookup(repo)
+	if err != nil {
+		return 1, fmt.Errorf("maintenance: %w", err)
+	}
+
+	stateID := objects.RandomMAC()
+	sc, err := repo.AppContext().GetCache().Scan(stateID)
+	if err != nil {
+		return 1, fmt.Errorf("maintenance: %w", err)
+	}
+	defer sc.Close()
+	repoWriter := repo.NewRepositoryWriter(sc, stateID, repository.DefaultType, "")
+
+	repoWriter.UncolourPackfile(packfileMAC)
+	if err := repoWriter.CommitTransaction(stateID); err != nil {
+		return 1, fmt.Errorf("maintenance: %w", err)
+	}
+
+	ctx.GetLogger().Info("maintenance: packfile %x uncoloured", packfileMAC)
+	return 0, nil
+}
@@ -0,0 +1,124 @@
+package maintenance
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGracePeriodFlagAndStoreOption(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap1 := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap1"))
+	ptesting.GenerateSnapshot(t, repo, extraFiles("p"), ptesting.WithName("snap2"))
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, snap1.Header.GetIndexID())
+
+	// the store option wins over the environment, the flag over both
+	t.Setenv("PLAKAR_GRACEPERIOD", "72h")
+	ctx.StoreConfig = map[string]string{gracePeriodKey: "48h"}
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, nil))
+	require.Equal(t, "2d", humanDuration(cmd.gracePeriod))
+
+	cmd = &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-grace-period", "10d"}))
+	require.Equal(t, "10d", humanDuration(cmd.gracePeriod))
+
+	bufOut.Reset()
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "scheduled to be removed in 10d")
+
+	require.Error(t, (&Maintenance{}).Parse(ctx, []string{"-grace-period", "soon"}))
+	_, err = parseGracePeriod("-1h")
+	require.Error(t, err)
+
+	ctx.StoreConfig = map[string]string{gracePeriodKey: "soon"}
+	require.Error(t, (&Maintenance{}).Parse(ctx, nil))
+}
+
+func TestNoDeletionStoreOption(t *testing.T) {
+	_, ctx, _, _ := freshRepo(t)
+
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, nil))
+	require.False(t, cmd.noDeletion)
+
+	cmd = &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-deletion"}))
+	require.True(t, cmd.noDeletion)
+
+	ctx.StoreConfig = map[string]string{noDeletionKey: "true"}
+	cmd = &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, nil))
+	require.True(t, cmd.noDeletion)
+
+	ctx.StoreConfig = map[string]string{noDeletionKey: "maybe"}
+	require.Error(t, (&Maintenance{}).Parse(ctx, nil))
+}
+
+func TestStatusAndUncolour(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap1 := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap1"))
+	ptesting.GenerateSnapshot(t, repo, extraFiles("p"), ptesting.WithName("snap2"))
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, snap1.Header.GetIndexID())
+
+	status := &MaintenanceStatus{}
+	require.NoError(t, status.Parse(ctx, nil))
+	bufOut.Reset()
+	_, err := status.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Contains(t, bufOut.String(), "maintenance: 0 coloured packfiles, 0 B pending deletion")
+
+	colourRunAndRebuild(t, ctx, repo, bufOut, bufErr)
+	coloured := colouredPackfiles(t, repo)
+	require.NotEmpty(t, coloured)
+
+	bufOut.Reset()
+	_, err = status.Execute(ctx, repo)
+	require.NoError(t, err)
+	for mac := range coloured {
+		require.Regexp(t, hex.EncodeToString(mac[:])+` coloured \S+, ([\d.]+ \S*B|size unknown), deletion `, bufOut.String())
+	}
+	require.Regexp(t, `maintenance: [1-9]\d* coloured packfiles, [\d.]+ \S*B pending deletion`, bufOut.String())
+
+	// with no grace period, every coloured packfile is due
+	status = &MaintenanceStatus{}
+	require.NoError(t, status.Parse(ctx, []string{"-grace-period", "0s"}))
+	bufOut.Reset()
+	_, err = status.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Contains(t, bufOut.String(), ", deletion due\n")
+
+	var target objects.MAC
+	for mac := range coloured {
+		target = mac
+		break
+	}
+	uncolour := &MaintenanceUncolour{}
+	require.NoError(t, uncolour.Parse(ctx, []string{hex.EncodeToString(target[:])}))
+	rc, err := uncolour.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, rc)
+
+	require.NoError(t, repo.RebuildState())
+	require.NotContains(t, colouredPackfiles(t, repo), target)
+	require.Len(t, colouredPackfiles(t, repo), len(coloured)-1)
+
+	// the packfile is no longer coloured, there is nothing to uncolour
+	uncolour = &MaintenanceUncolour{}
+	require.NoError(t, uncolour.Parse(ctx, []string{hex.EncodeToString(target[:])}))
+	_, err = uncolour.Execute(ctx, repo)
+	require.ErrorContains(t, err, "no coloured packfile")
+}
+
+func TestUncolourParse(t *testing.T) {
+	_, ctx, _, _ := freshRepo(t)
+	require.Error(t, (&MaintenanceUncolour{}).Parse(ctx, nil))
+	require.Error(t, (&MaintenanceUncolour{}).Parse(ctx, []string{"a", "b"}))
+	require.Error(t, (&MaintenanceUncolour{}).Parse(ctx, []string{"xyz"}))
+	require.NoError(t, (&MaintenanceUncolour{}).Parse(ctx, []string{"ABC"}))
+}
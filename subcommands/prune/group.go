@@ -0,0 +1,38 @@
+package prune
+
+import (
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+)
+
+// match evaluates the policy.  With a group-by, the periods apply to each
+// group on its own: the snapshots the filters select are split into groups
+// and the periods are evaluated again over each, from the headers already
+// in infos rather than by locating the whole repository once per group.
+// Snapshots whose header could not be loaded belong to no group and keep
+// the decision taken over the whole pool.
+func (cmd *Prune) match(repo *repository.Repository, infos map[objects.MAC]*snapshotInfo, now time.Time) (map[objects.MAC]locate.Reason, error) {
+	_, reasons, err := locate.Match(repo, cmd.LocateOptions)
+	if err != nil {
+		return nil, err
+	}
+	if len(cmd.Retention.GroupBy) == 0 {
+		return reasons, nil
+	}
+
+	candidates := make([]objects.MAC, 0, len(reasons))
+	for id := range reasons {
+		candidates = append(candidates, id)
+	}
+
+	periods := simPeriods(cmd.LocateOptions)
+	for _, snapshots := range byGroup(infos, candidates, cmd.Retention.GroupBy) {
+		for id, r := range simMatch(periods, snapshots, now) {
+			reasons[id] = r
+		}
+	}
+	return reasons, nil
+}
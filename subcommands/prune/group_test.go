@@ -0,0 +1,69 @@
+package prune
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrune_PolicyGroupBy(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	for _, name := range []string{"a", "b", "a", "b"} {
+		snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+			ptesting.NewMockFile("file.txt", 0644, "hello "+name),
+		}, ptesting.WithName(name))
+		snap.Close()
+	}
+
+	dir := t.TempDir()
+	ctx.ConfigDir = dir
+	policyYAML := "version: v1.0.0\n" +
+		"policies:\n  pooled:\n    periods:\n      minute:\n        cap: 1\n" +
+		"  grouped:\n    periods:\n      minute:\n        cap: 1\n" +
+		"retention:\n  grouped:\n    group-by: [name]\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "policies.yml"), []byte(policyYAML), 0644))
+
+	// all four snapshots share one minute bucket
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-policy", "pooled"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "prune: would keep 1 and delete 3 snapshot(s)")
+	require.NotContains(t, bufOut.String(), "group ")
+
+	// one minute bucket per name
+	bufOut.Reset()
+	cmd = &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-policy", "grouped"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	out := bufOut.String()
+	require.Contains(t, out, "prune: would keep 2 and delete 2 snapshot(s)")
+	require.Contains(t, out, "group name=a\n")
+	require.Contains(t, out, "group name=b\n")
+
+	bufOut.Reset()
+	cmd = &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-policy", "grouped", "-plan-out", "-"}))
+	_, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+
+	var plan Plan
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &plan))
+	require.Len(t, plan.Snapshots, 4)
+	kept := map[string]int{}
+	for _, s := range plan.Snapshots {
+		if s.Action == "keep" {
+			kept[s.Group]++
+		}
+	}
+	require.Equal(t, map[string]int{"name=a": 1, "name=b": 1}, kept)
+}
@@ -0,0 +1,191 @@
+package prune
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/hold"
+)
+
+const PLAN_VERSION = "1.0.0"
+
+// Plan is the outcome of a prune, written by -plan-out and carried out as
+// is by -apply-plan.
+type Plan struct {
+	Version    string         `json:"version"`
+	Repository string         `json:"repository"`
+	Timestamp  time.Time      `json:"timestamp"`
+	Snapshots  []PlanSnapshot `json:"snapshots"`
+}
+
+// PlanSnapshot is the decision taken for one snapshot and why.
+type PlanSnapshot struct {
+	Snapshot  string    `json:"snapshot"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Group     string    `json:"group,omitempty"`
+	Rule      string    `json:"rule,omitempty"`
+	Bucket    string    `json:"bucket,omitempty"`
+	Rank      int       `json:"rank"`
+	Cap       int       `json:"cap"`
+	Note      string    `json:"note,omitempty"`
+}
+
+// newPlan records the decisions of the policy.  Snapshots whose header
+// could not be loaded are recorded too, without a timestamp.
+func newPlan(repo *repository.Repository, reasons map[objects.MAC]locate.Reason, entries []planEntry, now time.Time) *Plan {
+	timestamps := make(map[objects.MAC]time.Time, len(entries))
+	groups := make(map[objects.MAC]string, len(entries))
+	for _, e := range entries {
+		timestamps[e.id] = e.ts
+		groups[e.id] = e.group
+	}
+
+	plan := &Plan{
+		Version:    PLAN_VERSION,
+		Repository: repo.Configuration().RepositoryID.String(),
+		Timestamp:  now,
+		Snapshots:  make([]PlanSnapshot, 0, len(reasons)),
+	}
+	for id, r := range reasons {
+		plan.Snapshots = append(plan.Snapshots, PlanSnapshot{
+			Snapshot:  hex.EncodeToString(id[:]),
+			Timestamp: timestamps[id],
+			Action:    r.Action,
+			Group:     groups[id],
+			Rule:      r.Rule,
+			Bucket:    r.Bucket,
+			Rank:      r.Rank,
+			Cap:       r.Cap,
+			Note:      r.Note,
+		})
+	}
+	slices.SortStableFunc(plan.Snapshots, func(a, b PlanSnapshot) int {
+		if c := strings.Compare(a.Group, b.Group); c != 0 {
+			return c
+		}
+		if c := b.Timestamp.Compare(a.Timestamp); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Snapshot, b.Snapshot)
+	})
+	return plan
+}
+
+// toDelete returns the snapshots the plan deletes.
+func (plan *Plan) toDelete() ([]objects.MAC, error) {
+	var ids []objects.MAC
+	for _, s := range plan.Snapshots {
+		if s.Action != "delete" {
+			continue
+		}
+		data, err := hex.DecodeString(s.Snapshot)
+		if err != nil || len(data) != len(objects.MAC{}) {
+			return nil, fmt.Errorf("invalid snapshot identifier %q", s.Snapshot)
+		}
+		ids = append(ids, objects.MAC(data))
+	}
+	return ids, nil
+}
+
+func writePlan(w io.Writer, plan *Plan) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
+
+func (cmd *Prune) savePlan(ctx *appcontext.AppContext, plan *Plan) error {
+	if cmd.PlanOut == "-" {
+		return writePlan(ctx.Stdout, plan)
+	}
+
+	fp, err := os.Create(cmd.PlanOut)
+	if err != nil {
+		return err
+	}
+	if err := writePlan(fp, plan); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+func loadPlan(filename string) (*Plan, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("could not decode plan: %w", err)
+	}
+	if plan.Version != PLAN_VERSION {
+		return nil, fmt.Errorf("unsupported plan version %q", plan.Version)
+	}
+	return &plan, nil
+}
+
+// applyPlan deletes the snapshots the plan marks for deletion.  The plan
+// must have been made for this repository and every one of them must still
+// exist and not be on hold, otherwise nothing is deleted.
+func (cmd *Prune) applyPlan(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	plan, err := loadPlan(cmd.ApplyPlan)
+	if err != nil {
+		return 1, fmt.Errorf("prune: %s: %w", cmd.ApplyPlan, err)
+	}
+	if repoID := repo.Configuration().RepositoryID.String(); plan.Repository != repoID {
+		return 1, fmt.Errorf("prune: %s: plan was made for repository %s, not %s", cmd.ApplyPlan, plan.Repository, repoID)
+	}
+
+	toDelete, err := plan.toDelete()
+	if err != nil {
+		return 1, fmt.Errorf("prune: %s: %w", cmd.ApplyPlan, err)
+	}
+
+	existing := make(map[objects.MAC]struct{})
+	for id, err := range repo.ListSnapshots() {
+		if err != nil {
+			return 1, err
+		}
+		existing[id] = struct{}{}
+	}
+	missing := 0
+	for _, id := range toDelete {
+		if _, ok := existing[id]; !ok {
+			ctx.GetLogger().Error("prune: snapshot %x no longer exists", id[:4])
+			missing++
+		}
+	}
+	if missing != 0 {
+		return 1, fmt.Errorf("prune: %s: %d snapshots of the plan no longer exist, not applying it", cmd.ApplyPlan, missing)
+	}
+
+	holds, err := hold.Load(repo)
+	if err != nil {
+		return 1, fmt.Errorf("prune: %w", err)
+	}
+	held := 0
+	now := time.Now()
+	for _, id := range toDelete {
+		if err := holds.Check(id, now); err != nil {
+			ctx.GetLogger().Error("prune: %x: %s", id[:4], err)
+			held++
+		}
+	}
+	if held != 0 {
+		return 1, fmt.Errorf("prune: %s: %d snapshots of the plan are on hold, not applying it", cmd.ApplyPlan, held)
+	}
+
+	return deleteSnapshots(ctx, repo, toDelete)
+}
@@ -0,0 +1,92 @@
+package prune
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrune_PlanOutThenApplyPlan(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, bufOut, bytes.NewBuffer(nil))
+	defer snap1.Close()
+	defer snap2.Close()
+
+	planFile := filepath.Join(t.TempDir(), "plan.json")
+
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"--per-minute=1", "-plan-out", planFile}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NotContains(t, bufOut.String(), "prune: removal of")
+
+	plan, err := loadPlan(planFile)
+	require.NoError(t, err)
+	require.Equal(t, repo.Configuration().RepositoryID.String(), plan.Repository)
+	require.Len(t, plan.Snapshots, 2)
+
+	toDelete, err := plan.toDelete()
+	require.NoError(t, err)
+	require.Len(t, toDelete, 1)
+	require.Equal(t, snap1.Header.Identifier, toDelete[0])
+
+	cmd = &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-apply-plan", planFile}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	short1 := hex.EncodeToString(snap1.Header.GetIndexShortID())
+	short2 := hex.EncodeToString(snap2.Header.GetIndexShortID())
+	require.Contains(t, bufOut.String(), fmt.Sprintf("info: prune: removal of %s completed successfully", short1))
+	require.NotContains(t, bufOut.String(), fmt.Sprintf("info: prune: removal of %s completed successfully", short2))
+}
+
+func TestPrune_ApplyPlanMissingSnapshot(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, bufOut, bytes.NewBuffer(nil))
+	defer snap1.Close()
+	defer snap2.Close()
+
+	planFile := filepath.Join(t.TempDir(), "plan.json")
+
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"--per-minute=1", "-plan-out", planFile}))
+	_, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+
+	// the snapshot goes away between the review and the application
+	require.NoError(t, repo.DeleteSnapshot(snap1.Header.Identifier))
+	require.NoError(t, repo.RebuildState())
+
+	cmd = &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-apply-plan", planFile}))
+	status, err := cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+	require.Contains(t, err.Error(), "no longer exist")
+}
+
+func TestPrune_ApplyPlanParse(t *testing.T) {
+	_, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, nil, nil)
+	defer snap1.Close()
+	defer snap2.Close()
+
+	cmd := &Prune{}
+	require.Error(t, cmd.Parse(ctx, []string{"-apply-plan", "plan.json", "-apply"}))
+
+	cmd = &Prune{}
+	require.Error(t, cmd.Parse(ctx, []string{"-apply-plan", "plan.json", "--per-minute=1"}))
+
+	cmd = &Prune{}
+	require.Error(t, cmd.Parse(ctx, []string{"-plan-out", "plan.json", "-apply", "--per-minute=1"}))
+
+	// a plan needs no filter, it carries its own selection
+	cmd = &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-apply-plan", "plan.json"}))
+}
@@ -25,12 +25,16 @@ import (
 	"sync"
 	"time"
 
+	"github.com/PlakarKorp/go-human2duration"
 	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/hold"
+	"github.com/PlakarKorp/plakar/reclaim"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/trash"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
@@ -41,10 +45,24 @@ type Prune struct {
 
 	LocateOptions *locate.LocateOptions
 
-	Apply bool
+	Retention utils.RetentionRules
 
-	policyName     string
-	policyOverride *locate.LocateOptions
+	Apply     bool
+	Force     bool
+	PlanOut   string
+	ApplyPlan string
+
+	Simulate     bool
+	SimulateDays int
+	Interval     string
+	Replay       bool
+
+	policyName        string
+	policyOverride    *locate.LocateOptions
+	retentionOverride utils.RetentionRules
+	keepWithin        string
+	keepTagged        string
+	interval          time.Duration
 }
 
 func init() {
@@ -60,6 +78,17 @@ func (cmd *Prune) CobraCommand() *cobra.Command {
 	}
 	c.Flags().BoolVar(&cmd.Apply, "apply", false, "do the actual removal")
 	c.Flags().StringVar(&cmd.policyName, "policy", "", "policy to use")
+	c.Flags().StringVar(&cmd.PlanOut, "plan-out", "", "write the plan as json to this file, - for stdout, instead of printing it")
+	c.Flags().StringVar(&cmd.ApplyPlan, "apply-plan", "", "delete exactly the snapshots a plan written by -plan-out marks for deletion")
+	c.Flags().BoolVar(&cmd.Force, "force", false, "allow deleting the newest snapshot of an origin and root")
+	c.Flags().StringVar(&cmd.keepWithin, "keep-within", "", "keep the snapshots taken within this duration, e.g. 30d")
+	c.Flags().IntVar(&cmd.retentionOverride.KeepLast, "keep-last", 0, "keep the last `N` snapshots of each origin and root")
+	c.Flags().StringVar(&cmd.keepTagged, "keep-tagged", "", "keep the snapshots with one of these comma-separated tags")
+	c.Flags().IntVar(&cmd.retentionOverride.MinKeep, "min-keep", 0, "never leave fewer than `N` snapshots of an origin and root")
+	c.Flags().BoolVar(&cmd.Simulate, "simulate", false, "simulate the policy over time instead of pruning")
+	c.Flags().IntVar(&cmd.SimulateDays, "simulate-days", 365, "number of `days` to simulate")
+	c.Flags().StringVar(&cmd.Interval, "interval", "1d", "time between two simulated snapshots")
+	c.Flags().BoolVar(&cmd.Replay, "replay", false, "simulate over the snapshots of the repository instead of synthesized ones")
 	subcommands.InstallGoFlags(c.Flags(), cmd.policyOverride.InstallLocateFlags)
 	return c
 }
@@ -70,6 +99,53 @@ func (cmd *Prune) Parse(ctx *appcontext.AppContext, args []string) error {
 		return err
 	}
 
+	if cmd.keepWithin != "" {
+		d, err := utils.Duration(cmd.keepWithin).Duration()
+		if err != nil {
+			return fmt.Errorf("-keep-within: %w", err)
+		}
+		if d < 0 {
+			return fmt.Errorf("-keep-within: negative duration")
+		}
+		cmd.retentionOverride.KeepWithin = utils.Duration(cmd.keepWithin)
+	}
+	if cmd.keepTagged != "" {
+		cmd.retentionOverride.KeepTagged = strings.Split(cmd.keepTagged, ",")
+	}
+	if cmd.retentionOverride.KeepLast < 0 || cmd.retentionOverride.MinKeep < 0 {
+		return fmt.Errorf("-keep-last and -min-keep cannot be negative")
+	}
+
+	if cmd.Simulate {
+		if cmd.Apply || cmd.PlanOut != "" || cmd.ApplyPlan != "" || len(rest) != 0 {
+			return fmt.Errorf("-simulate cannot be combined with -apply, -plan-out, -apply-plan or snapshots")
+		}
+		if cmd.SimulateDays <= 0 {
+			return fmt.Errorf("-simulate-days must be positive")
+		}
+		cmd.interval, err = human2duration.ParseDuration(cmd.Interval)
+		if err != nil {
+			return fmt.Errorf("-interval: %w", err)
+		}
+		if cmd.interval < time.Minute {
+			return fmt.Errorf("-interval: %s is shorter than a minute", cmd.Interval)
+		}
+	} else if cmd.Replay {
+		return fmt.Errorf("-replay requires -simulate")
+	}
+
+	if cmd.ApplyPlan != "" {
+		if cmd.Apply || cmd.Force || cmd.PlanOut != "" || cmd.policyName != "" || len(rest) != 0 ||
+			!cmd.policyOverride.Empty() || !cmd.retentionOverride.Empty() {
+			return fmt.Errorf("-apply-plan cannot be combined with other options")
+		}
+		cmd.RepositorySecret = ctx.GetSecret()
+		return nil
+	}
+	if cmd.Apply && cmd.PlanOut != "" {
+		return fmt.Errorf("-plan-out and -apply are mutually exclusive")
+	}
+
 	if cmd.policyName != "" {
 		configFile := filepath.Join(ctx.ConfigDir, "policies.yml")
 		cfg, err := utils.LoadPolicyConfigFile(configFile)
@@ -80,10 +156,12 @@ func (cmd *Prune) Parse(ctx *appcontext.AppContext, args []string) error {
 			return fmt.Errorf("policy %q not found", cmd.policyName)
 		}
 		cfg.ApplyConfig(cmd.policyName, cmd.LocateOptions)
+		cfg.ApplyRetention(cmd.policyName, &cmd.Retention)
 	}
 	mergePolicyOptions(cmd.LocateOptions, cmd.policyOverride)
+	mergeRetention(&cmd.Retention, &cmd.retentionOverride)
 
-	if len(rest) == 0 && cmd.LocateOptions.Empty() {
+	if len(rest) == 0 && cmd.LocateOptions.Empty() && cmd.Retention.Empty() {
 		return fmt.Errorf("no filter specified, not going to prune everything")
 	}
 
@@ -180,17 +258,49 @@ type planEntry struct {
 	id     objects.MAC
 	key    string
 	ts     time.Time
+	group  string
 
 	reason locate.Reason
 	action string // "keep" or "delete"
 }
 
 func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	_, reasons, err := locate.Match(repo, cmd.LocateOptions)
+	if cmd.ApplyPlan != "" {
+		return cmd.applyPlan(ctx, repo)
+	}
+	if cmd.Simulate {
+		return cmd.simulate(ctx, repo)
+	}
+
+	infos, err := loadSnapshots(repo)
 	if err != nil {
 		return 1, err
 	}
 
+	now := time.Now()
+	reasons, err := cmd.match(repo, infos, now)
+	if err != nil {
+		return 1, err
+	}
+
+	if err := cmd.applyRetention(infos, reasons, now); err != nil {
+		return 1, fmt.Errorf("prune: %w", err)
+	}
+
+	// whatever the policy says, snapshots on hold are kept
+	holds, err := hold.Load(repo)
+	if err != nil {
+		return 1, fmt.Errorf("prune: %w", err)
+	}
+	for id, r := range reasons {
+		if r.Action != "delete" {
+			continue
+		}
+		if err := holds.Check(id, now); err != nil {
+			reasons[id] = locate.Reason{Action: "keep", Note: err.Error()}
+		}
+	}
+
 	toDelete := make([]objects.MAC, 0, len(reasons))
 	entries := make([]planEntry, 0, len(reasons))
 
@@ -218,6 +328,9 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 			tags)
 		snap.Close()
 		entry := planEntry{prefix: prefix, id: id, key: r.Bucket, ts: snap.Header.Timestamp}
+		if info, ok := infos[id]; ok && len(cmd.Retention.GroupBy) != 0 {
+			entry.group = info.group(cmd.Retention.GroupBy)
+		}
 
 		r, ok := reasons[id]
 		// Default to "skip" if we couldn't evaluate (e.g., missing timestamp)
@@ -230,9 +343,23 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		entries = append(entries, entry)
 	}
 
+	if cmd.PlanOut != "" {
+		if err := cmd.savePlan(ctx, newPlan(repo, reasons, entries, time.Now())); err != nil {
+			return 1, fmt.Errorf("prune: could not write plan: %w", err)
+		}
+		if cmd.PlanOut != "-" {
+			ctx.GetLogger().Info("prune: plan to keep %d and delete %d snapshot(s) written to %s, run with -apply-plan %s to proceed",
+				len(reasons)-len(toDelete), len(toDelete), cmd.PlanOut, cmd.PlanOut)
+		}
+		return 0, nil
+	}
+
 	if !cmd.Apply {
-		// Sort newest-first; unknown timestamps (IsZero) go last
+		// Sort by group then newest-first; unknown timestamps (IsZero) go last
 		sort.SliceStable(entries, func(i, j int) bool {
+			if entries[i].group != entries[j].group {
+				return entries[i].group < entries[j].group
+			}
 			ti, tj := entries[i].ts, entries[j].ts
 			if ti.IsZero() && tj.IsZero() {
 				return entries[i].key < entries[j].key // stable tiebreak
@@ -250,7 +377,10 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		for _, e := range entries {
 			l = max(l, len(e.prefix))
 		}
-		for _, e := range entries {
+		for i, e := range entries {
+			if e.group != "" && (i == 0 || entries[i-1].group != e.group) {
+				fmt.Fprintf(ctx.Stdout, "group %s\n", e.group)
+			}
 			for len(e.prefix) < l {
 				e.prefix += " "
 			}
@@ -262,29 +392,59 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 					e.action, e.prefix, r.Rule, r.Bucket, r.Rank, r.Cap)
 			}
 		}
+		if len(toDelete) != 0 {
+			printReclaimable(ctx, repo, toDelete)
+		}
 		return 0, nil
 	}
 
+	return deleteSnapshots(ctx, repo, toDelete)
+}
+
+// printReclaimable tells how much space deleting the snapshots would give
+// back once maintenance runs.  It is only an estimate, failing to compute
+// it does not fail the command.
+func printReclaimable(ctx *appcontext.AppContext, repo *repository.Repository, snapshots []objects.MAC) {
+	est, err := reclaim.Snapshots(ctx, repo, snapshots)
+	if err != nil {
+		ctx.GetLogger().Warn("prune: could not estimate the reclaimable space: %s", err)
+		return
+	}
+	fmt.Fprintf(ctx.Stdout, "prune: maintenance would then reclaim %s\n", est)
+}
+
+func deleteSnapshots(ctx *appcontext.AppContext, repo *repository.Repository, toDelete []objects.MAC) (int, error) {
 	if len(toDelete) == 0 {
 		return 0, nil
 	}
 
 	errors := 0
 	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	var deleted []objects.MAC
 	for _, snap := range toDelete {
 		wg.Add(1)
 		go func(snapshotID objects.MAC) {
 			defer wg.Done()
 			if err := repo.DeleteSnapshot(snapshotID); err != nil {
 				ctx.GetLogger().Error("%s", err)
+				mu.Lock()
 				errors++
+				mu.Unlock()
 				return
 			}
 			ctx.GetLogger().Info("prune: removal of %x completed successfully", snapshotID[:4])
+			mu.Lock()
+			deleted = append(deleted, snapshotID)
+			mu.Unlock()
 		}(snap)
 	}
 	wg.Wait()
 
+	if err := trash.Record(repo, deleted, time.Now()); err != nil {
+		ctx.GetLogger().Warn("prune: could not record the deletion dates: %s", err)
+	}
+
 	if errors != 0 {
 		return 1, fmt.Errorf("failed to remove %d snapshots", errors)
 	}
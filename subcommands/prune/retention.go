@@ -0,0 +1,238 @@
+package prune
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// snapshotInfo is what the retention rules need to know of a snapshot.
+type snapshotInfo struct {
+	id     objects.MAC
+	ts     time.Time
+	name   string
+	tags   []string
+	origin string
+	root   string
+}
+
+// defaultGroupBy is how the rules that count snapshots group them when the
+// policy has no group-by: per origin and root.
+var defaultGroupBy = utils.GroupBy{"origin", "root"}
+
+// group describes the group the snapshot belongs to, as its values for the
+// keys.  The tags are taken as a whole, snapshots share a group only if
+// they carry the same tags.
+func (s *snapshotInfo) group(keys utils.GroupBy) string {
+	if len(keys) == 0 {
+		keys = defaultGroupBy
+	}
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		var value string
+		switch key {
+		case "origin":
+			value = s.origin
+		case "root":
+			value = s.root
+		case "name":
+			value = s.name
+		case "tags":
+			tags := slices.Clone(s.tags)
+			slices.Sort(tags)
+			value = strings.Join(tags, ",")
+		}
+		parts = append(parts, key+"="+value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// loadSnapshots reads the header of every snapshot of the repository.
+// Snapshots whose header can't be loaded are left out.
+func loadSnapshots(repo *repository.Repository) (map[objects.MAC]*snapshotInfo, error) {
+	infos := make(map[objects.MAC]*snapshotInfo)
+	for snapshotID, err := range repo.ListSnapshots() {
+		if err != nil {
+			return nil, err
+		}
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			continue
+		}
+		source := snap.Header.GetSource(0)
+		infos[snapshotID] = &snapshotInfo{
+			id:     snapshotID,
+			ts:     snap.Header.Timestamp,
+			name:   snap.Header.Name,
+			tags:   slices.Clone(snap.Header.Tags),
+			origin: source.Importer.Origin,
+			root:   source.Importer.Directory,
+		}
+		snap.Close()
+	}
+	return infos, nil
+}
+
+// byGroup returns the snapshots per group, newest first.
+func byGroup(infos map[objects.MAC]*snapshotInfo, ids []objects.MAC, keys utils.GroupBy) map[string][]*snapshotInfo {
+	groups := make(map[string][]*snapshotInfo)
+	for _, id := range ids {
+		info, ok := infos[id]
+		if !ok {
+			continue
+		}
+		group := info.group(keys)
+		groups[group] = append(groups[group], info)
+	}
+	for _, group := range groups {
+		slices.SortFunc(group, func(a, b *snapshotInfo) int {
+			if c := b.ts.Compare(a.ts); c != 0 {
+				return c
+			}
+			return slices.Compare(a.id[:], b.id[:])
+		})
+	}
+	return groups
+}
+
+func hasPeriods(opts *locate.LocateOptions) bool {
+	for _, p := range []locate.LocatePeriod{
+		opts.Periods.Minute, opts.Periods.Hour, opts.Periods.Day,
+		opts.Periods.Week, opts.Periods.Month, opts.Periods.Year,
+		opts.Periods.Monday, opts.Periods.Tuesday, opts.Periods.Wednesday,
+		opts.Periods.Thursday, opts.Periods.Friday, opts.Periods.Saturday,
+		opts.Periods.Sunday,
+	} {
+		if p.Keep != 0 || p.Cap != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeRetention layers the retention rules given on the command line onto
+// the ones of the policy, field by field.
+func mergeRetention(to *utils.RetentionRules, from *utils.RetentionRules) {
+	if from.KeepWithin != "" {
+		to.KeepWithin = from.KeepWithin
+	}
+	if from.KeepLast != 0 {
+		to.KeepLast = from.KeepLast
+	}
+	if len(from.KeepTagged) != 0 {
+		to.KeepTagged = from.KeepTagged
+	}
+	if len(from.GroupBy) != 0 {
+		to.GroupBy = from.GroupBy
+	}
+	if from.MinKeep != 0 {
+		to.MinKeep = from.MinKeep
+	}
+}
+
+// applyRetention revisits the decisions of the periods.  The retention
+// rules only ever keep snapshots, except that without periods they decide
+// alone, so what none of them keeps is deleted.  Whatever the rules, the
+// newest snapshot of each origin and root is kept unless forced.
+func (cmd *Prune) applyRetention(infos map[objects.MAC]*snapshotInfo, reasons map[objects.MAC]locate.Reason, now time.Time) error {
+	rules := &cmd.Retention
+	if rules.Empty() && cmd.Force {
+		return nil
+	}
+
+	within, err := rules.KeepWithin.Duration()
+	if err != nil {
+		return fmt.Errorf("keep-within: %w", err)
+	}
+
+	candidates := make([]objects.MAC, 0, len(reasons))
+	for id := range reasons {
+		candidates = append(candidates, id)
+	}
+
+	if !rules.Empty() && !hasPeriods(cmd.LocateOptions) {
+		for _, id := range candidates {
+			reasons[id] = locate.Reason{Action: "delete", Note: "not kept by any retention rule"}
+		}
+	}
+
+	keep := func(id objects.MAC, r locate.Reason) {
+		if reasons[id].Action == "delete" {
+			r.Action = "keep"
+			reasons[id] = r
+		}
+	}
+
+	for _, id := range candidates {
+		info, ok := infos[id]
+		if !ok {
+			continue
+		}
+		if tag, ok := firstTag(info.tags, rules.KeepTagged); ok {
+			keep(id, locate.Reason{Rule: "keep-tagged", Bucket: tag})
+		}
+		if within != 0 && now.Sub(info.ts) < within {
+			keep(id, locate.Reason{Rule: "keep-within", Bucket: string(rules.KeepWithin)})
+		}
+	}
+
+	if rules.KeepLast != 0 {
+		for group, snapshots := range byGroup(infos, candidates, rules.GroupBy) {
+			for i, info := range snapshots[:min(rules.KeepLast, len(snapshots))] {
+				keep(info.id, locate.Reason{Rule: "keep-last", Bucket: group, Rank: i + 1, Cap: rules.KeepLast})
+			}
+		}
+	}
+
+	all := make([]objects.MAC, 0, len(infos))
+	for id := range infos {
+		all = append(all, id)
+	}
+	groups := byGroup(infos, all, rules.GroupBy)
+
+	// the newest backup of every origin and root stays, whatever grouping
+	// the rules count by
+	if !cmd.Force {
+		for group, snapshots := range byGroup(infos, all, defaultGroupBy) {
+			keep(snapshots[0].id, locate.Reason{Note: fmt.Sprintf("newest of %s, use -force to delete it", group)})
+		}
+	}
+
+	if rules.MinKeep != 0 {
+		for group, snapshots := range groups {
+			remaining := 0
+			for _, info := range snapshots {
+				if r, ok := reasons[info.id]; !ok || r.Action != "delete" {
+					remaining++
+				}
+			}
+			for _, info := range snapshots {
+				if remaining >= rules.MinKeep {
+					break
+				}
+				if reasons[info.id].Action == "delete" {
+					keep(info.id, locate.Reason{Rule: "min-keep", Bucket: group, Cap: rules.MinKeep})
+					remaining++
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func firstTag(tags []string, wanted []string) (string, bool) {
+	for _, tag := range wanted {
+		if slices.Contains(tags, tag) {
+			return tag, true
+		}
+	}
+	return "", false
+}
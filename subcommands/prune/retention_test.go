@@ -0,0 +1,144 @@
+package prune
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrune_KeepLast(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, bufOut, bytes.NewBuffer(nil))
+	defer snap1.Close()
+	defer snap2.Close()
+
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-keep-last", "1"}))
+
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "prune: would keep 1 and delete 1 snapshot(s)")
+}
+
+func TestPrune_KeepWithin(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, bufOut, bytes.NewBuffer(nil))
+	defer snap1.Close()
+	defer snap2.Close()
+
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-keep-within", "1d"}))
+
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "prune: would keep 2 and delete 0 snapshot(s)")
+}
+
+func TestPrune_NewestKeptUnlessForced(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, bufOut, bytes.NewBuffer(nil))
+	defer snap1.Close()
+	defer snap2.Close()
+
+	// no snapshot carries the tag, so the rules keep none of them
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-keep-tagged", "nope"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "prune: would keep 1 and delete 1 snapshot(s)")
+	require.Contains(t, bufOut.String(), "use -force to delete it")
+
+	bufOut.Reset()
+	cmd = &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-keep-tagged", "nope", "-force"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "prune: would keep 0 and delete 2 snapshot(s)")
+}
+
+func TestPrune_NewestKeptPerOriginAndRoot(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	for _, name := range []string{"a", "b"} {
+		snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+			ptesting.NewMockFile("file.txt", 0644, "hello "+name),
+		}, ptesting.WithName(name))
+		snap.Close()
+	}
+
+	dir := t.TempDir()
+	ctx.ConfigDir = dir
+	policyYAML := "version: v1.0.0\npolicies:\n  grouped: {}\n" +
+		"retention:\n  grouped:\n    group-by: [name]\n    keep-tagged: [nope]\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "policies.yml"), []byte(policyYAML), 0644))
+
+	// grouping by name doesn't protect the newest of each name, only the
+	// newest of the origin and root both snapshots share
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-policy", "grouped"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "prune: would keep 1 and delete 1 snapshot(s)")
+}
+
+func TestPrune_MinKeep(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, bufOut, bytes.NewBuffer(nil))
+	defer snap1.Close()
+	defer snap2.Close()
+
+	// the minute cap alone would delete the older snapshot
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"--per-minute=1", "-min-keep", "2"}))
+
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "prune: would keep 2 and delete 0 snapshot(s)")
+	require.Contains(t, bufOut.String(), "min-keep")
+}
+
+func TestPrune_RetentionFromPolicy(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, bufOut, bytes.NewBuffer(nil))
+	defer snap1.Close()
+	defer snap2.Close()
+
+	dir := t.TempDir()
+	ctx.ConfigDir = dir
+	policyYAML := "version: v1.0.0\npolicies:\n  last: {}\nretention:\n  last:\n    keep-last: 1\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "policies.yml"), []byte(policyYAML), 0644))
+
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-policy", "last"}))
+
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "prune: would keep 1 and delete 1 snapshot(s)")
+}
+
+func TestPrune_RetentionParse(t *testing.T) {
+	_, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, nil, nil)
+	defer snap1.Close()
+	defer snap2.Close()
+
+	for _, args := range [][]string{
+		{"-keep-within", "soon"},
+		{"-keep-last", "-1"},
+		{"-min-keep", "-2"},
+		{"-apply-plan", "plan.json", "-force"},
+	} {
+		cmd := &Prune{}
+		require.Error(t, cmd.Parse(ctx, args), "%v", args)
+	}
+}
@@ -0,0 +1,297 @@
+package prune
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+)
+
+// simPeriod is a period of the policy as the simulation evaluates it.
+// bucket tells which bucket of the period a date falls in, if any, and
+// back returns a date in the bucket i buckets before the one of now.
+type simPeriod struct {
+	name   string
+	period locate.LocatePeriod
+	bucket func(t time.Time) (string, bool)
+	back   func(now time.Time, i int) time.Time
+}
+
+func fixedPeriod(name string, p locate.LocatePeriod, layout string, unit time.Duration) simPeriod {
+	return simPeriod{
+		name:   name,
+		period: p,
+		bucket: func(t time.Time) (string, bool) { return t.Format(layout), true },
+		back:   func(now time.Time, i int) time.Time { return now.Add(-time.Duration(i) * unit) },
+	}
+}
+
+func weekdayPeriod(name string, p locate.LocatePeriod, day time.Weekday) simPeriod {
+	return simPeriod{
+		name:   name,
+		period: p,
+		bucket: func(t time.Time) (string, bool) { return t.Format("2006-01-02"), t.Weekday() == day },
+		back: func(now time.Time, i int) time.Time {
+			last := now.AddDate(0, 0, -int((now.Weekday()-day+7)%7))
+			return last.AddDate(0, 0, -7*i)
+		},
+	}
+}
+
+func simPeriods(opts *locate.LocateOptions) []simPeriod {
+	p := &opts.Periods
+	return []simPeriod{
+		fixedPeriod("minute", p.Minute, "2006-01-02T15:04", time.Minute),
+		fixedPeriod("hour", p.Hour, "2006-01-02T15", time.Hour),
+		fixedPeriod("day", p.Day, "2006-01-02", 24*time.Hour),
+		{
+			name:   "week",
+			period: p.Week,
+			bucket: func(t time.Time) (string, bool) {
+				year, week := t.ISOWeek()
+				return fmt.Sprintf("%04d-W%02d", year, week), true
+			},
+			back: func(now time.Time, i int) time.Time { return now.AddDate(0, 0, -7*i) },
+		},
+		{
+			name:   "month",
+			period: p.Month,
+			bucket: func(t time.Time) (string, bool) { return t.Format("2006-01"), true },
+			back: func(now time.Time, i int) time.Time {
+				return time.Date(now.Year(), now.Month()-time.Month(i), 1, 0, 0, 0, 0, now.Location())
+			},
+		},
+		{
+			name:   "year",
+			period: p.Year,
+			bucket: func(t time.Time) (string, bool) { return t.Format("2006"), true },
+			back: func(now time.Time, i int) time.Time {
+				return time.Date(now.Year()-i, 1, 1, 0, 0, 0, 0, now.Location())
+			},
+		},
+		weekdayPeriod("monday", p.Monday, time.Monday),
+		weekdayPeriod("tuesday", p.Tuesday, time.Tuesday),
+		weekdayPeriod("wednesday", p.Wednesday, time.Wednesday),
+		weekdayPeriod("thursday", p.Thursday, time.Thursday),
+		weekdayPeriod("friday", p.Friday, time.Friday),
+		weekdayPeriod("saturday", p.Saturday, time.Saturday),
+		weekdayPeriod("sunday", p.Sunday, time.Sunday),
+	}
+}
+
+// simMatch decides on the snapshots the way the periods of a policy do:
+// a period keeps the snapshots of its last Keep buckets, at most Cap per
+// bucket, and a snapshot is kept if any period keeps it.  Without periods,
+// every snapshot is kept.
+func simMatch(periods []simPeriod, snapshots []*snapshotInfo, now time.Time) map[objects.MAC]locate.Reason {
+	reasons := make(map[objects.MAC]locate.Reason, len(snapshots))
+	active := false
+	for _, p := range periods {
+		if p.period.Keep != 0 || p.period.Cap != 0 {
+			active = true
+		}
+	}
+	for _, info := range snapshots {
+		if active {
+			reasons[info.id] = locate.Reason{Action: "delete", Note: "not kept by any period"}
+		} else {
+			reasons[info.id] = locate.Reason{Action: "keep"}
+		}
+	}
+
+	for _, p := range periods {
+		if p.period.Keep == 0 && p.period.Cap == 0 {
+			continue
+		}
+
+		var recent map[string]struct{}
+		if p.period.Keep != 0 {
+			recent = make(map[string]struct{}, p.period.Keep)
+			for i := range p.period.Keep {
+				key, _ := p.bucket(p.back(now, i))
+				recent[key] = struct{}{}
+			}
+		}
+
+		buckets := make(map[string][]*snapshotInfo)
+		for _, info := range snapshots {
+			key, ok := p.bucket(info.ts)
+			if !ok {
+				continue
+			}
+			if _, ok := recent[key]; recent != nil && !ok {
+				continue
+			}
+			buckets[key] = append(buckets[key], info)
+		}
+
+		for key, bucket := range buckets {
+			slices.SortFunc(bucket, func(a, b *snapshotInfo) int { return b.ts.Compare(a.ts) })
+			if p.period.Cap != 0 {
+				bucket = bucket[:min(p.period.Cap, len(bucket))]
+			}
+			for i, info := range bucket {
+				if reasons[info.id].Action != "keep" {
+					reasons[info.id] = locate.Reason{Action: "keep", Rule: p.name, Bucket: key, Rank: i + 1, Cap: p.period.Cap}
+				}
+			}
+		}
+	}
+	return reasons
+}
+
+// simulationHistory returns the snapshots the simulation starts from and
+// adds over time.  Replaying, these are the snapshots of the repository the
+// filters of the policy select.  Otherwise a snapshot is synthesized at
+// every interval from now on.
+func (cmd *Prune) simulationHistory(repo *repository.Repository, now time.Time) ([]*snapshotInfo, error) {
+	if !cmd.Replay {
+		end := now.AddDate(0, 0, cmd.SimulateDays)
+		var history []*snapshotInfo
+		for ts := now; !ts.After(end); ts = ts.Add(cmd.interval) {
+			var id objects.MAC
+			binary.BigEndian.PutUint64(id[:], uint64(len(history)))
+			history = append(history, &snapshotInfo{id: id, ts: ts, origin: "simulation"})
+		}
+		return history, nil
+	}
+
+	infos, err := loadSnapshots(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var none locate.LocateOptions
+	opts := *cmd.LocateOptions
+	opts.Periods = none.Periods
+	opts.GroupBy = locate.GroupByNone
+	_, selected, err := locate.Match(repo, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []*snapshotInfo
+	for id := range selected {
+		if info, ok := infos[id]; ok {
+			history = append(history, info)
+		}
+	}
+	slices.SortFunc(history, func(a, b *snapshotInfo) int { return a.ts.Compare(b.ts) })
+	return history, nil
+}
+
+// ageBuckets are the upper bounds of the age distribution, the last
+// column counts what is older.
+var ageBuckets = []struct {
+	name string
+	age  time.Duration
+}{
+	{"<1d", 24 * time.Hour},
+	{"<1w", 7 * 24 * time.Hour},
+	{"<1m", 30 * 24 * time.Hour},
+	{"<1y", 365 * 24 * time.Hour},
+}
+
+// simulate applies the policy day by day to a timeline of snapshots and
+// reports what it retains.  Nothing is written to the repository.
+func (cmd *Prune) simulate(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	history, err := cmd.simulationHistory(repo, time.Now())
+	if err != nil {
+		return 1, fmt.Errorf("prune: %w", err)
+	}
+	if len(history) == 0 {
+		return 1, fmt.Errorf("prune: no snapshot to replay")
+	}
+
+	start := history[0].ts
+	if cmd.Replay {
+		fmt.Fprintf(ctx.Stdout, "prune: replaying %d snapshot(s) over %d day(s) from %s\n",
+			len(history), cmd.SimulateDays, start.Format("2006-01-02"))
+	} else {
+		fmt.Fprintf(ctx.Stdout, "prune: simulating %d day(s) with a snapshot every %s\n",
+			cmd.SimulateDays, cmd.Interval)
+	}
+
+	fmt.Fprintf(ctx.Stdout, "%5s %-10s %8s", "day", "date", "retained")
+	for _, b := range ageBuckets {
+		fmt.Fprintf(ctx.Stdout, " %5s", b.name)
+	}
+	fmt.Fprintf(ctx.Stdout, " %5s  %s\n", "older", "oldest")
+
+	periods := simPeriods(cmd.LocateOptions)
+	retained := make(map[objects.MAC]*snapshotInfo)
+	next, taken := 0, 0
+	for day := 1; day <= cmd.SimulateDays; day++ {
+		if err := ctx.Err(); err != nil {
+			return 1, err
+		}
+
+		now := start.AddDate(0, 0, day)
+		for ; next < len(history) && !history[next].ts.After(now); next++ {
+			retained[history[next].id] = history[next]
+			taken++
+		}
+
+		snapshots := make([]*snapshotInfo, 0, len(retained))
+		for _, info := range retained {
+			snapshots = append(snapshots, info)
+		}
+
+		var reasons map[objects.MAC]locate.Reason
+		if len(cmd.Retention.GroupBy) == 0 {
+			reasons = simMatch(periods, snapshots, now)
+		} else {
+			reasons = make(map[objects.MAC]locate.Reason, len(snapshots))
+			ids := make([]objects.MAC, 0, len(snapshots))
+			for _, info := range snapshots {
+				ids = append(ids, info.id)
+			}
+			for _, group := range byGroup(retained, ids, cmd.Retention.GroupBy) {
+				for id, r := range simMatch(periods, group, now) {
+					reasons[id] = r
+				}
+			}
+		}
+		if err := cmd.applyRetention(retained, reasons, now); err != nil {
+			return 1, fmt.Errorf("prune: %w", err)
+		}
+
+		for id, r := range reasons {
+			if r.Action == "delete" {
+				delete(retained, id)
+			}
+		}
+
+		counts := make([]int, len(ageBuckets)+1)
+		var oldest time.Time
+		for _, info := range retained {
+			age := now.Sub(info.ts)
+			i := 0
+			for i < len(ageBuckets) && age >= ageBuckets[i].age {
+				i++
+			}
+			counts[i]++
+			if oldest.IsZero() || info.ts.Before(oldest) {
+				oldest = info.ts
+			}
+		}
+
+		fmt.Fprintf(ctx.Stdout, "%5d %-10s %8d", day, now.Format("2006-01-02"), len(retained))
+		for _, n := range counts {
+			fmt.Fprintf(ctx.Stdout, " %5d", n)
+		}
+		if oldest.IsZero() {
+			fmt.Fprintf(ctx.Stdout, "  -\n")
+		} else {
+			fmt.Fprintf(ctx.Stdout, "  %s (%dd)\n", oldest.Format("2006-01-02"), int(now.Sub(oldest)/(24*time.Hour)))
+		}
+	}
+
+	fmt.Fprintf(ctx.Stdout, "prune: %d of %d snapshot(s) retained after %d day(s)\n", len(retained), taken, cmd.SimulateDays)
+	return 0, nil
+}
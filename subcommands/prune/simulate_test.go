@@ -0,0 +1,148 @@
+package prune
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func countSnapshots(t *testing.T, repo *repository.Repository) int {
+	n := 0
+	for _, err := range repo.ListSnapshots() {
+		require.NoError(t, err)
+		n++
+	}
+	return n
+}
+
+func TestPrune_SimulateSynthesized(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, bufOut, bytes.NewBuffer(nil))
+	defer snap1.Close()
+	defer snap2.Close()
+
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-simulate", "-simulate-days", "10", "-interval", "12h", "--per-day=1"}))
+
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// one snapshot per calendar day over eleven days
+	out := bufOut.String()
+	require.Contains(t, out, "prune: simulating 10 day(s) with a snapshot every 12h")
+	require.Contains(t, out, "prune: 11 of 21 snapshot(s) retained after 10 day(s)")
+	require.Equal(t, 2, countSnapshots(t, repo))
+}
+
+func TestPrune_SimulateReplay(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, bufOut, bytes.NewBuffer(nil))
+	defer snap1.Close()
+	defer snap2.Close()
+
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-simulate", "-replay", "-simulate-days", "3", "--per-minute=1"}))
+
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	out := bufOut.String()
+	require.Contains(t, out, "prune: replaying 2 snapshot(s) over 3 day(s)")
+	require.Contains(t, out, "prune: 1 of 2 snapshot(s) retained after 3 day(s)")
+	require.Equal(t, 2, countSnapshots(t, repo))
+}
+
+func TestPrune_SimulateParse(t *testing.T) {
+	_, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, nil, nil)
+	defer snap1.Close()
+	defer snap2.Close()
+
+	for _, args := range [][]string{
+		{"-replay", "--per-day=1"},
+		{"-simulate", "-apply", "--per-day=1"},
+		{"-simulate", "-plan-out", "-", "--per-day=1"},
+		{"-simulate", "-interval", "10s", "--per-day=1"},
+		{"-simulate", "-interval", "often", "--per-day=1"},
+		{"-simulate", "-simulate-days", "0", "--per-day=1"},
+	} {
+		cmd := &Prune{}
+		require.Error(t, cmd.Parse(ctx, args), "%v", args)
+	}
+}
+
+func actions(reasons map[objects.MAC]locate.Reason) map[objects.MAC]string {
+	out := make(map[objects.MAC]string, len(reasons))
+	for id, r := range reasons {
+		out[id] = r.Action
+	}
+	return out
+}
+
+// TestPrune_SimMatchAgreesWithLocate checks that the simulation, and the
+// evaluation of groups, decide like the policy does on a repository, for
+// every period.
+func TestPrune_SimMatchAgreesWithLocate(t *testing.T) {
+	repo, _ := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	// a snapshot every 25 hours covers each weekday and several hours,
+	// the others fall in the same minute, hour, month or year as another
+	now := time.Now()
+	offsets := []time.Duration{20 * time.Second, 3 * time.Minute, 45 * 24 * time.Hour, 100 * 24 * time.Hour, 400 * 24 * time.Hour}
+	for i := range 10 {
+		offsets = append(offsets, time.Duration(i)*25*time.Hour)
+	}
+	for i, offset := range offsets {
+		snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+			ptesting.NewMockFile("file.txt", 0644, fmt.Sprintf("hello %d", i)),
+		}, ptesting.WithTimestamp(now.Add(-offset)))
+		snap.Close()
+	}
+
+	infos, err := loadSnapshots(repo)
+	require.NoError(t, err)
+	require.Len(t, infos, len(offsets))
+	snapshots := make([]*snapshotInfo, 0, len(infos))
+	for _, info := range infos {
+		snapshots = append(snapshots, info)
+	}
+
+	for _, name := range []string{
+		"minute", "hour", "day", "week", "month", "year",
+		"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday",
+	} {
+		for _, period := range []locate.LocatePeriod{{Keep: 2}, {Cap: 1}, {Keep: 3, Cap: 1}} {
+			opts := locate.NewDefaultLocateOptions()
+			p := &opts.Periods
+			fields := map[string]*locate.LocatePeriod{
+				"minute": &p.Minute, "hour": &p.Hour, "day": &p.Day,
+				"week": &p.Week, "month": &p.Month, "year": &p.Year,
+				"monday": &p.Monday, "tuesday": &p.Tuesday, "wednesday": &p.Wednesday,
+				"thursday": &p.Thursday, "friday": &p.Friday, "saturday": &p.Saturday,
+				"sunday": &p.Sunday,
+			}
+			*fields[name] = period
+
+			// the buckets of now may change while locating
+			before := time.Now()
+			_, want, err := locate.Match(repo, opts)
+			require.NoError(t, err)
+			after := time.Now()
+
+			got := actions(simMatch(simPeriods(opts), snapshots, before))
+			if !maps.Equal(got, actions(want)) {
+				got = actions(simMatch(simPeriods(opts), snapshots, after))
+			}
+			require.Equal(t, actions(want), got, "%s keep=%d cap=%d", name, period.Keep, period.Cap)
+		}
+	}
+}
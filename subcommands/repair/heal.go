@@ -0,0 +1,248 @@
+package repair
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/PlakarKorp/kloset/connectors/storage"
+	"github.com/PlakarKorp/kloset/encryption"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/cached"
+	"github.com/PlakarKorp/plakar/scrub"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// peerSecret derives the key of the peer store, from its configuration or
+// by asking for its passphrase.
+func peerSecret(storeConfig map[string]string, serializedConfig []byte) ([]byte, error) {
+	peerStoreConfig, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	if err != nil {
+		return nil, err
+	}
+	if peerStoreConfig.Encryption == nil {
+		return nil, nil
+	}
+
+	var passphrase []byte
+	if pass, ok := storeConfig["passphrase"]; ok {
+		passphrase = []byte(pass)
+	} else if cmd, ok := storeConfig["passphrase_cmd"]; ok {
+		pass, err := utils.GetPassphraseFromCommand(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase from command: %w", err)
+		}
+		passphrase = []byte(pass)
+	} else {
+		for {
+			passphrase, err = utils.GetPassphrase("peer store")
+			if err == nil {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}
+
+	key, err := encryption.DeriveKey(peerStoreConfig.Encryption.KDFParams, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if !encryption.VerifyCanary(peerStoreConfig.Encryption, key) {
+		return nil, fmt.Errorf("invalid passphrase")
+	}
+	return key, nil
+}
+
+func (cmd *Repair) openPeer(ctx *appcontext.AppContext) (*repository.Repository, error) {
+	storeConfig, err := ctx.Config.GetRepository(cmd.From)
+	if err != nil {
+		return nil, fmt.Errorf("peer store: %w", err)
+	}
+
+	peerStore, peerStoreSerializedConfig, err := storage.Open(ctx.GetInner(), storeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not open peer store %s: %w", cmd.From, err)
+	}
+
+	peerCtx := appcontext.NewAppContextFrom(ctx)
+	peerCtx.SetSecret(cmd.peerSecret)
+	peerCtx.StoreConfig = storeConfig
+	peer, err := repository.NewNoRebuild(peerCtx.GetInner(), peerCtx.GetSecret(), peerStore, peerStoreSerializedConfig, true)
+	if err != nil {
+		return nil, fmt.Errorf("could not open peer repository %s: %w", cmd.From, err)
+	}
+
+	if _, err = cached.RebuildStateFromStore(peerCtx, peer.Configuration().RepositoryID, storeConfig, false); err != nil {
+		return nil, fmt.Errorf("failed to rebuild peer repository's state %s: %w", cmd.From, err)
+	}
+	return peer, nil
+}
+
+// damagedBlobs finds the blobs that can no longer be read from where the
+// state places them: in packfiles that are missing, unreadable or hold a
+// corrupt copy.  Blobs for which the repository has an intact copy
+// elsewhere are left out.
+func damagedBlobs(ctx *appcontext.AppContext, repo *repository.Repository) ([]scrub.Blob, error) {
+	stateIDs, err := repo.GetStates()
+	if err != nil {
+		return nil, fmt.Errorf("could not list states: %w", err)
+	}
+	located, failedStates, err := scrub.ReadStates(ctx, repo, stateIDs)
+	if err != nil {
+		return nil, err
+	}
+	for stateID, err := range failedStates {
+		ctx.GetLogger().Warn("repair: could not read state %x: %s", stateID, err)
+	}
+
+	packfiles, err := repo.GetPackfiles()
+	if err != nil {
+		return nil, fmt.Errorf("could not list packfiles: %w", err)
+	}
+	results, err := scrub.VerifyPackfiles(ctx, repo, packfiles, true)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[scrub.Key]scrub.Blob)
+	add := func(blobs []scrub.Blob) {
+		for _, blob := range blobs {
+			candidates[blob.Key()] = blob
+		}
+	}
+
+	inStorage := make(map[objects.MAC]struct{}, len(packfiles))
+	for _, res := range results {
+		inStorage[res.MAC] = struct{}{}
+		if res.Problem == scrub.ProblemUnreadable {
+			add(located[res.MAC])
+		} else {
+			add(res.Damaged)
+		}
+	}
+	for mac := range repo.ListPackfiles() {
+		if _, ok := inStorage[mac]; ok {
+			continue
+		}
+		deleted, err := repo.HasDeletedPackfile(mac)
+		if err != nil {
+			return nil, err
+		}
+		if !deleted {
+			add(located[mac])
+		}
+	}
+
+	var damaged []scrub.Blob
+	for _, blob := range candidates {
+		if data, err := readBlob(repo, blob.Key()); err == nil && scrub.Validate(repo, blob.Type, blob.MAC, data) == nil {
+			continue
+		}
+		damaged = append(damaged, blob)
+	}
+	slices.SortFunc(damaged, func(a, b scrub.Blob) int {
+		return bytes.Compare(a.MAC[:], b.MAC[:])
+	})
+	return damaged, nil
+}
+
+func readBlob(repo *repository.Repository, blob scrub.Key) ([]byte, error) {
+	rd, err := repo.GetBlob(blob.Type, blob.MAC)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rd)
+}
+
+// heal fetches intact copies of the damaged blobs from the peer and, with
+// -apply, writes them to new packfiles, dropping the damaged locations
+// from the state.  Blobs have the same MAC in synchronized repositories,
+// and the copies are checked against it before being written.
+func (cmd *Repair) heal(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	peer, err := cmd.openPeer(ctx)
+	if err != nil {
+		return 1, err
+	}
+	if peer.Origin() == repo.Origin() && peer.Root() == repo.Root() {
+		return 1, fmt.Errorf("cannot repair a store from itself")
+	}
+
+	damaged, err := damagedBlobs(ctx, repo)
+	if err != nil {
+		return 1, fmt.Errorf("repair: %w", err)
+	}
+	if len(damaged) == 0 {
+		ctx.GetLogger().Info("repair: no damaged blobs found")
+		return 0, nil
+	}
+
+	var writer *repository.RepositoryWriter
+	if cmd.Apply {
+		scanCache, err := ctx.GetCache().Scan(cmd.repairID)
+		if err != nil {
+			return 1, err
+		}
+		defer scanCache.Close()
+		writer = repo.NewRepositoryWriter(scanCache, cmd.repairID, repository.DefaultType, "")
+	}
+
+	healed, lost := 0, 0
+	for _, blob := range damaged {
+		if err := ctx.Err(); err != nil {
+			return 1, err
+		}
+
+		data, err := readBlob(peer, blob.Key())
+		if err == nil {
+			err = scrub.Validate(repo, blob.Type, blob.MAC, data)
+		}
+		if err != nil {
+			ctx.GetLogger().Error("repair: %s %x in packfile %x: no intact copy on %s: %s",
+				blob.Type, blob.MAC, blob.Location.Packfile, cmd.From, err)
+			lost++
+			continue
+		}
+
+		if writer == nil {
+			ctx.GetLogger().Info("repair: %s %x in packfile %x: intact copy found on %s",
+				blob.Type, blob.MAC, blob.Location.Packfile, cmd.From)
+			healed++
+			continue
+		}
+
+		if err := writer.PutBlob(blob.Type, blob.MAC, data); err != nil {
+			return 1, fmt.Errorf("repair: could not write %s %x: %w", blob.Type, blob.MAC, err)
+		}
+		if err := writer.RemoveBlob(blob.Type, blob.MAC, blob.Location.Packfile); err != nil {
+			return 1, fmt.Errorf("repair: could not drop damaged %s %x: %w", blob.Type, blob.MAC, err)
+		}
+		ctx.GetLogger().Info("repair: %s %x in packfile %x: healed from %s",
+			blob.Type, blob.MAC, blob.Location.Packfile, cmd.From)
+		healed++
+	}
+
+	if writer != nil && healed != 0 {
+		writer.PackerManager.Wait()
+		if err := writer.CommitTransaction(cmd.repairID); err != nil {
+			return 1, fmt.Errorf("repair: %w", err)
+		}
+	}
+
+	if writer == nil {
+		ctx.GetLogger().Info("repair: %d damaged blobs, %d can be healed from %s", len(damaged), healed, cmd.From)
+		if healed != 0 {
+			ctx.GetLogger().Info("to heal them, run `plakar repair -from %s -apply`", cmd.From)
+		}
+	} else {
+		ctx.GetLogger().Info("repair: healed %d of %d damaged blobs from %s", healed, len(damaged), cmd.From)
+	}
+
+	if lost != 0 {
+		return 1, fmt.Errorf("repair: %d damaged blobs have no intact copy on %s", lost, cmd.From)
+	}
+	return 0, nil
+}
@@ -0,0 +1,81 @@
+package repair
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/connectors/storage"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/config"
+	"github.com/PlakarKorp/plakar/subcommands/sync"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairFromMirror(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	mirror, _ := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	ptesting.StartCached(t, ctx)
+	ctx.StoreConfig = map[string]string{"location": repo.Root()}
+	ctx.Config = config.NewConfig()
+	ctx.Config.Repositories["mirror"] = map[string]string{"location": mirror.Root()}
+
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/a.txt", 0644, "hello"),
+		ptesting.NewMockFile("subdir/b.txt", 0644, "world"),
+	})
+	snap.Close()
+
+	syncCmd := &sync.Sync{}
+	require.NoError(t, syncCmd.Parse(ctx, []string{"to", "@mirror"}))
+	status, err := syncCmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	damaged, err := damagedBlobs(ctx, repo)
+	require.NoError(t, err)
+	require.Empty(t, damaged)
+
+	// lose a packfile
+	var lost objects.MAC
+	for mac := range repo.ListPackfiles() {
+		lost = mac
+		break
+	}
+	require.NoError(t, repo.Store().Delete(ctx, storage.StorageResourcePackfile, lost))
+
+	damaged, err = damagedBlobs(ctx, repo)
+	require.NoError(t, err)
+	require.NotEmpty(t, damaged)
+
+	// dry run
+	cmd := &Repair{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-from", "@mirror"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "can be healed from @mirror")
+
+	cmd = &Repair{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-from", "@mirror", "-apply"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.NoError(t, repo.RebuildState())
+	damaged, err = damagedBlobs(ctx, repo)
+	require.NoError(t, err)
+	require.Empty(t, damaged)
+}
+
+func TestRepairParseFromUnknownPeer(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	ctx.Config = config.NewConfig()
+
+	cmd := &Repair{}
+	require.Error(t, cmd.Parse(ctx, []string{"-from", "@nowhere"}))
+}
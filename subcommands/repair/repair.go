@@ -17,15 +17,16 @@
 package repair
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"time"
 
+	"github.com/PlakarKorp/kloset/connectors/storage"
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/repository/state"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/lock"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/spf13/cobra"
 )
@@ -33,10 +34,14 @@ import (
 type Repair struct {
 	subcommands.SubcommandBase
 
-	Apply bool
+	Apply   bool
+	From    string
+	Salvage string
+	Replace bool
 
 	repository *repository.Repository
 	repairID   objects.MAC
+	peerSecret []byte
 }
 
 func init() {
@@ -48,6 +53,9 @@ func (cmd *Repair) CobraCommand() *cobra.Command {
 		Use: "repair",
 	}
 	c.Flags().BoolVar(&cmd.Apply, "apply", false, "do the actual repair")
+	c.Flags().StringVar(&cmd.From, "from", "", "heal damaged blobs with intact copies from this synchronized repository")
+	c.Flags().StringVar(&cmd.Salvage, "salvage", "", "rebuild this snapshot without the files whose chunks are unrecoverable")
+	c.Flags().BoolVar(&cmd.Replace, "replace", false, "delete the original snapshot once salvaged")
 	return c
 }
 
@@ -56,6 +64,28 @@ func (cmd *Repair) Parse(ctx *appcontext.AppContext, args []string) error {
 		return err
 	}
 
+	if cmd.From != "" && cmd.Salvage != "" {
+		return fmt.Errorf("-from and -salvage are mutually exclusive")
+	}
+	if cmd.Replace && cmd.Salvage == "" {
+		return fmt.Errorf("-replace requires -salvage")
+	}
+
+	if cmd.From != "" {
+		storeConfig, err := ctx.Config.GetRepository(cmd.From)
+		if err != nil {
+			return fmt.Errorf("peer store: %w", err)
+		}
+		_, peerStoreSerializedConfig, err := storage.Open(ctx.GetInner(), storeConfig)
+		if err != nil {
+			return err
+		}
+		cmd.peerSecret, err = peerSecret(storeConfig, peerStoreSerializedConfig)
+		if err != nil {
+			return err
+		}
+	}
+
 	cmd.RepositorySecret = ctx.GetSecret()
 
 	return nil
@@ -74,6 +104,13 @@ func (cmd *Repair) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 		defer cmd.Unlock(done)
 	}
 
+	if cmd.From != "" {
+		return cmd.heal(ctx, repo)
+	}
+	if cmd.Salvage != "" {
+		return cmd.salvage(ctx, repo)
+	}
+
 	oldCache, err := repo.AppContext().GetCache().Repository(repo.Configuration().RepositoryID)
 	if err != nil {
 		return 1, err
@@ -177,92 +214,10 @@ func (cmd *Repair) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 	return 0, nil
 }
 
-func (cmd *Repair) Lock() (chan bool, error) {
-	lockDone := make(chan bool)
-	lock := repository.NewExclusiveLock(cmd.repository.AppContext().Hostname)
-
-	buffer := &bytes.Buffer{}
-	err := lock.SerializeToStream(buffer)
-	if err != nil {
-		return nil, err
-	}
-
-	_, err = cmd.repository.PutLock(cmd.repairID, buffer)
-	if err != nil {
-		return nil, err
-	}
-
-	// We installed the lock, now let's see if there is a conflicting exclusive lock or not.
-	locksID, err := cmd.repository.GetLocks()
-	if err != nil {
-		// We still need to delete it, and we need to do so manually.
-		cmd.repository.DeleteLock(cmd.repairID)
-		return nil, err
-	}
-
-	for _, lockID := range locksID {
-		if lockID == cmd.repairID {
-			continue
-		}
-
-		rd, err := cmd.repository.GetLock(lockID)
-		if err != nil {
-			cmd.repository.DeleteLock(cmd.repairID)
-			return nil, err
-		}
-
-		lock, err := repository.NewLockFromStream(rd)
-		rd.Close()
-		if err != nil {
-			cmd.repository.DeleteLock(cmd.repairID)
-			return nil, err
-		}
-
-		/* Kick out stale locks */
-		if lock.IsStale() {
-			err := cmd.repository.DeleteLock(lockID)
-			if err != nil {
-				cmd.repository.DeleteLock(cmd.repairID)
-				return nil, err
-			}
-
-			continue
-		}
-
-		// There is a lock in place, we need to abort.
-		err = cmd.repository.DeleteLock(cmd.repairID)
-		if err != nil {
-			return nil, err
-		}
-
-		return nil, fmt.Errorf("can't take exclusive lock, repository is already locked")
-	}
-
-	// The following bit is a "ping" mechanism, Lock() is a bit badly named at this point,
-	// we are just refreshing the existing lock so that the watchdog doesn't removes us.
-	go func() {
-		for {
-			select {
-			case <-lockDone:
-				cmd.repository.DeleteLock(cmd.repairID)
-				return
-			case <-time.After(repository.LOCK_REFRESH_RATE):
-				lock := repository.NewExclusiveLock(cmd.repository.AppContext().Hostname)
-
-				buffer := &bytes.Buffer{}
-
-				// We ignore errors here on purpose, it's tough to handle them
-				// correctly, and if they happen we will be ripped by the
-				// watchdog anyway.
-				lock.SerializeToStream(buffer)
-				cmd.repository.PutLock(cmd.repairID, buffer)
-			}
-		}
-	}()
-
-	return lockDone, nil
+func (cmd *Repair) Lock() (*lock.Lease, error) {
+	return lock.Acquire(cmd.repository, cmd.repairID)
 }
 
-func (cmd *Repair) Unlock(ping chan bool) {
-	close(ping)
+func (cmd *Repair) Unlock(lease *lock.Lease) {
+	lease.Release()
 }
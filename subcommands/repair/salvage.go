@@ -0,0 +1,268 @@
+package repair
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/PlakarKorp/kloset/btree"
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/hold"
+	"github.com/PlakarKorp/plakar/scrub"
+)
+
+// salvageImporter replays the tree of a damaged snapshot, extended
+// attributes included.  The files in lost are emitted as errors, so that
+// the backup records them in the error list of the new snapshot instead of
+// their content.
+type salvageImporter struct {
+	repo   *repository.Repository
+	snap   *snapshot.Snapshot
+	fs     *vfs.Filesystem
+	lost   map[string]error
+	xattrs map[string][]*vfs.Xattr
+
+	origin string
+	typ    string
+	root   string
+}
+
+func (imp *salvageImporter) Origin() string        { return imp.origin }
+func (imp *salvageImporter) Type() string          { return imp.typ }
+func (imp *salvageImporter) Root() string          { return imp.root }
+func (imp *salvageImporter) Flags() location.Flags { return 0 }
+
+func (imp *salvageImporter) Import(ctx context.Context, records chan<- *connectors.Record, results <-chan *connectors.Result) error {
+	defer close(records)
+
+	if err := imp.loadXattrs(); err != nil {
+		return err
+	}
+
+	return imp.fs.WalkDir("/", func(p string, e *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if lost, ok := imp.lost[p]; ok {
+			records <- connectors.NewError(p, lost)
+			return nil
+		}
+
+		var names []string
+		for _, xattr := range imp.xattrs[p] {
+			names = append(names, xattr.Name)
+		}
+
+		var open func() (io.ReadCloser, error)
+		if e.Stat().Mode().IsRegular() {
+			open = func() (io.ReadCloser, error) { return e.Open(imp.fs) }
+		}
+		record := connectors.NewRecord(p, e.SymlinkTarget, e.FileInfo, names, open)
+		records <- record
+
+		for _, xattr := range imp.xattrs[p] {
+			records <- connectors.NewXattr(record, xattr.Name, xattr.Type, func() (io.ReadCloser, error) {
+				return io.NopCloser(vfs.NewObjectReader(imp.repo, xattr.ResolvedObject, xattr.Size, -1)), nil
+			})
+		}
+		return nil
+	})
+}
+
+// loadXattrs reads the extended attributes of the snapshot by path, as
+// plakar diag xattr lists them.
+func (imp *salvageImporter) loadXattrs() error {
+	imp.xattrs = make(map[string][]*vfs.Xattr)
+
+	rd, err := imp.repo.GetBlob(resources.RT_XATTR_BTREE, imp.snap.Header.GetSource(0).VFS.Xattrs)
+	if err != nil {
+		return err
+	}
+
+	store := repository.NewRepositoryStore[string, objects.MAC](imp.repo, resources.RT_XATTR_NODE)
+	tree, err := btree.Deserialize(rd, store, vfs.PathCmp)
+	if err != nil {
+		return err
+	}
+
+	it, err := tree.ScanFrom("/")
+	if err != nil {
+		return err
+	}
+	for it.Next() {
+		_, xattrmac := it.Current()
+		xattr, err := imp.fs.ResolveXattr(xattrmac)
+		if err != nil {
+			return err
+		}
+		if _, ok := imp.lost[xattr.Path]; !ok {
+			imp.xattrs[xattr.Path] = append(imp.xattrs[xattr.Path], xattr)
+		}
+	}
+	return it.Err()
+}
+
+func (imp *salvageImporter) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (imp *salvageImporter) Close(ctx context.Context) error {
+	return nil
+}
+
+// lostFiles returns the regular files of the snapshot that have a chunk
+// the repository can no longer read intact, with the reason.  Chunks are
+// shared between files and only read once.
+func lostFiles(ctx *appcontext.AppContext, repo *repository.Repository, fs *vfs.Filesystem) (map[string]error, error) {
+	chunks := make(map[objects.MAC]error)
+	lost := make(map[string]error)
+
+	err := fs.WalkDir("/", func(p string, e *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !e.Stat().Mode().IsRegular() || e.ResolvedObject == nil {
+			return nil
+		}
+
+		for _, chunk := range e.ResolvedObject.Chunks {
+			chunkErr, seen := chunks[chunk.ContentMAC]
+			if !seen {
+				key := scrub.Key{Type: resources.RT_CHUNK, MAC: chunk.ContentMAC}
+				data, err := readBlob(repo, key)
+				if err == nil {
+					err = scrub.Validate(repo, key.Type, key.MAC, data)
+				}
+				chunkErr = err
+				chunks[chunk.ContentMAC] = chunkErr
+			}
+			if chunkErr != nil {
+				lost[p] = fmt.Errorf("unrecoverable chunk %x: %w", chunk.ContentMAC, chunkErr)
+				break
+			}
+		}
+		return nil
+	})
+	return lost, err
+}
+
+// salvage rebuilds a snapshot without the files it can no longer restore.
+// The new snapshot keeps the header of the original, and the files left
+// out show up in its error list.  With -replace, the original is deleted
+// once the new snapshot is committed.
+func (cmd *Repair) salvage(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	snap, _, err := locate.OpenSnapshotByPath(repo, cmd.Salvage)
+	if err != nil {
+		return 1, fmt.Errorf("repair: %s: %w", cmd.Salvage, err)
+	}
+	defer snap.Close()
+	id := snap.Header.Identifier
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return 1, fmt.Errorf("repair: %x: %w", id[:4], err)
+	}
+
+	lost, err := lostFiles(ctx, repo, fs)
+	if err != nil {
+		return 1, fmt.Errorf("repair: %x: %w", id[:4], err)
+	}
+	if len(lost) == 0 {
+		ctx.GetLogger().Info("repair: snapshot %x has no unrecoverable files", id[:4])
+		return 0, nil
+	}
+	for _, p := range slices.Sorted(maps.Keys(lost)) {
+		ctx.GetLogger().Warn("repair: %x:%s: %s", id[:4], p, lost[p])
+	}
+
+	if !cmd.Apply {
+		ctx.GetLogger().Info("repair: %d files of snapshot %x are unrecoverable", len(lost), id[:4])
+		ctx.GetLogger().Info("to salvage the rest, run `plakar repair -salvage %s -apply`", cmd.Salvage)
+		return 0, nil
+	}
+
+	newID, err := salvageSnapshot(ctx, repo, snap, fs, lost)
+	if err != nil {
+		return 1, fmt.Errorf("repair: could not salvage snapshot %x: %w", id[:4], err)
+	}
+	ctx.GetLogger().Info("repair: salvaged snapshot %x into %x, %d files left out", id[:4], newID[:4], len(lost))
+
+	if cmd.Replace {
+		holds, err := hold.Load(repo)
+		if err != nil {
+			return 1, fmt.Errorf("repair: %w", err)
+		}
+		if err := holds.Check(id, time.Now()); err != nil {
+			return 1, fmt.Errorf("repair: not deleting snapshot %x: %w", id[:4], err)
+		}
+		if err := repo.DeleteSnapshot(id); err != nil {
+			return 1, fmt.Errorf("repair: could not delete snapshot %x: %w", id[:4], err)
+		}
+		ctx.GetLogger().Info("repair: deleted snapshot %x", id[:4])
+	}
+	return 0, nil
+}
+
+// salvageSnapshot copies snap into a new snapshot with the same header,
+// leaving out the content of the lost files.  The tree of snap serves as
+// the VFS cache of the copy, so that the files kept are not read again
+// but point to the objects they already had.
+func salvageSnapshot(ctx *appcontext.AppContext, repo *repository.Repository, snap *snapshot.Snapshot, fs *vfs.Filesystem, lost map[string]error) (objects.MAC, error) {
+	source := snap.Header.GetSource(0)
+	newSnap, err := snapshot.Create(repo, repository.DefaultType, "", objects.NilMac, &snapshot.BuilderOptions{
+		Name: snap.Header.Name,
+	})
+	if err != nil {
+		return objects.NilMac, err
+	}
+	defer newSnap.Close()
+
+	newSnap.WithVFSCache(fs)
+
+	imp := &salvageImporter{
+		repo:   repo,
+		snap:   snap,
+		fs:     fs,
+		lost:   lost,
+		origin: source.Importer.Origin,
+		typ:    source.Importer.Type,
+		root:   source.Importer.Directory,
+	}
+	src, err := snapshot.NewSource(repo.AppContext(), imp)
+	if err != nil {
+		return objects.NilMac, err
+	}
+	if err := newSnap.Backup(src); err != nil {
+		return objects.NilMac, ctx.ErrorCause(err)
+	}
+
+	// keep the original header, as sync does, but with the identity and
+	// the tree of the copy
+	identifier, sources := newSnap.Header.Identifier, newSnap.Header.Sources
+	*newSnap.Header = *snap.Header
+	newSnap.Header.Identifier = identifier
+	newSnap.Header.Sources = sources
+
+	if err := newSnap.Commit(); err != nil {
+		return objects.NilMac, ctx.ErrorCause(err)
+	}
+	return newSnap.Header.Identifier, nil
+}
@@ -0,0 +1,87 @@
+package repair
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/snapshot"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSalvageSnapshot(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/a.txt", 0644, "hello"),
+		ptesting.NewMockFile("subdir/b.txt", 0644, "world"),
+	})
+	defer snap.Close()
+
+	fs, err := snap.Filesystem()
+	require.NoError(t, err)
+
+	lost, err := lostFiles(ctx, repo, fs)
+	require.NoError(t, err)
+	require.Empty(t, lost)
+
+	lost = map[string]error{"/subdir/a.txt": errors.New("unrecoverable chunk")}
+	newID, err := salvageSnapshot(ctx, repo, snap, fs, lost)
+	require.NoError(t, err)
+	require.NotEqual(t, snap.Header.Identifier, newID)
+
+	require.NoError(t, repo.RebuildState())
+	salvaged, err := snapshot.Load(repo, newID)
+	require.NoError(t, err)
+	defer salvaged.Close()
+
+	require.Equal(t, snap.Header.Name, salvaged.Header.Name)
+	require.True(t, snap.Header.Timestamp.Equal(salvaged.Header.Timestamp))
+
+	salvagedFs, err := salvaged.Filesystem()
+	require.NoError(t, err)
+
+	_, err = salvagedFs.GetEntry("/subdir/b.txt")
+	require.NoError(t, err)
+
+	var errored []string
+	for item, err := range salvagedFs.Errors("/") {
+		require.NoError(t, err)
+		errored = append(errored, item.Name)
+	}
+	require.Len(t, errored, 1)
+	require.Contains(t, errored[0], "a.txt")
+}
+
+func TestSalvageNothingLost(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "hello"),
+	})
+	snap.Close()
+
+	cmd := &Repair{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-salvage", hex.EncodeToString(snap.Header.GetIndexShortID()), "-apply"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "has no unrecoverable files")
+}
+
+func TestSalvageParse(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	cmd := &Repair{}
+	require.Error(t, cmd.Parse(ctx, []string{"-replace"}))
+
+	cmd = &Repair{}
+	require.Error(t, cmd.Parse(ctx, []string{"-salvage", "abcd", "-from", "@mirror"}))
+}
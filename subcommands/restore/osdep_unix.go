@@ -0,0 +1,17 @@
+//go:build !windows
+
+package restore
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func fileOwner(info os.FileInfo) (uint64, uint64, error) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, fmt.Errorf("cannot read owner of %s", info.Name())
+	}
+	return uint64(st.Uid), uint64(st.Gid), nil
+}
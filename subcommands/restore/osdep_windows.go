@@ -0,0 +1,10 @@
+package restore
+
+import (
+	"fmt"
+	"os"
+)
+
+func fileOwner(info os.FileInfo) (uint64, uint64, error) {
+	return 0, 0, fmt.Errorf("-owner-from-target is not supported on windows")
+}
@@ -0,0 +1,412 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/location"
+)
+
+// Ownership says how the owner and mode recorded in the snapshot are
+// rewritten before the entries reach the exporter.  It only holds what was
+// typed on the command line: names are resolved on the host doing the
+// restore, in newRemapper.
+type Ownership struct {
+	UIDs            map[uint64]uint64
+	GIDs            map[uint64]uint64
+	Users           map[string]string
+	Groups          map[string]string
+	OwnerFromTarget bool
+	Chmod           string
+}
+
+func (o *Ownership) IsZero() bool {
+	return len(o.UIDs) == 0 && len(o.GIDs) == 0 &&
+		len(o.Users) == 0 && len(o.Groups) == 0 &&
+		!o.OwnerFromTarget && o.Chmod == ""
+}
+
+type mapFlags []string
+
+func (m *mapFlags) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *mapFlags) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+func splitMapping(value string) (string, string, error) {
+	from, to, ok := strings.Cut(value, ":")
+	if !ok || from == "" || to == "" {
+		return "", "", fmt.Errorf("invalid mapping %q, expected FROM:TO", value)
+	}
+	return from, to, nil
+}
+
+func parseIDMappings(values []string) (map[uint64]uint64, error) {
+	ids := make(map[uint64]uint64, len(values))
+	for _, value := range values {
+		from, to, err := splitMapping(value)
+		if err != nil {
+			return nil, err
+		}
+		f, err := strconv.ParseUint(from, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q in mapping %q", from, value)
+		}
+		t, err := strconv.ParseUint(to, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q in mapping %q", to, value)
+		}
+		if _, exists := ids[f]; exists {
+			return nil, fmt.Errorf("id %d is mapped more than once", f)
+		}
+		ids[f] = t
+	}
+	return ids, nil
+}
+
+func parseNameMappings(values []string) (map[string]string, error) {
+	names := make(map[string]string, len(values))
+	for _, value := range values {
+		from, to, err := splitMapping(value)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := names[from]; exists {
+			return nil, fmt.Errorf("%s is mapped more than once", from)
+		}
+		names[from] = to
+	}
+	return names, nil
+}
+
+// chmodClause is one comma-separated part of a symbolic mode: "go-w" or
+// "u=rwX".  An octal mode becomes a single "a=" clause.
+type chmodClause struct {
+	mask fs.FileMode
+	op   byte
+	perm fs.FileMode
+	// condExec is X: execute only for directories or files that are
+	// already executable by someone.
+	condExec fs.FileMode
+}
+
+const permBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+func (c chmodClause) apply(mode fs.FileMode) fs.FileMode {
+	perm := c.perm
+	if c.condExec != 0 && (mode.IsDir() || mode&0111 != 0) {
+		perm |= c.condExec
+	}
+
+	switch c.op {
+	case '+':
+		return mode | perm
+	case '-':
+		return mode &^ perm
+	default:
+		return mode&^c.mask | perm
+	}
+}
+
+func parseChmod(spec string) ([]chmodClause, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	if spec[0] >= '0' && spec[0] <= '7' {
+		octal, err := strconv.ParseUint(spec, 8, 32)
+		if err != nil || octal > 07777 {
+			return nil, fmt.Errorf("invalid mode %q", spec)
+		}
+		perm := fs.FileMode(octal & 0777)
+		if octal&04000 != 0 {
+			perm |= fs.ModeSetuid
+		}
+		if octal&02000 != 0 {
+			perm |= fs.ModeSetgid
+		}
+		if octal&01000 != 0 {
+			perm |= fs.ModeSticky
+		}
+		return []chmodClause{{mask: permBits, op: '=', perm: perm}}, nil
+	}
+
+	var clauses []chmodClause
+	for _, part := range strings.Split(spec, ",") {
+		i := 0
+		var who string
+		for i < len(part) && strings.IndexByte("ugoa", part[i]) >= 0 {
+			who += string(part[i])
+			i++
+		}
+		if who == "" || strings.Contains(who, "a") {
+			who = "ugo"
+		}
+
+		var mask fs.FileMode
+		for _, w := range who {
+			mask |= whoBits(w, 0777, 0, 0)
+			mask |= whoBits(w, 0, fs.ModeSetuid, fs.ModeSetgid)
+		}
+		if who == "ugo" {
+			mask |= fs.ModeSticky
+		}
+
+		if i == len(part) {
+			return nil, fmt.Errorf("invalid mode %q: missing operator", spec)
+		}
+		for i < len(part) {
+			op := part[i]
+			if op != '+' && op != '-' && op != '=' {
+				return nil, fmt.Errorf("invalid mode %q: unexpected %q", spec, op)
+			}
+			i++
+
+			clause := chmodClause{mask: mask, op: op}
+			for i < len(part) && strings.IndexByte("+-=", part[i]) < 0 {
+				for _, w := range who {
+					switch part[i] {
+					case 'r':
+						clause.perm |= whoBits(w, 0444, 0, 0)
+					case 'w':
+						clause.perm |= whoBits(w, 0222, 0, 0)
+					case 'x':
+						clause.perm |= whoBits(w, 0111, 0, 0)
+					case 'X':
+						clause.condExec |= whoBits(w, 0111, 0, 0)
+					case 's':
+						clause.perm |= whoBits(w, 0, fs.ModeSetuid, fs.ModeSetgid)
+					case 't':
+						if who == "ugo" || w == 'o' {
+							clause.perm |= fs.ModeSticky
+						}
+					default:
+						return nil, fmt.Errorf("invalid mode %q: unexpected %q", spec, part[i])
+					}
+				}
+				i++
+			}
+			clauses = append(clauses, clause)
+		}
+	}
+	return clauses, nil
+}
+
+// whoBits picks out of perm the bits belonging to w, and the setuid or
+// setgid bit that goes with it.
+func whoBits(w rune, perm fs.FileMode, setuid, setgid fs.FileMode) fs.FileMode {
+	switch w {
+	case 'u':
+		return perm&0700 | setuid
+	case 'g':
+		return perm&0070 | setgid
+	default:
+		return perm & 0007
+	}
+}
+
+// remapper is an Ownership resolved against the restoring host.
+type remapper struct {
+	uids    map[uint64]uint64
+	gids    map[uint64]uint64
+	users   map[string]uint64
+	groups  map[string]uint64
+	chmod   []chmodClause
+	owner   bool
+	uid     uint64
+	gid     uint64
+	unames  map[uint64]string
+	gnames  map[uint64]string
+	forcedU string
+	forcedG string
+}
+
+func lookupUser(name string) (uint64, error) {
+	if u, err := user.Lookup(name); err == nil {
+		return strconv.ParseUint(u.Uid, 10, 32)
+	}
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return id, nil
+	}
+	return 0, fmt.Errorf("unknown user %q", name)
+}
+
+func lookupGroup(name string) (uint64, error) {
+	if g, err := user.LookupGroup(name); err == nil {
+		return strconv.ParseUint(g.Gid, 10, 32)
+	}
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return id, nil
+	}
+	return 0, fmt.Errorf("unknown group %q", name)
+}
+
+func newRemapper(o *Ownership, exp exporter.Exporter) (*remapper, error) {
+	r := &remapper{
+		uids:   o.UIDs,
+		gids:   o.GIDs,
+		users:  make(map[string]uint64, len(o.Users)),
+		groups: make(map[string]uint64, len(o.Groups)),
+		unames: make(map[uint64]string),
+		gnames: make(map[uint64]string),
+		owner:  o.OwnerFromTarget,
+	}
+
+	for from, to := range o.Users {
+		uid, err := lookupUser(to)
+		if err != nil {
+			return nil, err
+		}
+		r.users[from] = uid
+	}
+	for from, to := range o.Groups {
+		gid, err := lookupGroup(to)
+		if err != nil {
+			return nil, err
+		}
+		r.groups[from] = gid
+	}
+
+	chmod, err := parseChmod(o.Chmod)
+	if err != nil {
+		return nil, err
+	}
+	r.chmod = chmod
+
+	if r.owner {
+		if exp.Flags()&location.FLAG_LOCALFS == 0 {
+			return nil, fmt.Errorf("-owner-from-target requires a local destination")
+		}
+		r.uid, r.gid, err = targetOwner(exp.Root())
+		if err != nil {
+			return nil, err
+		}
+		r.forcedU = r.userName(r.uid)
+		r.forcedG = r.groupName(r.gid)
+	}
+
+	return r, nil
+}
+
+// targetOwner returns the owner of the restore root or, as it may not be
+// created yet, of its closest existing parent.
+func targetOwner(root string) (uint64, uint64, error) {
+	dir := root
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			return fileOwner(info)
+		}
+		if !os.IsNotExist(err) {
+			return 0, 0, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return 0, 0, err
+		}
+		dir = parent
+	}
+}
+
+// The names recorded in the snapshot belong to the source host, so a
+// remapped id gets the name it has here, if any.
+func (r *remapper) userName(uid uint64) string {
+	if name, ok := r.unames[uid]; ok {
+		return name
+	}
+	var name string
+	if u, err := user.LookupId(strconv.FormatUint(uid, 10)); err == nil {
+		name = u.Username
+	}
+	r.unames[uid] = name
+	return name
+}
+
+func (r *remapper) groupName(gid uint64) string {
+	if name, ok := r.gnames[gid]; ok {
+		return name
+	}
+	var name string
+	if g, err := user.LookupGroupId(strconv.FormatUint(gid, 10)); err == nil {
+		name = g.Name
+	}
+	r.gnames[gid] = name
+	return name
+}
+
+func (r *remapper) apply(record *connectors.Record) {
+	if record.Err != nil || record.IsXattr {
+		return
+	}
+
+	fi := &record.FileInfo
+
+	switch {
+	case r.owner:
+		fi.Luid, fi.Lusername = r.uid, r.forcedU
+	case hasKey(r.users, fi.Lusername):
+		uid := r.users[fi.Lusername]
+		fi.Luid, fi.Lusername = uid, r.userName(uid)
+	case hasKey(r.uids, fi.Luid):
+		uid := r.uids[fi.Luid]
+		fi.Luid, fi.Lusername = uid, r.userName(uid)
+	}
+
+	switch {
+	case r.owner:
+		fi.Lgid, fi.Lgroupname = r.gid, r.forcedG
+	case hasKey(r.groups, fi.Lgroupname):
+		gid := r.groups[fi.Lgroupname]
+		fi.Lgid, fi.Lgroupname = gid, r.groupName(gid)
+	case hasKey(r.gids, fi.Lgid):
+		gid := r.gids[fi.Lgid]
+		fi.Lgid, fi.Lgroupname = gid, r.groupName(gid)
+	}
+
+	if fi.Lmode&fs.ModeSymlink == 0 {
+		for _, clause := range r.chmod {
+			fi.Lmode = clause.apply(fi.Lmode)
+		}
+	}
+}
+
+func hasKey[K comparable, V any](m map[K]V, key K) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// remapExporter rewrites the records on their way to the real exporter.
+type remapExporter struct {
+	exporter.Exporter
+	remapper *remapper
+}
+
+func (e *remapExporter) Export(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) error {
+	remapped := make(chan *connectors.Record, cap(records))
+
+	go func() {
+		defer close(remapped)
+		for record := range records {
+			e.remapper.apply(record)
+			select {
+			case remapped <- record:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return e.Exporter.Export(ctx, remapped, results)
+}
@@ -0,0 +1,107 @@
+package restore
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/stretchr/testify/require"
+)
+
+func chmod(t *testing.T, spec string, mode fs.FileMode) fs.FileMode {
+	t.Helper()
+	clauses, err := parseChmod(spec)
+	require.NoError(t, err)
+	for _, clause := range clauses {
+		mode = clause.apply(mode)
+	}
+	return mode
+}
+
+func TestParseChmod(t *testing.T) {
+	require.Equal(t, fs.FileMode(0644), chmod(t, "0644", 0755))
+	require.Equal(t, fs.ModeDir|0750, chmod(t, "750", fs.ModeDir|0777))
+	require.Equal(t, fs.FileMode(0755)|fs.ModeSetuid, chmod(t, "4755", 0600))
+	require.Equal(t, fs.FileMode(0644), chmod(t, "go-w", 0666))
+	require.Equal(t, fs.FileMode(0640), chmod(t, "u=rw,g=r,o=", 0777))
+	require.Equal(t, fs.FileMode(0755), chmod(t, "a+rX", 0700))
+	require.Equal(t, fs.FileMode(0644), chmod(t, "a+rX", 0600))
+	require.Equal(t, fs.ModeDir|0755, chmod(t, "+rX", fs.ModeDir|0700))
+	require.Equal(t, fs.FileMode(0600), chmod(t, "u+w-x", 0500))
+	require.Equal(t, fs.FileMode(0777)|fs.ModeSticky, chmod(t, "+t", 0777))
+
+	for _, spec := range []string{"0999", "u", "u*w", "u+q", "17777"} {
+		_, err := parseChmod(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestParseMappings(t *testing.T) {
+	ids, err := parseIDMappings([]string{"1000:2001", "0:65534"})
+	require.NoError(t, err)
+	require.Equal(t, map[uint64]uint64{1000: 2001, 0: 65534}, ids)
+
+	for _, bad := range [][]string{{"1000"}, {"a:1"}, {"1:"}, {"1:2", "1:3"}} {
+		_, err := parseIDMappings(bad)
+		require.Error(t, err, bad)
+	}
+
+	names, err := parseNameMappings([]string{"alice:bob"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"alice": "bob"}, names)
+
+	_, err = parseNameMappings([]string{":bob"})
+	require.Error(t, err)
+}
+
+func TestRemapperApply(t *testing.T) {
+	r := &remapper{
+		uids:   map[uint64]uint64{1000: 2001},
+		gids:   map[uint64]uint64{100: 200},
+		users:  map[string]uint64{"alice": 3000},
+		groups: map[string]uint64{},
+		unames: map[uint64]string{2001: "carol", 3000: "bob"},
+		gnames: map[uint64]string{200: "staff"},
+	}
+	r.chmod, _ = parseChmod("go-w")
+
+	byName := &connectors.Record{FileInfo: objects.FileInfo{
+		Luid: 1000, Lgid: 100, Lusername: "alice", Lgroupname: "users", Lmode: 0666,
+	}}
+	r.apply(byName)
+	require.Equal(t, uint64(3000), byName.FileInfo.Luid)
+	require.Equal(t, "bob", byName.FileInfo.Lusername)
+	require.Equal(t, uint64(200), byName.FileInfo.Lgid)
+	require.Equal(t, "staff", byName.FileInfo.Lgroupname)
+	require.Equal(t, fs.FileMode(0644), byName.FileInfo.Lmode)
+
+	byID := &connectors.Record{FileInfo: objects.FileInfo{Luid: 1000, Lgid: 5, Lmode: 0666}}
+	r.apply(byID)
+	require.Equal(t, uint64(2001), byID.FileInfo.Luid)
+	require.Equal(t, "carol", byID.FileInfo.Lusername)
+	require.Equal(t, uint64(5), byID.FileInfo.Lgid)
+
+	link := &connectors.Record{FileInfo: objects.FileInfo{Lmode: fs.ModeSymlink | 0777}}
+	r.apply(link)
+	require.Equal(t, fs.ModeSymlink|0777, link.FileInfo.Lmode)
+}
+
+func TestRestoreParseOwnershipOptions(t *testing.T) {
+	_, _, ctx := generateSnapshot(t)
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-map-uid", "1000:2001", "-map-user", "alice:bob", "-chmod", "go-w"}))
+	require.Equal(t, map[uint64]uint64{1000: 2001}, cmd.Ownership.UIDs)
+	require.Equal(t, map[string]string{"alice": "bob"}, cmd.Ownership.Users)
+	require.Equal(t, "go-w", cmd.Ownership.Chmod)
+
+	cmd = &Restore{}
+	require.Error(t, cmd.Parse(ctx, []string{"-map-gid", "nope"}))
+
+	cmd = &Restore{}
+	require.Error(t, cmd.Parse(ctx, []string{"-chmod", "u*x"}))
+
+	cmd = &Restore{}
+	require.Error(t, cmd.Parse(ctx, []string{"-skip-permissions", "-owner-from-target"}))
+}
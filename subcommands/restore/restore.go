@@ -44,12 +44,20 @@ type Restore struct {
 	OptTag             string
 	OptSkipPermissions bool
 	Opts               map[string]string
+	Ownership          Ownership
 
 	Target    string
 	Strip     string
 	Snapshots []string
 
 	pullPath string
+
+	optMapUID          mapFlags
+	optMapGID          mapFlags
+	optMapUser         mapFlags
+	optMapGroup        mapFlags
+	optOwnerFromTarget bool
+	optChmod           string
 }
 
 func init() {
@@ -71,6 +79,12 @@ func (cmd *Restore) CobraCommand() *cobra.Command {
 	c.Flags().Var(subcommands.GoValue(utils.NewOptsFlag(cmd.Opts)), "o", "specify extra exporter options")
 	c.Flags().StringVar(&cmd.pullPath, "to", "", "base directory where pull will restore")
 	c.Flags().BoolVar(&cmd.OptSkipPermissions, "skip-permissions", false, "do not restore file permissions")
+	c.Flags().Var(subcommands.GoValue(&cmd.optMapUID), "map-uid", "restore files owned by uid FROM as uid TO, can be specified multiple times")
+	c.Flags().Var(subcommands.GoValue(&cmd.optMapGID), "map-gid", "restore files owned by gid FROM as gid TO, can be specified multiple times")
+	c.Flags().Var(subcommands.GoValue(&cmd.optMapUser), "map-user", "restore files owned by user FROM as user TO, can be specified multiple times")
+	c.Flags().Var(subcommands.GoValue(&cmd.optMapGroup), "map-group", "restore files owned by group FROM as group TO, can be specified multiple times")
+	c.Flags().BoolVar(&cmd.optOwnerFromTarget, "owner-from-target", false, "give restored files the owner of the target directory")
+	c.Flags().StringVar(&cmd.optChmod, "chmod", "", "apply a chmod-style mode to restored files and directories")
 	return c
 }
 
@@ -88,6 +102,37 @@ func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 		return fmt.Errorf("multiple restore paths specified, please specify only one")
 	}
 
+	uids, err := parseIDMappings(cmd.optMapUID)
+	if err != nil {
+		return fmt.Errorf("-map-uid: %w", err)
+	}
+	gids, err := parseIDMappings(cmd.optMapGID)
+	if err != nil {
+		return fmt.Errorf("-map-gid: %w", err)
+	}
+	users, err := parseNameMappings(cmd.optMapUser)
+	if err != nil {
+		return fmt.Errorf("-map-user: %w", err)
+	}
+	groups, err := parseNameMappings(cmd.optMapGroup)
+	if err != nil {
+		return fmt.Errorf("-map-group: %w", err)
+	}
+	if _, err := parseChmod(cmd.optChmod); err != nil {
+		return fmt.Errorf("-chmod: %w", err)
+	}
+	cmd.Ownership = Ownership{
+		UIDs:            uids,
+		GIDs:            gids,
+		Users:           users,
+		Groups:          groups,
+		OwnerFromTarget: cmd.optOwnerFromTarget,
+		Chmod:           cmd.optChmod,
+	}
+	if cmd.OptSkipPermissions && !cmd.Ownership.IsZero() {
+		return fmt.Errorf("-skip-permissions cannot be combined with ownership or mode options")
+	}
+
 	if cmd.pullPath == "" {
 		cmd.pullPath = fmt.Sprintf("%s/plakar-%s", ctx.CWD, time.Now().Format("20060102150405"))
 	}
@@ -176,6 +221,17 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 	}
 	defer exporterInstance.Close(ctx)
 
+	if !cmd.Ownership.IsZero() {
+		remapper, err := newRemapper(&cmd.Ownership, exporterInstance)
+		if err != nil {
+			return 1, err
+		}
+		exporterInstance = &remapExporter{
+			Exporter: exporterInstance,
+			remapper: remapper,
+		}
+	}
+
 	opts := &snapshot.ExportOptions{}
 	if cmd.OptSkipPermissions {
 		opts.SkipPermissions = true
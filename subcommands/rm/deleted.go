@@ -0,0 +1,80 @@
+package rm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/trash"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// listDeleted prints the deleted snapshots, newest first, and whether
+// plakar undelete can still bring them back.
+func listDeleted(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	bin, err := trash.Load(repo)
+	if err != nil {
+		return 1, fmt.Errorf("rm: %w", err)
+	}
+
+	type entry struct {
+		id     objects.MAC
+		ts     time.Time
+		line   string
+		status string
+	}
+
+	var entries []entry
+	for snapshotID := range repo.ListDeletedSnapShots() {
+		if err := ctx.Err(); err != nil {
+			return 1, err
+		}
+
+		e := entry{id: snapshotID, status: "recoverable"}
+		known, ok := bin.Get(snapshotID)
+		restored := ok && known.Restored != (objects.MAC{})
+		if restored {
+			e.status = fmt.Sprintf("restored as %x", known.Restored[:4])
+		}
+
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			e.line = fmt.Sprintf("%-20s %10x", "-", snapshotID[:4])
+			e.status = "unrecoverable"
+			entries = append(entries, e)
+			continue
+		}
+		e.ts = snap.Header.Timestamp
+		e.line = fmt.Sprintf("%s %10s %s",
+			snap.Header.Timestamp.UTC().Format(time.RFC3339),
+			hex.EncodeToString(snap.Header.GetIndexShortID()),
+			utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory))
+		if !restored {
+			if err := trash.Recoverable(repo, snap); err != nil {
+				e.status = "unrecoverable: " + err.Error()
+			}
+		}
+		snap.Close()
+		if ok {
+			e.status += ", deleted " + known.Deleted.UTC().Format(time.RFC3339)
+		}
+		entries = append(entries, e)
+	}
+
+	// unknown timestamps go last
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].ts.Equal(entries[j].ts) {
+			return hex.EncodeToString(entries[i].id[:]) < hex.EncodeToString(entries[j].id[:])
+		}
+		return entries[i].ts.After(entries[j].ts)
+	})
+	for _, e := range entries {
+		fmt.Fprintf(ctx.Stdout, "%s (%s)\n", e.line, e.status)
+	}
+	return 0, nil
+}
@@ -29,7 +29,10 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/hold"
+	"github.com/PlakarKorp/plakar/reclaim"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/trash"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
@@ -40,7 +43,8 @@ type Rm struct {
 
 	LocateOptions *locate.LocateOptions
 
-	Apply bool
+	Apply       bool
+	ListDeleted bool
 }
 
 func init() {
@@ -54,6 +58,7 @@ func (cmd *Rm) CobraCommand() *cobra.Command {
 		Use: "rm [OPTIONS] SNAPSHOT...",
 	}
 	c.Flags().BoolVar(&cmd.Apply, "apply", false, "do the actual removal")
+	c.Flags().BoolVar(&cmd.ListDeleted, "list-deleted", false, "list the deleted snapshots and whether they can be undeleted")
 	subcommands.InstallGoFlags(c.Flags(), cmd.LocateOptions.InstallDeletionFlags)
 	return c
 }
@@ -64,6 +69,14 @@ func (cmd *Rm) Parse(ctx *appcontext.AppContext, args []string) error {
 		return err
 	}
 
+	if cmd.ListDeleted {
+		if cmd.Apply || len(rest) != 0 || !cmd.LocateOptions.Empty() {
+			return fmt.Errorf("-list-deleted takes no other argument")
+		}
+		cmd.RepositorySecret = ctx.GetSecret()
+		return nil
+	}
+
 	if len(rest) == 0 && cmd.LocateOptions.Empty() {
 		return fmt.Errorf("no filter specified, not going to remove everything")
 	}
@@ -76,6 +89,10 @@ func (cmd *Rm) Parse(ctx *appcontext.AppContext, args []string) error {
 }
 
 func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if cmd.ListDeleted {
+		return listDeleted(ctx, repo)
+	}
+
 	matches, err := locate.LocateSnapshotIDs(repo, cmd.LocateOptions)
 	if err != nil {
 		return 1, err
@@ -86,6 +103,22 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 		return 0, nil
 	}
 
+	holds, err := hold.Load(repo)
+	if err != nil {
+		return 1, fmt.Errorf("rm: %w", err)
+	}
+	held := 0
+	now := time.Now()
+	for _, id := range matches {
+		if err := holds.Check(id, now); err != nil {
+			ctx.GetLogger().Error("rm: %x: %s", id[:4], err)
+			held++
+		}
+	}
+	if held != 0 {
+		return 1, fmt.Errorf("rm: %d snapshots are on hold, not removing anything", held)
+	}
+
 	// plan
 	if !cmd.Apply {
 		type planEntry struct {
@@ -142,12 +175,15 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 		for _, e := range entries {
 			fmt.Fprintf(ctx.Stdout, "%s\n", e.prefix)
 		}
+		printReclaimable(ctx, repo, matches)
 		return 0, nil
 	}
 
 	// execution
 	errors := 0
 	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	var deleted []objects.MAC
 	repo.NoStateToLocalDisk = true
 	for _, matchID := range matches {
 		wg.Add(1)
@@ -155,17 +191,38 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 			defer wg.Done()
 			if err := repo.DeleteSnapshot(snapshotID); err != nil {
 				ctx.GetLogger().Error("%s", err)
+				mu.Lock()
 				errors++
+				mu.Unlock()
 				return
 			}
 			ctx.GetLogger().Info("rm: removal of %x completed successfully", snapshotID[:4])
+			mu.Lock()
+			deleted = append(deleted, snapshotID)
+			mu.Unlock()
 		}(matchID)
 	}
 	wg.Wait()
 
+	if err := trash.Record(repo, deleted, now); err != nil {
+		ctx.GetLogger().Warn("rm: could not record the deletion dates: %s", err)
+	}
+
 	if errors != 0 {
 		return 1, fmt.Errorf("failed to remove %d snapshots", errors)
 	}
 
 	return 0, nil
 }
+
+// printReclaimable tells how much space deleting the snapshots would give
+// back once maintenance runs.  It is only an estimate, failing to compute
+// it does not fail the command.
+func printReclaimable(ctx *appcontext.AppContext, repo *repository.Repository, snapshots []objects.MAC) {
+	est, err := reclaim.Snapshots(ctx, repo, snapshots)
+	if err != nil {
+		ctx.GetLogger().Warn("rm: could not estimate the reclaimable space: %s", err)
+		return
+	}
+	fmt.Fprintf(ctx.Stdout, "rm: maintenance would then reclaim %s\n", est)
+}
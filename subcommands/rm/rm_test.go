@@ -150,5 +150,33 @@ func TestRm_DryRun_ShowsPlan(t *testing.T) {
 
 	out := bufOut.String()
 	require.Contains(t, out, "rm: would remove these 1 snapshot(s), run with -apply to proceed")
+	require.Contains(t, out, "rm: maintenance would then reclaim")
 	require.NotContains(t, out, "rm: removal of") // no actual deletion
 }
+
+func TestRm_ListDeleted(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+	shortID := hex.EncodeToString(snap.Header.GetIndexShortID())
+
+	subcommand := &Rm{}
+	require.NoError(t, subcommand.Parse(ctx, []string{"-apply", shortID}))
+	_, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.NoError(t, repo.RebuildState())
+
+	bufOut.Reset()
+	subcommand = &Rm{}
+	require.NoError(t, subcommand.Parse(ctx, []string{"-list-deleted"}))
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), shortID)
+	require.Contains(t, bufOut.String(), "(recoverable, deleted ")
+
+	subcommand = &Rm{}
+	require.Error(t, subcommand.Parse(ctx, []string{"-list-deleted", shortID}))
+}
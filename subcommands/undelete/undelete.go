@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package undelete
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/lock"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/trash"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Undelete{} }, 0, "undelete")
+}
+
+type Undelete struct {
+	subcommands.SubcommandBase
+
+	Snapshots []string
+
+	repository *repository.Repository
+	lockID     objects.MAC
+}
+
+func (cmd *Undelete) CobraCommand() *cobra.Command {
+	return &cobra.Command{
+		Use: "undelete SNAPSHOT...",
+	}
+}
+
+func (cmd *Undelete) Parse(ctx *appcontext.AppContext, args []string) error {
+	rest, err := subcommands.ParseCobra(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) == 0 {
+		return fmt.Errorf("at least one snapshot is required")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Snapshots = rest
+
+	return nil
+}
+
+// resolve matches the identifiers against the deleted snapshots only.
+func resolve(repo *repository.Repository, prefixes []string) ([]objects.MAC, error) {
+	var deleted []objects.MAC
+	for snapshotID := range repo.ListDeletedSnapShots() {
+		deleted = append(deleted, snapshotID)
+	}
+
+	var ids []objects.MAC
+	for _, prefix := range prefixes {
+		var matches []objects.MAC
+		for _, snapshotID := range deleted {
+			if strings.HasPrefix(hex.EncodeToString(snapshotID[:]), strings.ToLower(prefix)) {
+				matches = append(matches, snapshotID)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("%s: no such deleted snapshot", prefix)
+		case 1:
+			ids = append(ids, matches[0])
+		default:
+			return nil, fmt.Errorf("%s: ambiguous snapshot identifier", prefix)
+		}
+	}
+	return ids, nil
+}
+
+// findCopy looks for a live snapshot that already brings the deleted one
+// back, so that undeleting twice, possibly from different machines, does
+// not produce duplicates.
+func findCopy(repo *repository.Repository, snap *snapshot.Snapshot) (objects.MAC, bool, error) {
+	for snapshotID, err := range repo.ListSnapshots() {
+		if err != nil {
+			return objects.MAC{}, false, err
+		}
+		live, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			continue
+		}
+		same := live.Header.Timestamp.Equal(snap.Header.Timestamp) &&
+			live.Header.Name == snap.Header.Name &&
+			live.Header.GetSource(0).VFS.Root == snap.Header.GetSource(0).VFS.Root
+		live.Close()
+		if same {
+			return snapshotID, true, nil
+		}
+	}
+	return objects.MAC{}, false, nil
+}
+
+// restore commits a copy of the header of the deleted snapshot under a new
+// identifier: the deletion of the original is recorded for good, but the
+// copy points to the very same data and takes none of its own.
+func restore(ctx *appcontext.AppContext, repo *repository.Repository, snap *snapshot.Snapshot) (objects.MAC, error) {
+	newID := objects.RandomMAC()
+
+	hdr := *snap.Header
+	hdr.Identifier = newID
+	serialized, err := hdr.Serialize()
+	if err != nil {
+		return objects.MAC{}, err
+	}
+
+	scanCache, err := ctx.GetCache().Scan(newID)
+	if err != nil {
+		return objects.MAC{}, err
+	}
+	defer scanCache.Close()
+
+	writer := repo.NewRepositoryWriter(scanCache, newID, repository.DefaultType, "")
+	if err := writer.PutBlob(resources.RT_SNAPSHOT, newID, serialized); err != nil {
+		return objects.MAC{}, err
+	}
+	writer.PackerManager.Wait()
+	if err := writer.CommitTransaction(newID); err != nil {
+		return objects.MAC{}, err
+	}
+	return newID, nil
+}
+
+func (cmd *Undelete) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	cmd.repository = repo
+	cmd.lockID = objects.RandomMAC()
+
+	ids, err := resolve(repo, cmd.Snapshots)
+	if err != nil {
+		return 1, fmt.Errorf("undelete: %w", err)
+	}
+
+	// maintenance must not sweep the packfiles while they are being
+	// referenced again
+	done, err := cmd.Lock()
+	if err != nil {
+		return 1, err
+	}
+	defer cmd.Unlock(done)
+
+	bin, err := trash.Load(repo)
+	if err != nil {
+		return 1, fmt.Errorf("undelete: %w", err)
+	}
+
+	errors := 0
+	for _, snapshotID := range ids {
+		if err := ctx.Err(); err != nil {
+			return 1, err
+		}
+
+		newID, err := cmd.undelete(ctx, snapshotID)
+		if err != nil {
+			ctx.GetLogger().Error("undelete: %x: %s", snapshotID[:4], err)
+			errors++
+			continue
+		}
+		bin.Restored(snapshotID, newID, time.Now())
+		ctx.GetLogger().Info("undelete: snapshot %x restored as %x", snapshotID[:4], newID[:4])
+	}
+
+	if err := bin.Save(); err != nil {
+		return 1, fmt.Errorf("undelete: %w", err)
+	}
+
+	if errors != 0 {
+		return 1, fmt.Errorf("failed to undelete %d snapshots", errors)
+	}
+	return 0, nil
+}
+
+func (cmd *Undelete) undelete(ctx *appcontext.AppContext, snapshotID objects.MAC) (objects.MAC, error) {
+	snap, err := snapshot.Load(cmd.repository, snapshotID)
+	if err != nil {
+		return objects.MAC{}, fmt.Errorf("no longer recoverable: %w", err)
+	}
+	defer snap.Close()
+
+	if copyID, ok, err := findCopy(cmd.repository, snap); err != nil {
+		return objects.MAC{}, err
+	} else if ok {
+		return objects.MAC{}, fmt.Errorf("already restored as %x", copyID[:4])
+	}
+
+	if err := trash.Recoverable(cmd.repository, snap); err != nil {
+		return objects.MAC{}, fmt.Errorf("no longer recoverable: %w", err)
+	}
+
+	return restore(ctx, cmd.repository, snap)
+}
+
+func (cmd *Undelete) Lock() (*lock.Lease, error) {
+	return lock.Acquire(cmd.repository, cmd.lockID)
+}
+
+func (cmd *Undelete) Unlock(lease *lock.Lease) {
+	lease.Release()
+}
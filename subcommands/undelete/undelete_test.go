@@ -0,0 +1,87 @@
+package undelete
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndelete(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/a.txt", 0644, "hello"),
+	})
+	snap.Close()
+	shortID := hex.EncodeToString(snap.Header.GetIndexShortID())
+
+	require.NoError(t, repo.DeleteSnapshot(snap.Header.Identifier))
+	require.NoError(t, repo.RebuildState())
+
+	cmd := &Undelete{}
+	require.NoError(t, cmd.Parse(ctx, []string{shortID}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "undelete: snapshot "+shortID+" restored as")
+
+	require.NoError(t, repo.RebuildState())
+	var live []objects.MAC
+	for snapshotID, err := range repo.ListSnapshots() {
+		require.NoError(t, err)
+		live = append(live, snapshotID)
+	}
+	require.Len(t, live, 1)
+	require.NotEqual(t, snap.Header.Identifier, live[0])
+
+	restored, err := snapshot.Load(repo, live[0])
+	require.NoError(t, err)
+	defer restored.Close()
+	require.True(t, snap.Header.Timestamp.Equal(restored.Header.Timestamp))
+
+	fs, err := restored.Filesystem()
+	require.NoError(t, err)
+	_, err = fs.GetEntry("/subdir/a.txt")
+	require.NoError(t, err)
+
+	// undeleting twice would only produce a duplicate
+	cmd = &Undelete{}
+	require.NoError(t, cmd.Parse(ctx, []string{shortID}))
+	status, err = cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+	require.Contains(t, bufOut.String(), "already restored as")
+}
+
+func TestUndeleteNotDeleted(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "hello"),
+	})
+	snap.Close()
+
+	cmd := &Undelete{}
+	require.NoError(t, cmd.Parse(ctx, []string{hex.EncodeToString(snap.Header.GetIndexShortID())}))
+	status, err := cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+	require.Contains(t, err.Error(), "no such deleted snapshot")
+}
+
+func TestUndeleteParse(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	cmd := &Undelete{}
+	require.Error(t, cmd.Parse(ctx, nil))
+}
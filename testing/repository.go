@@ -40,6 +40,7 @@ func GenerateRepository(t *testing.T, bufout *bytes.Buffer, buferr *bytes.Buffer
 	ctx := appcontext.NewAppContext()
 	ctx.SetCookies(cookies)
 	ctx.CacheDir = tmpCacheDir
+	ctx.ConfigDir = t.TempDir()
 
 	ctx.Client = "plakar-test/1.0.0"
 
@@ -130,6 +131,7 @@ func GenerateRepositoryWithoutConfig(t *testing.T, bufout *bytes.Buffer, buferr
 	ctx.Client = "plakar-test/1.0.0"
 	ctx.MaxConcurrency = 1
 	ctx.CacheDir = tmpCacheDir
+	ctx.ConfigDir = t.TempDir()
 
 	// create a storage
 	r, err := bfs.NewStore(ctx, "fs", map[string]string{"location": tmpRepoDir})
@@ -7,6 +7,7 @@ import (
 	"path"
 	"path/filepath"
 	"testing"
+	"time"
 
 	_ "github.com/PlakarKorp/integrations/fs/importer"
 
@@ -75,9 +76,10 @@ func (m *MockFile) ScanResult() *connectors.Record {
 }
 
 type testingOptions struct {
-	name     string
-	excludes []string
-	gen      func(chan<- *connectors.Record)
+	name      string
+	excludes  []string
+	gen       func(chan<- *connectors.Record)
+	timestamp time.Time
 }
 
 func newTestingOptions() *testingOptions {
@@ -100,6 +102,13 @@ func WithExcludes(excludes []string) TestingOptions {
 	}
 }
 
+// WithTimestamp dates the snapshot, which is otherwise taken now.
+func WithTimestamp(ts time.Time) TestingOptions {
+	return func(o *testingOptions) {
+		o.timestamp = ts
+	}
+}
+
 func GenerateFiles(t *testing.T, files []MockFile) string {
 	tmpBackupDir, err := os.MkdirTemp("", "tmp_to_backup")
 	require.NoError(t, err)
@@ -159,6 +168,10 @@ func GenerateSnapshot(t *testing.T, repo *repository.Repository, files []MockFil
 	err = builder.Backup(s)
 	require.NoError(t, err)
 
+	if !o.timestamp.IsZero() {
+		builder.Header.Timestamp = o.timestamp
+	}
+
 	err = builder.Commit()
 	require.NoError(t, err)
 
@@ -0,0 +1,224 @@
+// Package trash keeps track of when snapshots were deleted and tells
+// whether a deleted snapshot can still be brought back.
+//
+// A deleted snapshot remains recoverable until maintenance sweeps the
+// packfiles it relies on.  The store does not record when a snapshot was
+// deleted, so deletion dates are stored in the repository alongside the
+// holds, one metadata record per snapshot, by rm and prune when they
+// delete a snapshot and by maintenance when it first notices a deletion
+// made by an older client.
+package trash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+)
+
+const VERSION = "1.0.0"
+
+// metadataKind names the repository metadata records of the trash.
+const metadataKind = "trash"
+
+type onDisk struct {
+	Version  string    `json:"version"`
+	Deleted  time.Time `json:"deleted"`
+	Restored string    `json:"restored,omitempty"`
+}
+
+// Entry is what is known of a deleted snapshot.
+type Entry struct {
+	Deleted  time.Time
+	Restored objects.MAC
+}
+
+type Trash struct {
+	repo    *repository.Repository
+	entries map[objects.MAC]*Entry
+
+	// what Save has to write and to remove
+	dirty     map[objects.MAC]struct{}
+	forgotten map[objects.MAC]struct{}
+}
+
+func decodeMAC(s string) (objects.MAC, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) != len(objects.MAC{}) {
+		return objects.MAC{}, fmt.Errorf("invalid snapshot identifier %q", s)
+	}
+	return objects.MAC(data), nil
+}
+
+// Load reads the trash of the repository.
+func Load(repo *repository.Repository) (*Trash, error) {
+	t := &Trash{
+		repo:      repo,
+		entries:   make(map[objects.MAC]*Entry),
+		dirty:     make(map[objects.MAC]struct{}),
+		forgotten: make(map[objects.MAC]struct{}),
+	}
+
+	ids, err := repo.ListMetadata(metadataKind)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the trash: %w", err)
+	}
+	for _, id := range ids {
+		entry, err := get(repo, id)
+		if err != nil {
+			return nil, fmt.Errorf("trash entry of %x: %w", id[:4], err)
+		}
+		t.entries[id] = entry
+	}
+	return t, nil
+}
+
+func get(repo *repository.Repository, id objects.MAC) (*Entry, error) {
+	rd, err := repo.GetMetadata(metadataKind, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	var disk onDisk
+	if err := json.NewDecoder(rd).Decode(&disk); err != nil {
+		return nil, fmt.Errorf("could not decode: %w", err)
+	}
+	if disk.Version != VERSION {
+		return nil, fmt.Errorf("unsupported version %q", disk.Version)
+	}
+
+	entry := &Entry{Deleted: disk.Deleted}
+	if disk.Restored != "" {
+		if entry.Restored, err = decodeMAC(disk.Restored); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+// Save stores the entries changed since Load and removes the forgotten
+// ones.
+func (t *Trash) Save() error {
+	for id := range t.dirty {
+		e := t.entries[id]
+		disk := onDisk{Version: VERSION, Deleted: e.Deleted}
+		if e.Restored != (objects.MAC{}) {
+			disk.Restored = hex.EncodeToString(e.Restored[:])
+		}
+		data, err := json.Marshal(&disk)
+		if err != nil {
+			return err
+		}
+		if _, err := t.repo.PutMetadata(metadataKind, id, bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("could not store trash entry of %x: %w", id[:4], err)
+		}
+		delete(t.dirty, id)
+	}
+	for id := range t.forgotten {
+		if err := t.repo.DeleteMetadata(metadataKind, id); err != nil {
+			return fmt.Errorf("could not remove trash entry of %x: %w", id[:4], err)
+		}
+		delete(t.forgotten, id)
+	}
+	return nil
+}
+
+// Deleted records that the snapshot was deleted at the given date, unless
+// an earlier deletion is known already, and returns the recorded date.
+func (t *Trash) Deleted(id objects.MAC, when time.Time) time.Time {
+	e, ok := t.entries[id]
+	if !ok {
+		e = &Entry{Deleted: when}
+		t.entries[id] = e
+		t.dirty[id] = struct{}{}
+	} else if when.Before(e.Deleted) {
+		e.Deleted = when
+		t.dirty[id] = struct{}{}
+	}
+	delete(t.forgotten, id)
+	return e.Deleted
+}
+
+// Record loads the trash of the repository, records that the snapshots
+// were deleted at the given date and saves it.
+func Record(repo *repository.Repository, ids []objects.MAC, when time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	t, err := Load(repo)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		t.Deleted(id, when)
+	}
+	return t.Save()
+}
+
+// Get returns what is known of a deleted snapshot.
+func (t *Trash) Get(id objects.MAC) (Entry, bool) {
+	e, ok := t.entries[id]
+	if !ok {
+		return Entry{}, false
+	}
+	return *e, true
+}
+
+// Restored records that the snapshot was brought back as another one.
+func (t *Trash) Restored(id, as objects.MAC, when time.Time) {
+	t.Deleted(id, when)
+	t.entries[id].Restored = as
+	t.dirty[id] = struct{}{}
+}
+
+// Keep forgotten the snapshots for which keep returns false.
+func (t *Trash) Keep(keep func(objects.MAC) bool) {
+	for id := range t.entries {
+		if !keep(id) {
+			delete(t.entries, id)
+			delete(t.dirty, id)
+			t.forgotten[id] = struct{}{}
+		}
+	}
+}
+
+// Recoverable fails if a packfile the deleted snapshot relies on has been
+// swept already.  Packfiles coloured for deletion but not swept yet are
+// fine: maintenance uncolours them once the snapshot is back.
+func Recoverable(repo *repository.Repository, snap *snapshot.Snapshot) error {
+	stored, err := repo.GetPackfiles()
+	if err != nil {
+		return fmt.Errorf("could not list packfiles: %w", err)
+	}
+	inStorage := make(map[objects.MAC]struct{}, len(stored))
+	for _, mac := range stored {
+		inStorage[mac] = struct{}{}
+	}
+	inState := make(map[objects.MAC]struct{})
+	for mac := range repo.ListPackfiles() {
+		inState[mac] = struct{}{}
+	}
+
+	iter, err := snap.ListPackfiles()
+	if err != nil {
+		return err
+	}
+	for packfile, err := range iter {
+		if err != nil {
+			return err
+		}
+		if _, ok := inState[packfile]; !ok {
+			return fmt.Errorf("packfile %x was swept", packfile[:4])
+		}
+		if _, ok := inStorage[packfile]; !ok {
+			return fmt.Errorf("packfile %x is missing from the store", packfile[:4])
+		}
+	}
+	return nil
+}
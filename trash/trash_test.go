@@ -0,0 +1,52 @@
+package trash
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrashRoundTrip(t *testing.T) {
+	repo, _ := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	bin, err := Load(repo)
+	require.NoError(t, err)
+	_, ok := bin.Get(objects.MAC{1})
+	require.False(t, ok)
+
+	a, b, c := objects.MAC{1}, objects.MAC{2}, objects.MAC{3}
+	require.Equal(t, now, bin.Deleted(a, now))
+	// the earliest known deletion date wins
+	require.Equal(t, now, bin.Deleted(a, now.Add(time.Hour)))
+	require.Equal(t, now.Add(-time.Hour), bin.Deleted(a, now.Add(-time.Hour)))
+	bin.Restored(b, c, now)
+	require.NoError(t, bin.Save())
+
+	// the trash lives in the repository, any other client reads it back
+	bin, err = Load(repo)
+	require.NoError(t, err)
+	e, ok := bin.Get(a)
+	require.True(t, ok)
+	require.True(t, now.Add(-time.Hour).Equal(e.Deleted))
+	require.Equal(t, objects.MAC{}, e.Restored)
+	e, ok = bin.Get(b)
+	require.True(t, ok)
+	require.Equal(t, c, e.Restored)
+
+	bin.Keep(func(id objects.MAC) bool { return id == b })
+	_, ok = bin.Get(a)
+	require.False(t, ok)
+	require.NoError(t, bin.Save())
+
+	bin, err = Load(repo)
+	require.NoError(t, err)
+	_, ok = bin.Get(a)
+	require.False(t, ok)
+	_, ok = bin.Get(b)
+	require.True(t, ok)
+}
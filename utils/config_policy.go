@@ -6,17 +6,63 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/PlakarKorp/go-human2duration"
 	"github.com/PlakarKorp/kloset/locate"
 	"go.yaml.in/yaml/v3"
 )
 
 type policiesConfig struct {
-	Version  string                           `yaml:"version"`
-	Policies map[string]*locate.LocateOptions `yaml:"policies"`
+	Version   string                           `yaml:"version"`
+	Policies  map[string]*locate.LocateOptions `yaml:"policies"`
+	Retention map[string]*RetentionRules       `yaml:"retention,omitempty"`
+}
+
+// RetentionRules complement the periods of a policy with rules based on
+// recency and tags, which only ever keep snapshots the periods would
+// delete.
+type RetentionRules struct {
+	KeepWithin Duration `yaml:"keep-within,omitempty" json:"keep-within,omitempty"`
+	KeepLast   int      `yaml:"keep-last,omitempty" json:"keep-last,omitempty"`
+	KeepTagged []string `yaml:"keep-tagged,omitempty" json:"keep-tagged,omitempty"`
+	MinKeep    int      `yaml:"min-keep,omitempty" json:"min-keep,omitempty"`
+
+	// GroupBy splits the snapshots into groups to which the periods and
+	// the rules apply independently.
+	GroupBy GroupBy `yaml:"group-by,omitempty" json:"group-by,omitempty"`
+}
+
+// Empty tells whether there is no retention rule, the grouping aside.
+func (r *RetentionRules) Empty() bool {
+	return r.KeepWithin == "" && r.KeepLast == 0 && len(r.KeepTagged) == 0 && r.MinKeep == 0
+}
+
+// GroupBy is the list of snapshot attributes that make up a group.
+type GroupBy []string
+
+var groupByKeys = []string{"origin", "root", "name", "tags"}
+
+func (g GroupBy) Validate() error {
+	for _, key := range g {
+		if !slices.Contains(groupByKeys, key) {
+			return fmt.Errorf("invalid group-by key %q, must be one of %s", key, strings.Join(groupByKeys, ", "))
+		}
+	}
+	return nil
+}
+
+// Duration is a duration as the user wrote it, such as 30d.
+type Duration string
+
+func (d Duration) Duration() (time.Duration, error) {
+	if d == "" {
+		return 0, nil
+	}
+	return human2duration.ParseDuration(string(d))
 }
 
 func (c *policiesConfig) Has(name string) bool {
@@ -49,6 +95,39 @@ func (c *policiesConfig) setTime(value string, p *time.Time) error {
 	return nil
 }
 
+func (c *policiesConfig) setDuration(value string, p *Duration) error {
+	d, err := Duration(value).Duration()
+	if err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+	if d < 0 {
+		return fmt.Errorf("negative value")
+	}
+	*p = Duration(value)
+	return nil
+}
+
+func (c *policiesConfig) setGroupBy(value string, p *GroupBy) error {
+	g := GroupBy(strings.Split(value, ","))
+	if err := g.Validate(); err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+	*p = g
+	return nil
+}
+
+func (c *policiesConfig) retention(name string) *RetentionRules {
+	if c.Retention == nil {
+		c.Retention = make(map[string]*RetentionRules)
+	}
+	r, ok := c.Retention[name]
+	if !ok {
+		r = &RetentionRules{}
+		c.Retention[name] = r
+	}
+	return r
+}
+
 func (c *policiesConfig) setStringList(value string, p *[]string) error {
 	*p = strings.Split(value, ",")
 	return nil
@@ -138,6 +217,17 @@ func (c *policiesConfig) locateField(name string, key string) (any, error) {
 	case "per-sunday":
 		return &p.Periods.Sunday.Cap, nil
 
+	case "keep-within":
+		return &c.retention(name).KeepWithin, nil
+	case "keep-last":
+		return &c.retention(name).KeepLast, nil
+	case "keep-tagged":
+		return &c.retention(name).KeepTagged, nil
+	case "min-keep":
+		return &c.retention(name).MinKeep, nil
+	case "group-by":
+		return &c.retention(name).GroupBy, nil
+
 	default:
 		return nil, fmt.Errorf("invalid key")
 	}
@@ -154,6 +244,10 @@ func (c *policiesConfig) Set(name string, key string, value string) error {
 		return c.setInt(value, p)
 	case *time.Time:
 		return c.setTime(value, p)
+	case *Duration:
+		return c.setDuration(value, p)
+	case *GroupBy:
+		return c.setGroupBy(value, p)
 	case *string:
 		*p = value
 		return nil
@@ -182,6 +276,10 @@ func (c *policiesConfig) Unset(name string, key string) error {
 		*p = 0
 	case *time.Time:
 		*p = time.Time{}
+	case *Duration:
+		*p = ""
+	case *GroupBy:
+		*p = nil
 	case *string:
 		*p = ""
 	case *[]string:
@@ -197,6 +295,7 @@ func (c *policiesConfig) Unset(name string, key string) error {
 
 func (c *policiesConfig) Remove(name string) {
 	delete(c.Policies, name)
+	delete(c.Retention, name)
 }
 
 func (c *policiesConfig) SaveToFile(filename string) error {
@@ -229,12 +328,17 @@ func (c *policiesConfig) Dump(w io.Writer, format string, names []string) error
 		if !c.Has(name) {
 			return fmt.Errorf("entry %q not found", name)
 		}
+		var entry any
 		var err error
 		switch format {
 		case "json":
-			err = json.NewEncoder(w).Encode(map[string]*locate.LocateOptions{name: c.Policies[name]})
+			if entry, err = c.dumpable(name, json.Marshal, json.Unmarshal); err == nil {
+				err = json.NewEncoder(w).Encode(map[string]any{name: entry})
+			}
 		case "yaml":
-			err = yaml.NewEncoder(w).Encode(map[string]*locate.LocateOptions{name: c.Policies[name]})
+			if entry, err = c.dumpable(name, yaml.Marshal, yaml.Unmarshal); err == nil {
+				err = yaml.NewEncoder(w).Encode(map[string]any{name: entry})
+			}
 		default:
 			return fmt.Errorf("unknown format %q", format)
 		}
@@ -246,10 +350,31 @@ func (c *policiesConfig) Dump(w io.Writer, format string, names []string) error
 	return nil
 }
 
+// dumpable returns the policy as it is dumped, its retention rules along
+// with its locate options.
+func (c *policiesConfig) dumpable(name string, marshal func(any) ([]byte, error), unmarshal func([]byte, any) error) (any, error) {
+	r, ok := c.Retention[name]
+	if !ok || (r.Empty() && len(r.GroupBy) == 0) {
+		return c.Policies[name], nil
+	}
+
+	data, err := marshal(c.Policies[name])
+	if err != nil {
+		return nil, err
+	}
+	entry := make(map[string]any)
+	if err := unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	entry["retention"] = r
+	return entry, nil
+}
+
 func LoadPolicyConfigFile(filename string) (*policiesConfig, error) {
 	var cfg policiesConfig
 	cfg.Version = "v1.0.0"
 	cfg.Policies = make(map[string]*locate.LocateOptions)
+	cfg.Retention = make(map[string]*RetentionRules)
 
 	rd, err := os.Open(filename)
 	if err != nil {
@@ -274,3 +399,14 @@ func (c *policiesConfig) ApplyConfig(name string, po *locate.LocateOptions) {
 	}
 	*po = *p
 }
+
+// ApplyRetention copies the retention rules of the policy, if any.
+func (c *policiesConfig) ApplyRetention(name string, r *RetentionRules) {
+	p, ok := c.Retention[name]
+	if !ok {
+		return
+	}
+	*r = *p
+	r.KeepTagged = slices.Clone(p.KeepTagged)
+	r.GroupBy = slices.Clone(p.GroupBy)
+}
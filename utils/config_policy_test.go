@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/PlakarKorp/kloset/locate"
 )
@@ -388,3 +389,94 @@ func TestPoliciesLocateFieldInvalidKey(t *testing.T) {
 		t.Fatal("Unset with invalid key should error")
 	}
 }
+
+func TestPoliciesRetentionRules(t *testing.T) {
+	c := newTestPolicies()
+	c.Add("p")
+	for kv, want := range map[[2]string]bool{
+		{"keep-within", "30d"}:   true,
+		{"keep-within", "soon"}:  false,
+		{"keep-last", "5"}:       true,
+		{"keep-last", "-1"}:      false,
+		{"keep-tagged", "a,b"}:   true,
+		{"min-keep", "2"}:        true,
+		{"min-keep", "two"}:      false,
+		{"keep-within", "1w2d"}:  true,
+		{"keep-last", "not-int"}: false,
+	} {
+		err := c.Set("p", kv[0], kv[1])
+		if want && err != nil {
+			t.Fatalf("Set %s=%s: %v", kv[0], kv[1], err)
+		}
+		if !want && err == nil {
+			t.Fatalf("Set %s=%s: expected an error", kv[0], kv[1])
+		}
+	}
+
+	var r RetentionRules
+	c.ApplyRetention("p", &r)
+	if r.KeepWithin != "1w2d" || r.KeepLast != 5 || len(r.KeepTagged) != 2 || r.MinKeep != 2 {
+		t.Fatalf("unexpected retention rules %+v", r)
+	}
+	if d, err := r.KeepWithin.Duration(); err != nil || d != 9*24*time.Hour {
+		t.Fatalf("KeepWithin.Duration() = %v, %v", d, err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Dump(&buf, "yaml", []string{"p"}); err != nil {
+		t.Fatalf("Dump yaml: %v", err)
+	}
+	if !strings.Contains(buf.String(), "keep-within: 1w2d") {
+		t.Fatalf("dump did not contain the retention rules: %q", buf.String())
+	}
+
+	for _, k := range []string{"keep-within", "keep-last", "keep-tagged", "min-keep"} {
+		if err := c.Unset("p", k); err != nil {
+			t.Fatalf("Unset %s: %v", k, err)
+		}
+	}
+	if !c.Retention["p"].Empty() {
+		t.Fatalf("expected empty retention rules, got %+v", c.Retention["p"])
+	}
+
+	c.Remove("p")
+	if _, ok := c.Retention["p"]; ok {
+		t.Fatal("Remove must drop the retention rules too")
+	}
+}
+
+func TestPoliciesGroupBy(t *testing.T) {
+	c := newTestPolicies()
+	c.Add("p")
+
+	if err := c.Set("p", "group-by", "origin,colour"); err == nil {
+		t.Fatal("Set group-by with an unknown key should error")
+	}
+	if err := c.Set("p", "group-by", "origin,root,name,tags"); err != nil {
+		t.Fatalf("Set group-by: %v", err)
+	}
+
+	var r RetentionRules
+	c.ApplyRetention("p", &r)
+	if len(r.GroupBy) != 4 || r.GroupBy[3] != "tags" {
+		t.Fatalf("unexpected group-by %v", r.GroupBy)
+	}
+	if !r.Empty() {
+		t.Fatal("a group-by alone is not a retention rule")
+	}
+
+	var buf bytes.Buffer
+	if err := c.Dump(&buf, "json", []string{"p"}); err != nil {
+		t.Fatalf("Dump json: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"group-by":["origin","root","name","tags"]`) {
+		t.Fatalf("dump did not contain the grouping: %q", buf.String())
+	}
+
+	if err := c.Unset("p", "group-by"); err != nil {
+		t.Fatalf("Unset group-by: %v", err)
+	}
+	if c.Retention["p"].GroupBy != nil {
+		t.Fatalf("expected no grouping, got %v", c.Retention["p"].GroupBy)
+	}
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// SizeFilter is a find(1)-like size: "+100M" is more than 100MiB, "-1k"
+// less than 1KiB and "512" exactly 512 bytes.
+type SizeFilter struct {
+	op   byte
+	size int64
+}
+
+func ParseSizeFilter(value string) (*SizeFilter, error) {
+	f := &SizeFilter{op: '='}
+	if value != "" && (value[0] == '+' || value[0] == '-') {
+		f.op = value[0]
+		value = value[1:]
+	}
+
+	mult := int64(1)
+	if n := len(value); n != 0 {
+		switch value[n-1] {
+		case 'k', 'K':
+			mult = 1 << 10
+		case 'm', 'M':
+			mult = 1 << 20
+		case 'g', 'G':
+			mult = 1 << 30
+		case 't', 'T':
+			mult = 1 << 40
+		}
+		if mult != 1 {
+			value = value[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid size %q", value)
+	}
+	f.size = n * mult
+	return f, nil
+}
+
+func (f *SizeFilter) Match(size int64) bool {
+	switch f.op {
+	case '+':
+		return size > f.size
+	case '-':
+		return size < f.size
+	default:
+		return size == f.size
+	}
+}
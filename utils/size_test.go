@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSizeFilter(t *testing.T) {
+	f, err := ParseSizeFilter("+100M")
+	require.NoError(t, err)
+	require.True(t, f.Match(100<<20+1))
+	require.False(t, f.Match(100<<20))
+
+	f, err = ParseSizeFilter("-1k")
+	require.NoError(t, err)
+	require.True(t, f.Match(1023))
+	require.False(t, f.Match(1024))
+
+	f, err = ParseSizeFilter("512")
+	require.NoError(t, err)
+	require.True(t, f.Match(512))
+	require.False(t, f.Match(511))
+
+	for _, value := range []string{"", "+", "12X", "-1.5G"} {
+		_, err := ParseSizeFilter(value)
+		require.Error(t, err, value)
+	}
+}
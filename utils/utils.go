@@ -405,3 +405,20 @@ func ValidateEmail(email string) (string, error) {
 	}
 	return mail.Address, nil
 }
+
+// BinarySniffLen is how much of a file IsBinary wants to look at.
+const BinarySniffLen = 512
+
+// IsBinary tells whether the head of a file looks like binary data, that
+// is whether it holds control characters other than the usual whitespace.
+func IsBinary(head []byte) bool {
+	for _, ch := range head {
+		if ch < ' ' && ch != '\t' && ch != '\n' && ch != '\r' && ch != '\f' {
+			return true
+		}
+		if ch == 0x7f {
+			return true
+		}
+	}
+	return false
+}